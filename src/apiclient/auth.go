@@ -0,0 +1,73 @@
+package apiclient
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/onecombine/onecombine-msg-validator/src/algorithms"
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+const API_CLIENT_AUTH string = "API_CLIENT_AUTH"
+const API_CLIENT_API_KEY string = "API_CLIENT_API_KEY"
+const API_CLIENT_SECRET string = "API_CLIENT_SECRET"
+const API_CLIENT_BEARER_TOKEN string = "API_CLIENT_BEARER_TOKEN"
+
+const (
+	AUTH_HMAC   = "hmac"
+	AUTH_BEARER = "bearer"
+)
+
+// Authenticator adds the credentials of the service to an outgoing request.
+type Authenticator interface {
+	Authenticate(request *http.Request, body []byte) error
+}
+
+type hmacAuthenticator struct {
+	apiKey string
+	hmac   *algorithms.OneCombineHmac
+}
+
+// NewHmacAuthenticator signs the requests like the partners sign theirs, with the X-Api-Key and
+// Signature headers. Requests without body sign their path.
+func NewHmacAuthenticator(apiKey, secret string) Authenticator {
+	return &hmacAuthenticator{
+		apiKey: apiKey,
+		hmac:   algorithms.NewOneCombineHmac(secret, 0).(*algorithms.OneCombineHmac),
+	}
+}
+
+func (a *hmacAuthenticator) Authenticate(request *http.Request, body []byte) error {
+	data := string(body)
+	if len(body) == 0 {
+		data = request.URL.RequestURI()
+	}
+	request.Header.Set("X-Api-Key", a.apiKey)
+	request.Header.Set("Signature", a.hmac.Sign(data))
+	return nil
+}
+
+type bearerAuthenticator struct {
+	token string
+}
+
+func NewBearerAuthenticator(token string) Authenticator {
+	return &bearerAuthenticator{token: token}
+}
+
+func (a *bearerAuthenticator) Authenticate(request *http.Request, body []byte) error {
+	request.Header.Set("Authorization", "Bearer "+a.token)
+	return nil
+}
+
+// NewAuthenticatorFromEnv returns the authenticator selected by API_CLIENT_AUTH, nil when unset.
+func NewAuthenticatorFromEnv() Authenticator {
+	switch strings.ToLower(utils.GetEnv(API_CLIENT_AUTH, "")) {
+	case AUTH_HMAC:
+		return NewHmacAuthenticator(utils.GetEnv(API_CLIENT_API_KEY, ""), utils.GetEnv(API_CLIENT_SECRET, ""))
+	case AUTH_BEARER:
+		return NewBearerAuthenticator(utils.GetEnv(API_CLIENT_BEARER_TOKEN, ""))
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,222 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+const API_CLIENT_TIMEOUT string = "API_CLIENT_TIMEOUT"
+const API_CLIENT_MAX_RETRIES string = "API_CLIENT_MAX_RETRIES"
+const API_CLIENT_RETRY_BACKOFF string = "API_CLIENT_RETRY_BACKOFF"
+
+// Error bodies are truncated to this size in StatusError
+const MAX_ERROR_BODY = 512
+
+// ErrNotModified is returned by GetJSONIfModified when the resource did not change since the
+// last successful call.
+var ErrNotModified = errors.New("resource not modified")
+var ErrDecode = errors.New("unable to decode response")
+
+// StatusError is returned for any response but 200 and 304.
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s, unexpected status %d: %s", e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+// Temporary reports whether the request may succeed when retried.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+type Config struct {
+	BaseUrl string
+	// Timeout of each attempt
+	Timeout    time.Duration
+	MaxRetries int
+	// Upper bound of the first retry delay, doubled on every retry, the delay is drawn at random
+	// below it
+	Backoff time.Duration
+	// Authenticator of the requests, nil sends them unauthenticated
+	Auth Authenticator
+}
+
+// NewConfigFromEnv reads the timeout, retries and authentication of the internal API clients.
+func NewConfigFromEnv(baseUrl string) Config {
+	timeout, err := time.ParseDuration(utils.GetEnv(API_CLIENT_TIMEOUT, "10s"))
+	if err != nil {
+		timeout = 10 * time.Second
+	}
+	retries, err := strconv.Atoi(utils.GetEnv(API_CLIENT_MAX_RETRIES, "2"))
+	if err != nil || retries < 0 {
+		retries = 2
+	}
+	backoff, err := time.ParseDuration(utils.GetEnv(API_CLIENT_RETRY_BACKOFF, "200ms"))
+	if err != nil {
+		backoff = 200 * time.Millisecond
+	}
+
+	return Config{
+		BaseUrl:    strings.TrimSuffix(baseUrl, "/"),
+		Timeout:    timeout,
+		MaxRetries: retries,
+		Backoff:    backoff,
+		Auth:       NewAuthenticatorFromEnv(),
+	}
+}
+
+type validators struct {
+	etag         string
+	lastModified string
+}
+
+// Client calls the internal JSON APIs, e.g. the profile API.
+type Client struct {
+	cfg  Config
+	http *http.Client
+
+	mu         sync.Mutex
+	validators map[string]validators
+}
+
+func NewClient(cfg Config) *Client {
+	return &Client{
+		cfg:        cfg,
+		http:       &http.Client{},
+		validators: make(map[string]validators),
+	}
+}
+
+// GetJSON fetches path and decodes the response into v.
+func (c *Client) GetJSON(ctx context.Context, path string, v any) error {
+	return c.get(ctx, path, v, false)
+}
+
+// GetJSONIfModified is a conditional GetJSON, it returns ErrNotModified and leaves v untouched
+// when path did not change since the last successful call.
+func (c *Client) GetJSONIfModified(ctx context.Context, path string, v any) error {
+	return c.get(ctx, path, v, true)
+}
+
+func (c *Client) get(ctx context.Context, path string, v any, conditional bool) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = c.attempt(ctx, path, v, conditional)
+		if err == nil || attempt >= c.cfg.MaxRetries || !retryable(err) {
+			return err
+		}
+
+		// Full jitter, concurrent instances do not retry in step
+		delay := time.Duration(0)
+		if limit := c.cfg.Backoff << attempt; limit > 0 {
+			delay = time.Duration(rand.Int63n(int64(limit)))
+		}
+		fmt.Printf("Retry GET %s (attempt: %d) in %s, error: %v\n", path, attempt+1, delay, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, path string, v any, conditional bool) error {
+	if c.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+		defer cancel()
+	}
+
+	url := c.cfg.BaseUrl + path
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", utils.CONTENT_TYPE_JSON)
+	if requestId := utils.RequestIdFromContext(ctx); requestId != "" {
+		request.Header.Set(utils.REQUEST_ID_HEADER, requestId)
+	}
+	if conditional {
+		c.mu.Lock()
+		cached := c.validators[path]
+		c.mu.Unlock()
+		if cached.etag != "" {
+			request.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			request.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+	if c.cfg.Auth != nil {
+		if err := c.cfg.Auth.Authenticate(request, nil); err != nil {
+			return err
+		}
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		if conditional {
+			return ErrNotModified
+		}
+		fallthrough
+	default:
+		body, _ := io.ReadAll(io.LimitReader(response.Body, MAX_ERROR_BODY))
+		return &StatusError{Method: http.MethodGet, URL: url, StatusCode: response.StatusCode, Body: string(body)}
+	}
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%w from GET %s: %w", ErrDecode, url, err)
+	}
+
+	// Validators are only kept once the response has been decoded
+	c.mu.Lock()
+	c.validators[path] = validators{
+		etag:         response.Header.Get("ETag"),
+		lastModified: response.Header.Get("Last-Modified"),
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// Forget drops the validators of path, the next conditional call fetches it in full.
+func (c *Client) Forget(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.validators, path)
+}
+
+func retryable(err error) bool {
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.Temporary()
+	}
+	// Decoding a complete response again gives the same result
+	return !errors.Is(err, ErrDecode) && !errors.Is(err, context.Canceled)
+}
@@ -0,0 +1,103 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/onecombine/onecombine-msg-validator/src/algorithms"
+)
+
+func newTestClient(url string, auth Authenticator) *Client {
+	return NewClient(Config{BaseUrl: url, Timeout: time.Second, MaxRetries: 2, Backoff: time.Millisecond, Auth: auth})
+}
+
+func TestGetJSONStatus(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		switch r.URL.Path {
+		case "/flaky":
+			if n < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`[{"pair":"USDTHB"}]`))
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("no such list"))
+		case "/html":
+			w.Write([]byte("<html>maintenance</html>"))
+		}
+	}))
+	defer server.Close()
+	client := newTestClient(server.URL, nil)
+
+	var list []map[string]string
+	assert.Nil(t, client.GetJSON(context.Background(), "/flaky", &list), "Retried on 503")
+	assert.Equal(t, "USDTHB", list[0]["pair"])
+	assert.Equal(t, int32(3), calls.Load())
+
+	calls.Store(0)
+	err := client.GetJSON(context.Background(), "/missing", &list)
+	var status *StatusError
+	assert.ErrorAs(t, err, &status)
+	assert.Equal(t, http.StatusNotFound, status.StatusCode)
+	assert.Equal(t, "no such list", status.Body)
+	assert.Equal(t, int32(1), calls.Load(), "Client errors are not retried")
+
+	calls.Store(0)
+	assert.ErrorIs(t, client.GetJSON(context.Background(), "/html", &list), ErrDecode)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestGetJSONIfModified(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`["100090"]`))
+	}))
+	defer server.Close()
+	client := newTestClient(server.URL, nil)
+
+	var ids []string
+	assert.Nil(t, client.GetJSONIfModified(context.Background(), "/ids", &ids))
+	assert.Equal(t, []string{"100090"}, ids)
+	assert.ErrorIs(t, client.GetJSONIfModified(context.Background(), "/ids", &ids), ErrNotModified)
+
+	// Unconditional calls always fetch
+	assert.Nil(t, client.GetJSON(context.Background(), "/ids", &ids))
+	client.Forget("/ids")
+	assert.Nil(t, client.GetJSONIfModified(context.Background(), "/ids", &ids))
+}
+
+func TestAuthenticators(t *testing.T) {
+	var headers http.Header
+	var uri string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Clone()
+		uri = r.URL.RequestURI()
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	var list []string
+	assert.Nil(t, newTestClient(server.URL, NewBearerAuthenticator("TOKEN")).GetJSON(context.Background(), "/v1/profile/acquirers", &list))
+	assert.Equal(t, "Bearer TOKEN", headers.Get("Authorization"))
+
+	assert.Nil(t, newTestClient(server.URL, NewHmacAuthenticator("KEY-1", "secret")).GetJSON(context.Background(), "/v1/profile/acquirers", &list))
+	assert.Equal(t, "KEY-1", headers.Get("X-Api-Key"))
+	signature := headers.Get("Signature")
+	assert.Equal(t, true, strings.HasPrefix(signature, "t="))
+	validator := algorithms.NewOneCombineHmac("secret", 60).(*algorithms.OneCombineHmac)
+	assert.Equal(t, true, validator.Verify([]byte(uri), signature), "Path signed")
+}
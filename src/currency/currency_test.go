@@ -0,0 +1,28 @@
+package currency
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookup(t *testing.T) {
+	thb, ok := Lookup("THB")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, Currency{Code: "THB", Numeric: "764", MinorUnits: 2}, thb)
+
+	jpy, _ := Lookup("JPY")
+	assert.Equal(t, 0, jpy.MinorUnits)
+	kwd, _ := Lookup("KWD")
+	assert.Equal(t, 3, kwd.MinorUnits)
+
+	assert.Equal(t, false, IsValid("thb"), "Codes are upper case")
+	assert.Equal(t, false, IsValid("XXX"))
+	assert.Equal(t, false, IsValid(""))
+
+	c, err := Parse(" usd ")
+	assert.Nil(t, err)
+	assert.Equal(t, "USD", c.Code)
+	_, err = Parse("BTC")
+	assert.ErrorIs(t, err, ErrUnknownCurrency)
+}
@@ -0,0 +1,83 @@
+package currency
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+type RoundingMode string
+
+const (
+	// Half away from zero, the usual commercial rounding
+	ROUND_HALF_UP RoundingMode = "HALF_UP"
+	// Half to the even neighbour, banker's rounding
+	ROUND_HALF_EVEN RoundingMode = "HALF_EVEN"
+	ROUND_DOWN      RoundingMode = "DOWN"
+	ROUND_UP        RoundingMode = "UP"
+)
+
+var ErrInvalidDecimal = errors.New("invalid decimal")
+var ErrUnknownRounding = errors.New("unknown rounding mode")
+
+// ParseDecimal parses a plain decimal like "12", "-0.60" or ".5" exactly, fractions and
+// exponents are refused.
+func ParseDecimal(value string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(value)
+	if !ok || strings.ContainsAny(value, "/eEpPxX_") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidDecimal, value)
+	}
+	return r, nil
+}
+
+func ParseRoundingMode(mode string) (RoundingMode, error) {
+	switch m := RoundingMode(strings.ToUpper(mode)); m {
+	case ROUND_HALF_UP, ROUND_HALF_EVEN, ROUND_DOWN, ROUND_UP:
+		return m, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownRounding, mode)
+}
+
+// Round rounds amount to the minor units of the currency, ROUND_DOWN and ROUND_UP are towards and
+// away from zero.
+func (c Currency) Round(amount *big.Rat, mode RoundingMode) *big.Rat {
+	return RoundTo(amount, c.MinorUnits, mode)
+}
+
+// Format prints amount with exactly the minor units of the currency, it is rounded half up.
+func (c Currency) Format(amount *big.Rat) string {
+	return amount.FloatString(c.MinorUnits)
+}
+
+// Fits reports whether amount has no more decimals than the minor units of the currency.
+func (c Currency) Fits(amount *big.Rat) bool {
+	return c.Round(amount, ROUND_DOWN).Cmp(amount) == 0
+}
+
+// RoundTo rounds amount to the given number of decimals.
+func RoundTo(amount *big.Rat, decimals int, mode RoundingMode) *big.Rat {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	scaled := new(big.Rat).Mul(amount, new(big.Rat).SetInt(scale))
+
+	// Truncated towards zero, with the remainder of the truncation
+	quo, rem := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	if rem.Sign() != 0 {
+		// Compare twice the remainder with the denominator, the sign of amount is in rem
+		half := new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(scaled.Denom())
+		away := false
+		switch mode {
+		case ROUND_UP:
+			away = true
+		case ROUND_DOWN:
+		case ROUND_HALF_EVEN:
+			away = half > 0 || (half == 0 && quo.Bit(0) == 1)
+		default:
+			away = half >= 0
+		}
+		if away {
+			quo.Add(quo, big.NewInt(int64(scaled.Sign())))
+		}
+	}
+	return new(big.Rat).SetFrac(quo, scale)
+}
@@ -0,0 +1,59 @@
+package currency
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDecimal(t *testing.T) {
+	for _, valid := range []string{"12", "-0.60", ".5", "100.000"} {
+		_, err := ParseDecimal(valid)
+		assert.Nil(t, err, valid)
+	}
+	for _, invalid := range []string{"", "0,60", "3/5", "1e3", "0x10", "1_000", "abc"} {
+		_, err := ParseDecimal(invalid)
+		assert.ErrorIs(t, err, ErrInvalidDecimal, invalid)
+	}
+}
+
+func TestRound(t *testing.T) {
+	thb, _ := Lookup("THB")
+	jpy, _ := Lookup("JPY")
+	kwd, _ := Lookup("KWD")
+
+	tests := []struct {
+		currency Currency
+		amount   string
+		mode     RoundingMode
+		expected string
+	}{
+		{thb, "1.005", ROUND_HALF_UP, "1.01"},
+		{thb, "-1.005", ROUND_HALF_UP, "-1.01"},
+		{thb, "1.005", ROUND_HALF_EVEN, "1.00"},
+		{thb, "1.015", ROUND_HALF_EVEN, "1.02"},
+		{thb, "1.0051", ROUND_HALF_EVEN, "1.01"},
+		{thb, "1.009", ROUND_DOWN, "1.00"},
+		{thb, "-1.009", ROUND_DOWN, "-1.00"},
+		{thb, "1.001", ROUND_UP, "1.01"},
+		{thb, "2.50", ROUND_UP, "2.50"},
+		{jpy, "150.5", ROUND_HALF_UP, "151"},
+		{jpy, "150.5", ROUND_HALF_EVEN, "150"},
+		{kwd, "0.12345", ROUND_HALF_UP, "0.123"},
+	}
+	for _, tt := range tests {
+		amount, _ := ParseDecimal(tt.amount)
+		rounded := tt.currency.Round(amount, tt.mode)
+		assert.Equal(t, tt.expected, tt.currency.Format(rounded), "%s %s %s", tt.currency.Code, tt.amount, tt.mode)
+	}
+
+	assert.Equal(t, true, thb.Fits(big.NewRat(105, 100)))
+	assert.Equal(t, false, jpy.Fits(big.NewRat(105, 100)))
+
+	mode, err := ParseRoundingMode("half_even")
+	assert.Nil(t, err)
+	assert.Equal(t, ROUND_HALF_EVEN, mode)
+	_, err = ParseRoundingMode("CEIL")
+	assert.ErrorIs(t, err, ErrUnknownRounding)
+}
@@ -0,0 +1,259 @@
+package fees
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/onecombine/onecombine-msg-validator/src/currency"
+	"github.com/onecombine/onecombine-msg-validator/src/partners"
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+const FEE_ROUNDING_MODE string = "FEE_ROUNDING_MODE"
+
+const (
+	FEE_SETTLEMENT = "SETTLEMENT"
+	FEE_SWITCHING  = "SWITCHING"
+)
+
+const (
+	CAPPED_MIN = "MIN"
+	CAPPED_MAX = "MAX"
+)
+
+var ErrInvalidAmount = errors.New("invalid transaction amount")
+var ErrInvalidFee = errors.New("invalid fee")
+var ErrCurrencyMismatch = errors.New("transaction currency differs from settlement currency")
+
+// Rule is a fee of a partner profile. Value is a percentage of the transaction amount for
+// partners.FEE_TYPE_PERCENT and an amount in the transaction currency for FEE_TYPE_ABSOLUTE.
+type Rule struct {
+	Kind   string
+	Type   string
+	Value  string
+	Waived bool
+}
+
+// Cap bounds a fee before rounding, empty values do not bound it. A waived fee is not raised to
+// its minimum.
+type Cap struct {
+	Min string
+	Max string
+}
+
+type Config struct {
+	Rounding currency.RoundingMode
+	// Caps per fee kind, in the transaction currency
+	Caps map[string]Cap
+}
+
+// NewConfigFromEnv rounds half up unless FEE_ROUNDING_MODE says otherwise, fees are not capped.
+func NewConfigFromEnv() Config {
+	mode, err := currency.ParseRoundingMode(utils.GetEnv(FEE_ROUNDING_MODE, string(currency.ROUND_HALF_UP)))
+	if err != nil {
+		fmt.Printf("Round fees half up, error: %v\n", err)
+		mode = currency.ROUND_HALF_UP
+	}
+	return Config{Rounding: mode, Caps: map[string]Cap{}}
+}
+
+// Item is the fee of a rule, Amount is rounded to the minor units of the currency.
+type Item struct {
+	Kind   string `json:"kind"`
+	Type   string `json:"type"`
+	Rate   string `json:"rate"`
+	Amount string `json:"amount"`
+	Waived bool   `json:"waived"`
+	Capped string `json:"capped,omitempty"`
+
+	amount *big.Rat
+}
+
+// Breakdown itemises the fees of a transaction. Total is the sum of the rounded items and Net the
+// amount left once they are deducted, it is negative when absolute fees exceed the amount.
+type Breakdown struct {
+	Currency string `json:"currency"`
+	Amount   string `json:"amount"`
+	Items    []Item `json:"items"`
+	Total    string `json:"total"`
+	Net      string `json:"net"`
+
+	total *big.Rat
+}
+
+// TotalRat returns the exact total of the fees.
+func (b *Breakdown) TotalRat() *big.Rat {
+	return new(big.Rat).Set(b.total)
+}
+
+// AmountRat returns the exact fee of an item.
+func (i Item) AmountRat() *big.Rat {
+	return new(big.Rat).Set(i.amount)
+}
+
+type bounds struct {
+	min *big.Rat
+	max *big.Rat
+}
+
+type Calculator struct {
+	rounding currency.RoundingMode
+	caps     map[string]bounds
+}
+
+func NewCalculator(cfg Config) (*Calculator, error) {
+	rounding := cfg.Rounding
+	if rounding == "" {
+		rounding = currency.ROUND_HALF_UP
+	}
+	calc := &Calculator{rounding: rounding, caps: make(map[string]bounds)}
+	for kind, cap := range cfg.Caps {
+		var b bounds
+		var err error
+		if cap.Min != "" {
+			if b.min, err = parseNonNegative(cap.Min); err != nil {
+				return nil, fmt.Errorf("%w: minimum of %s fee, %w", ErrInvalidFee, kind, err)
+			}
+		}
+		if cap.Max != "" {
+			if b.max, err = parseNonNegative(cap.Max); err != nil {
+				return nil, fmt.Errorf("%w: maximum of %s fee, %w", ErrInvalidFee, kind, err)
+			}
+		}
+		if b.min != nil && b.max != nil && b.min.Cmp(b.max) > 0 {
+			return nil, fmt.Errorf("%w: minimum of %s fee above its maximum", ErrInvalidFee, kind)
+		}
+		calc.caps[kind] = b
+	}
+	return calc, nil
+}
+
+// AcquirerRules returns the settlement and switching fees of an acquirer, fees without type are
+// skipped.
+func AcquirerRules(acq *partners.AcquirerProfile) []Rule {
+	return profileRules(acq.SettlementFee, acq.SettlementType, acq.SettlementWaived, acq.SwitchingFee, acq.SwitchingType, acq.SwitchingWaived)
+}
+
+func IssuerRules(iss *partners.IssuerProfile) []Rule {
+	return profileRules(iss.SettlementFee, iss.SettlementType, iss.SettlementWaived, iss.SwitchingFee, iss.SwitchingType, iss.SwitchingWaived)
+}
+
+func profileRules(settlementFee, settlementType string, settlementWaived bool, switchingFee, switchingType string, switchingWaived bool) []Rule {
+	rules := []Rule{}
+	if settlementType != "" {
+		rules = append(rules, Rule{Kind: FEE_SETTLEMENT, Type: settlementType, Value: settlementFee, Waived: settlementWaived})
+	}
+	if switchingType != "" {
+		rules = append(rules, Rule{Kind: FEE_SWITCHING, Type: switchingType, Value: switchingFee, Waived: switchingWaived})
+	}
+	return rules
+}
+
+// ForAcquirer computes the fees of an acquirer transaction. Absolute fees are amounts in the
+// settlement currency of the profile, the transaction must be in that currency when it is set.
+func (c *Calculator) ForAcquirer(acq *partners.AcquirerProfile, amount, code string) (*Breakdown, error) {
+	if err := checkSettlementCurrency(code, acq.SettlementCurrencyCode); err != nil {
+		return nil, err
+	}
+	return c.Compute(amount, code, AcquirerRules(acq)...)
+}
+
+func (c *Calculator) ForIssuer(iss *partners.IssuerProfile, amount, code string) (*Breakdown, error) {
+	if err := checkSettlementCurrency(code, iss.SettlementCurrencyCode); err != nil {
+		return nil, err
+	}
+	return c.Compute(amount, code, IssuerRules(iss)...)
+}
+
+func checkSettlementCurrency(code, settlement string) error {
+	if settlement == "" {
+		return nil
+	}
+	cur, err := currency.Parse(code)
+	if err != nil {
+		return err
+	}
+	if cur.Code != strings.ToUpper(strings.TrimSpace(settlement)) {
+		return fmt.Errorf("%w: %s, settled in %s", ErrCurrencyMismatch, cur.Code, settlement)
+	}
+	return nil
+}
+
+// Compute applies rules to a transaction amount given in the currency code. The amount may not
+// have more decimals than the minor units of the currency.
+func (c *Calculator) Compute(amount, code string, rules ...Rule) (*Breakdown, error) {
+	cur, err := currency.Parse(code)
+	if err != nil {
+		return nil, err
+	}
+	value, err := parseNonNegative(amount)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidAmount, err)
+	}
+	if !cur.Fits(value) {
+		return nil, fmt.Errorf("%w: %q has more than %d decimals for %s", ErrInvalidAmount, amount, cur.MinorUnits, cur.Code)
+	}
+
+	breakdown := &Breakdown{Currency: cur.Code, Amount: cur.Format(value), Items: []Item{}, total: new(big.Rat)}
+	for _, rule := range rules {
+		item, err := c.item(rule, value, cur)
+		if err != nil {
+			return nil, err
+		}
+		breakdown.Items = append(breakdown.Items, item)
+		breakdown.total.Add(breakdown.total, item.amount)
+	}
+	breakdown.Total = cur.Format(breakdown.total)
+	breakdown.Net = cur.Format(new(big.Rat).Sub(value, breakdown.total))
+	return breakdown, nil
+}
+
+func (c *Calculator) item(rule Rule, amount *big.Rat, cur currency.Currency) (Item, error) {
+	item := Item{Kind: rule.Kind, Type: rule.Type, Rate: rule.Value, Waived: rule.Waived, amount: new(big.Rat)}
+	if rule.Waived {
+		item.Amount = cur.Format(item.amount)
+		return item, nil
+	}
+
+	value, err := parseNonNegative(rule.Value)
+	if err != nil {
+		return Item{}, fmt.Errorf("%w: %s fee, %w", ErrInvalidFee, rule.Kind, err)
+	}
+	fee := new(big.Rat)
+	switch rule.Type {
+	case partners.FEE_TYPE_PERCENT:
+		fee.Mul(amount, value).Quo(fee, big.NewRat(100, 1))
+	case partners.FEE_TYPE_ABSOLUTE:
+		fee.Set(value)
+	default:
+		return Item{}, fmt.Errorf("%w: %s fee type %q", ErrInvalidFee, rule.Kind, rule.Type)
+	}
+
+	if b, ok := c.caps[rule.Kind]; ok {
+		switch {
+		case b.min != nil && fee.Cmp(b.min) < 0:
+			fee.Set(b.min)
+			item.Capped = CAPPED_MIN
+		case b.max != nil && fee.Cmp(b.max) > 0:
+			fee.Set(b.max)
+			item.Capped = CAPPED_MAX
+		}
+	}
+
+	item.amount = cur.Round(fee, c.rounding)
+	item.Amount = cur.Format(item.amount)
+	return item, nil
+}
+
+func parseNonNegative(value string) (*big.Rat, error) {
+	r, err := currency.ParseDecimal(value)
+	if err != nil {
+		return nil, err
+	}
+	if r.Sign() < 0 {
+		return nil, fmt.Errorf("%q is negative", value)
+	}
+	return r, nil
+}
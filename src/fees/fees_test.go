@@ -0,0 +1,121 @@
+package fees
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/onecombine/onecombine-msg-validator/src/currency"
+	"github.com/onecombine/onecombine-msg-validator/src/partners"
+)
+
+func TestComputeAcquirerFees(t *testing.T) {
+	calc, err := NewCalculator(Config{})
+	assert.Nil(t, err)
+
+	acq := &partners.AcquirerProfile{
+		AcqID:          "100090",
+		SettlementFee:  "0.60",
+		SettlementType: partners.FEE_TYPE_PERCENT,
+		SwitchingFee:   "0.61",
+		SwitchingType:  partners.FEE_TYPE_ABSOLUTE,
+	}
+	breakdown, err := calc.ForAcquirer(acq, "1234.50", "THB")
+	assert.Nil(t, err)
+	assert.Equal(t, "THB", breakdown.Currency)
+	assert.Equal(t, []Item{
+		{Kind: FEE_SETTLEMENT, Type: "PCT", Rate: "0.60", Amount: "7.41"},
+		{Kind: FEE_SWITCHING, Type: "ABS", Rate: "0.61", Amount: "0.61"},
+	}, stripExact(breakdown.Items))
+	assert.Equal(t, "8.02", breakdown.Total)
+	assert.Equal(t, "1226.48", breakdown.Net)
+
+	acq.SwitchingWaived = true
+	breakdown, _ = calc.ForAcquirer(acq, "1234.50", "THB")
+	assert.Equal(t, true, breakdown.Items[1].Waived)
+	assert.Equal(t, "0.00", breakdown.Items[1].Amount)
+	assert.Equal(t, "7.41", breakdown.Total)
+
+	// Absolute fees are in the settlement currency
+	acq.SettlementCurrencyCode = "THB"
+	_, err = calc.ForAcquirer(acq, "35.00", "USD")
+	assert.ErrorIs(t, err, ErrCurrencyMismatch)
+	breakdown, err = calc.ForAcquirer(acq, "1234.50", "thb")
+	assert.Nil(t, err)
+	assert.Equal(t, "THB", breakdown.Currency)
+}
+
+func TestComputeNegativeNet(t *testing.T) {
+	calc, _ := NewCalculator(Config{})
+	breakdown, err := calc.Compute("0.50", "USD", Rule{Kind: FEE_SWITCHING, Type: partners.FEE_TYPE_ABSOLUTE, Value: "0.75"})
+	assert.Nil(t, err)
+	assert.Equal(t, "-0.25", breakdown.Net, "Absolute fee above the amount")
+}
+
+func TestComputeRoundsToMinorUnits(t *testing.T) {
+	rules := []Rule{{Kind: FEE_SETTLEMENT, Type: partners.FEE_TYPE_PERCENT, Value: "1.5"}}
+
+	halfUp, _ := NewCalculator(Config{Rounding: currency.ROUND_HALF_UP})
+	breakdown, err := halfUp.Compute("1001", "JPY", rules...)
+	assert.Nil(t, err)
+	assert.Equal(t, "15", breakdown.Total, "15.015 JPY")
+
+	breakdown, _ = halfUp.Compute("1.000", "KWD", rules...)
+	assert.Equal(t, "0.015", breakdown.Total)
+
+	halfEven, _ := NewCalculator(Config{Rounding: currency.ROUND_HALF_EVEN})
+	breakdown, _ = halfEven.Compute("0.70", "USD", Rule{Kind: FEE_SETTLEMENT, Type: partners.FEE_TYPE_PERCENT, Value: "2.5"})
+	assert.Equal(t, "0.02", breakdown.Total, "0.0175 USD")
+
+	// 0.1 + 0.2 is exact
+	breakdown, _ = halfUp.Compute("10.00", "USD",
+		Rule{Kind: FEE_SETTLEMENT, Type: partners.FEE_TYPE_ABSOLUTE, Value: "0.1"},
+		Rule{Kind: FEE_SWITCHING, Type: partners.FEE_TYPE_ABSOLUTE, Value: "0.2"})
+	assert.Equal(t, "0.30", breakdown.Total)
+	assert.Equal(t, "0.30", breakdown.TotalRat().FloatString(2))
+}
+
+func TestComputeCaps(t *testing.T) {
+	calc, err := NewCalculator(Config{Caps: map[string]Cap{FEE_SETTLEMENT: {Min: "1.00", Max: "50"}}})
+	assert.Nil(t, err)
+	rules := []Rule{{Kind: FEE_SETTLEMENT, Type: partners.FEE_TYPE_PERCENT, Value: "1"}}
+
+	breakdown, _ := calc.Compute("20.00", "THB", rules...)
+	assert.Equal(t, "1.00", breakdown.Total)
+	assert.Equal(t, CAPPED_MIN, breakdown.Items[0].Capped)
+
+	breakdown, _ = calc.Compute("10000.00", "THB", rules...)
+	assert.Equal(t, "50.00", breakdown.Total)
+	assert.Equal(t, CAPPED_MAX, breakdown.Items[0].Capped)
+
+	breakdown, _ = calc.Compute("20.00", "THB", Rule{Kind: FEE_SETTLEMENT, Type: partners.FEE_TYPE_PERCENT, Value: "1", Waived: true})
+	assert.Equal(t, "0.00", breakdown.Total, "Waived fee is not raised to the minimum")
+
+	_, err = NewCalculator(Config{Caps: map[string]Cap{FEE_SWITCHING: {Min: "5", Max: "1"}}})
+	assert.ErrorIs(t, err, ErrInvalidFee)
+}
+
+func TestComputeErrors(t *testing.T) {
+	calc, _ := NewCalculator(Config{})
+	rules := []Rule{{Kind: FEE_SETTLEMENT, Type: partners.FEE_TYPE_PERCENT, Value: "1"}}
+
+	_, err := calc.Compute("10", "XYZ", rules...)
+	assert.ErrorIs(t, err, currency.ErrUnknownCurrency)
+	_, err = calc.Compute("-10", "THB", rules...)
+	assert.ErrorIs(t, err, ErrInvalidAmount)
+	_, err = calc.Compute("10.5", "JPY", rules...)
+	assert.ErrorIs(t, err, ErrInvalidAmount, "Decimals below the minor unit")
+	_, err = calc.Compute("10", "THB", Rule{Kind: FEE_SETTLEMENT, Type: "FLAT", Value: "1"})
+	assert.ErrorIs(t, err, ErrInvalidFee)
+	_, err = calc.Compute("10", "THB", Rule{Kind: FEE_SETTLEMENT, Type: partners.FEE_TYPE_ABSOLUTE, Value: "1,5"})
+	assert.ErrorIs(t, err, ErrInvalidFee)
+}
+
+func stripExact(items []Item) []Item {
+	stripped := make([]Item, 0, len(items))
+	for _, item := range items {
+		item.amount = nil
+		stripped = append(stripped, item)
+	}
+	return stripped
+}
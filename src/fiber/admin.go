@@ -0,0 +1,312 @@
+package fiber
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/onecombine/onecombine-msg-validator/src/partners"
+	"github.com/onecombine/onecombine-msg-validator/src/settlementfx"
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+const ADMIN_API_KEY string = "ADMIN_API_KEY"
+const ADMIN_API_KEY_HEADER string = "X-Admin-Key"
+
+const (
+	PARTNER_TYPE_ACQUIRER = partners.PARTNER_TYPE_ACQUIRER
+	PARTNER_TYPE_ISSUER   = partners.PARTNER_TYPE_ISSUER
+)
+
+type AdminConfig struct {
+	ErrorHandler fiber.Handler
+	ApiKey       string
+	Partners     *partners.PartnerService
+	FX           *settlementfx.SettlementFXService
+	Name         string
+}
+
+type AuthPolicy struct {
+	PartnerID             string   `json:"partner_id"`
+	PartnerType           string   `json:"partner_type"`
+	Name                  string   `json:"name"`
+	ApiKey                string   `json:"api_key"`
+	SecretLoaded          bool     `json:"secret_loaded"`
+	SignatureAlgorithm    string   `json:"signature_algorithm"`
+	MessageExpirationMsec int      `json:"message_expiration_msec"`
+	SignedMethods         []string `json:"signed_methods"`
+	UnsignedMethods       []string `json:"unsigned_methods"`
+	NotificationHook      string   `json:"notification_hook,omitempty"`
+	Status                string   `json:"status"`
+	Suspended             bool     `json:"suspended"`
+}
+
+// StoreVersions describes the writes applied to an in-memory store, for debugging.
+type StoreVersions struct {
+	Version  uint64                   `json:"version"`
+	Stale    uint64                   `json:"stale"`
+	Rejected map[string]uint64        `json:"rejected"`
+	Entities []partners.EntityVersion `json:"entities"`
+}
+
+type SuspendRequest struct {
+	Reason string `json:"reason"`
+}
+
+type PartnerList struct {
+	Acquirers []*partners.AcquirerProfile `json:"acquirers"`
+	Issuers   []*partners.IssuerProfile   `json:"issuers"`
+}
+
+func NewAdminConfig(name string, s *partners.PartnerService, fx *settlementfx.SettlementFXService) *AdminConfig {
+	var config AdminConfig
+	config.ErrorHandler = nil
+	config.ApiKey = utils.GetEnv(ADMIN_API_KEY, "")
+	config.Partners = s
+	config.FX = fx
+	config.Name = name
+	return &config
+}
+
+// NewAdminRouter returns an app exposing the partner and key state loaded by this pod.
+// It is meant to be mounted on the service app, e.g. app.Mount("/admin", NewAdminRouter(*config)).
+func NewAdminRouter(config AdminConfig) *fiber.App {
+	if config.ErrorHandler == nil {
+		config.ErrorHandler = func(ctx *fiber.Ctx) error {
+			return ctx.Status(fiber.StatusUnauthorized).JSON(utils.APIKeyError())
+		}
+	}
+
+	app := fiber.New()
+	app.Use(func(ctx *fiber.Ctx) error {
+		adminKey := ctx.Get(ADMIN_API_KEY_HEADER)
+		// An admin router without a configured credential refuses every call
+		if config.ApiKey == "" || subtle.ConstantTimeCompare([]byte(adminKey), []byte(config.ApiKey)) != 1 {
+			return config.ErrorHandler(ctx)
+		}
+		return ctx.Next()
+	})
+
+	if config.Partners != nil {
+		app.Get("/partners", func(ctx *fiber.Ctx) error {
+			return ctx.JSON(listPartners(config.Partners))
+		})
+		app.Post("/partners/refresh", func(ctx *fiber.Ctx) error {
+			if err := config.Partners.Reload(); err != nil {
+				return ctx.Status(fiber.StatusBadGateway).JSON(utils.InternalSystemError())
+			}
+			return ctx.JSON(listPartners(config.Partners))
+		})
+		app.Get("/partners/versions", func(ctx *fiber.Ctx) error {
+			return ctx.JSON(fiber.Map{
+				PARTNER_TYPE_ACQUIRER: storeVersions(config.Partners.GetAcquirerStore()),
+				PARTNER_TYPE_ISSUER:   storeVersions(config.Partners.GetIssuerStore()),
+			})
+		})
+		app.Get("/partners/suspensions", func(ctx *fiber.Ctx) error {
+			return ctx.JSON(config.Partners.GetSuspensions())
+		})
+		// Emergency switch, the partner is refused on the next request until the suspension is lifted
+		app.Post("/partners/:type/:id/suspend", func(ctx *fiber.Ctx) error {
+			var req SuspendRequest
+			if len(ctx.Body()) > 0 {
+				if err := ctx.BodyParser(&req); err != nil {
+					return ctx.Status(fiber.StatusBadRequest).JSON(utils.BadRequestError())
+				}
+			}
+			suspension, err := config.Partners.SuspendPartner(ctx.Params("type"), ctx.Params("id"), req.Reason)
+			if errors.Is(err, partners.ErrPartnerNotFound) {
+				return ctx.Status(fiber.StatusNotFound).JSON(utils.CreateErrorResponse(utils.CODE_PARTNER_NOT_FOUND))
+			}
+			if err != nil {
+				// Not applied, the other instances would keep accepting the partner
+				return ctx.Status(fiber.StatusServiceUnavailable).JSON(utils.InternalSystemError())
+			}
+			return ctx.JSON(suspension)
+		})
+		app.Delete("/partners/:type/:id/suspend", func(ctx *fiber.Ctx) error {
+			lifted, err := config.Partners.LiftSuspension(ctx.Params("type"), ctx.Params("id"))
+			if err != nil {
+				return ctx.Status(fiber.StatusServiceUnavailable).JSON(utils.InternalSystemError())
+			}
+			if !lifted {
+				return ctx.Status(fiber.StatusNotFound).JSON(utils.CreateErrorResponse(utils.CODE_PARTNER_NOT_FOUND))
+			}
+			return ctx.SendStatus(fiber.StatusNoContent)
+		})
+		app.Get("/partners/:id", func(ctx *fiber.Ctx) error {
+			policy := partnerAuthPolicy(config.Partners, ctx.Params("id"))
+			if policy == nil {
+				return ctx.Status(fiber.StatusNotFound).JSON(utils.CreateErrorResponse(utils.CODE_PARTNER_NOT_FOUND))
+			}
+			return ctx.JSON(policy)
+		})
+	}
+
+	app.Get("/kafka/offsets", func(ctx *fiber.Ctx) error {
+		offsets := []partners.TopicOffset{}
+		if config.Partners != nil {
+			offsets = append(offsets, config.Partners.GetConsumerOffsets()...)
+		}
+		if config.FX != nil {
+			offsets = append(offsets, config.FX.GetConsumerOffsets()...)
+		}
+		return ctx.JSON(offsets)
+	})
+
+	app.Get("/kafka/failures", func(ctx *fiber.Ctx) error {
+		failures := []partners.ConsumerFailures{}
+		if config.Partners != nil {
+			for _, stats := range config.Partners.GetConsumerStats() {
+				failures = append(failures, stats.Failures())
+			}
+		}
+		if config.FX != nil {
+			if stats := config.FX.GetConsumerStats(); stats != nil {
+				failures = append(failures, stats.Failures())
+			}
+		}
+		return ctx.JSON(failures)
+	})
+
+	if config.FX != nil {
+		app.Get("/fx", func(ctx *fiber.Ctx) error {
+			return ctx.JSON(config.FX.GetFXStore().Snapshot())
+		})
+		app.Get("/fx/versions", func(ctx *fiber.Ctx) error {
+			return ctx.JSON(storeVersions(config.FX.GetFXStore()))
+		})
+		app.Post("/fx/refresh", func(ctx *fiber.Ctx) error {
+			if err := config.FX.Reload(); err != nil {
+				return ctx.Status(fiber.StatusBadGateway).JSON(utils.InternalSystemError())
+			}
+			return ctx.JSON(config.FX.GetLastReconciliation())
+		})
+		app.Get("/fx/reconciliation", func(ctx *fiber.Ctx) error {
+			return ctx.JSON(config.FX.GetLastReconciliation())
+		})
+		app.Get("/fx/quarantine", func(ctx *fiber.Ctx) error {
+			return ctx.JSON(config.FX.GetQuarantinedRates())
+		})
+		// Applies a suspicious rate after a manual check, the rate is not guarded again
+		app.Post("/fx/quarantine/:pair/approve", func(ctx *fiber.Ctx) error {
+			fx, err := config.FX.ApproveQuarantinedRate(ctx.Params("pair"))
+			if errors.Is(err, settlementfx.ErrNotQuarantined) {
+				return ctx.Status(fiber.StatusNotFound).JSON(utils.CreateErrorResponse(utils.CODE_FX_NOT_FOUND))
+			}
+			if errors.Is(err, settlementfx.ErrQuarantineUnavailable) {
+				// Not applied, the other instances would keep the rate quarantined
+				return ctx.Status(fiber.StatusServiceUnavailable).JSON(utils.InternalSystemError())
+			}
+			if err != nil {
+				// Refused by the store, e.g. a newer rate was received meanwhile
+				return ctx.Status(fiber.StatusConflict).JSON(utils.BadRequestError())
+			}
+			return ctx.JSON(fx)
+		})
+		app.Delete("/fx/quarantine/:pair", func(ctx *fiber.Ctx) error {
+			err := config.FX.RejectQuarantinedRate(ctx.Params("pair"))
+			if errors.Is(err, settlementfx.ErrQuarantineUnavailable) {
+				return ctx.Status(fiber.StatusServiceUnavailable).JSON(utils.InternalSystemError())
+			}
+			if err != nil {
+				return ctx.Status(fiber.StatusNotFound).JSON(utils.CreateErrorResponse(utils.CODE_FX_NOT_FOUND))
+			}
+			return ctx.SendStatus(fiber.StatusNoContent)
+		})
+		app.Get("/fx/stale", func(ctx *fiber.Ctx) error {
+			return ctx.JSON(config.FX.GetStaleRates())
+		})
+		app.Get("/fx/history/:pair", func(ctx *fiber.Ctx) error {
+			points, err := config.FX.GetRateHistory(ctx.UserContext(), ctx.Params("pair"))
+			if errors.Is(err, settlementfx.ErrInvalidPair) {
+				return ctx.Status(fiber.StatusBadRequest).JSON(utils.BadRequestError())
+			}
+			if err != nil {
+				return ctx.Status(fiber.StatusBadGateway).JSON(utils.InternalSystemError())
+			}
+			return ctx.JSON(points)
+		})
+	}
+
+	return app
+}
+
+func listPartners(s *partners.PartnerService) *PartnerList {
+	list := &PartnerList{
+		Acquirers: []*partners.AcquirerProfile{},
+		Issuers:   []*partners.IssuerProfile{},
+	}
+
+	for _, v := range s.GetAcquirerStore().Snapshot() {
+		acq := *v
+		acq.ApiKey = maskApiKey(acq.ApiKey)
+		acq.Secret = redactSecret(acq.Secret)
+		list.Acquirers = append(list.Acquirers, &acq)
+	}
+	for _, v := range s.GetIssuerStore().Snapshot() {
+		iss := *v
+		iss.ApiKey = maskApiKey(iss.ApiKey)
+		iss.Secret = redactSecret(iss.Secret)
+		list.Issuers = append(list.Issuers, &iss)
+	}
+	return list
+}
+
+func partnerAuthPolicy(s *partners.PartnerService, id string) *AuthPolicy {
+	exp, _ := strconv.Atoi(utils.GetEnv(MESSAGE_EXPIRATION_MSEC, "600000"))
+	policy := &AuthPolicy{
+		PartnerID:             id,
+		SignatureAlgorithm:    "OneCombineHmac",
+		MessageExpirationMsec: exp,
+		SignedMethods:         []string{fiber.MethodPost, fiber.MethodPut, fiber.MethodDelete},
+		UnsignedMethods:       []string{fiber.MethodGet},
+	}
+
+	if acq := s.GetAcquireByID(id); acq != nil {
+		policy.PartnerType = PARTNER_TYPE_ACQUIRER
+		policy.Name = acq.Name
+		policy.ApiKey = maskApiKey(acq.ApiKey)
+		policy.SecretLoaded = acq.Secret != ""
+		policy.NotificationHook = acq.NotificationHook
+		policy.Status = acq.Status
+		policy.Suspended = !s.IsAcquirerActive(acq)
+		return policy
+	}
+	if iss := s.GetIssuerByID(id); iss != nil {
+		policy.PartnerType = PARTNER_TYPE_ISSUER
+		policy.Name = iss.Name
+		policy.ApiKey = maskApiKey(iss.ApiKey)
+		policy.SecretLoaded = iss.Secret != ""
+		policy.Status = iss.Status
+		policy.Suspended = !s.IsIssuerActive(iss)
+		return policy
+	}
+	return nil
+}
+
+func storeVersions[T any](st *partners.Store[T]) StoreVersions {
+	return StoreVersions{
+		Version:  st.Version(),
+		Stale:    st.Stale(),
+		Rejected: st.Rejected(),
+		Entities: st.EntityVersions(),
+	}
+}
+
+func maskApiKey(key string) string {
+	if len(key) <= 4 {
+		return strings.Repeat("*", len(key))
+	}
+	return strings.Repeat("*", len(key)-4) + key[len(key)-4:]
+}
+
+func redactSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "[REDACTED]"
+}
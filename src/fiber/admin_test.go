@@ -0,0 +1,40 @@
+package fiber
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminRouterCredential(t *testing.T) {
+	app := NewAdminRouter(AdminConfig{ApiKey: "ADMIN-KEY"})
+
+	req := httptest.NewRequest(fiber.MethodGet, "/kafka/offsets", nil)
+	resp, _ := app.Test(req)
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode, "Missing admin key")
+
+	req = httptest.NewRequest(fiber.MethodGet, "/kafka/offsets", nil)
+	req.Header.Set(ADMIN_API_KEY_HEADER, "WRONG-KEY")
+	resp, _ = app.Test(req)
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode, "Wrong admin key")
+
+	req = httptest.NewRequest(fiber.MethodGet, "/kafka/offsets", nil)
+	req.Header.Set(ADMIN_API_KEY_HEADER, "ADMIN-KEY")
+	resp, _ = app.Test(req)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode, "Valid admin key")
+
+	app = NewAdminRouter(AdminConfig{})
+	req = httptest.NewRequest(fiber.MethodGet, "/kafka/offsets", nil)
+	req.Header.Set(ADMIN_API_KEY_HEADER, "")
+	resp, _ = app.Test(req)
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode, "No admin key configured")
+}
+
+func TestMaskApiKey(t *testing.T) {
+	assert.Equal(t, "**********CDEF", maskApiKey("ABCD-ABCD-CDEF"))
+	assert.Equal(t, "***", maskApiKey("ABC"))
+	assert.Equal(t, "[REDACTED]", redactSecret("aaaa"))
+	assert.Equal(t, "", redactSecret(""))
+}
@@ -0,0 +1,180 @@
+package fiber
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+const REQUEST_MAX_BODY_BYTES string = "REQUEST_MAX_BODY_BYTES"
+const REQUEST_CONTENT_TYPES string = "REQUEST_CONTENT_TYPES"     // comma separated, e.g. application/json
+const SIGNATURE_BODY_ENCODING string = "SIGNATURE_BODY_ENCODING" // DECODED, ENCODED
+
+const (
+	// The signature is computed over the payload after Content-Encoding has been removed
+	SIGNATURE_COVERS_DECODED = "DECODED"
+	// The signature is computed over the bytes as sent on the wire
+	SIGNATURE_COVERS_ENCODED = "ENCODED"
+)
+
+var errBodyTooLarge = errors.New("body too large")
+var errUnsupportedEncoding = errors.New("unsupported content encoding")
+
+type BodyPolicy struct {
+	// Maximum size in bytes of both the encoded and the decoded body, 0 disables the limit
+	MaxSize int
+	// Content types accepted when no route specific list matches, empty accepts any
+	ContentTypes []string
+	// Content types accepted per route, keyed by path prefix; the longest matching prefix wins
+	RouteContentTypes map[string][]string
+	SignatureCovers   string
+}
+
+func NewBodyPolicy() BodyPolicy {
+	maxSize, err := strconv.Atoi(utils.GetEnv(REQUEST_MAX_BODY_BYTES, "1048576"))
+	if err != nil {
+		maxSize = 1048576
+	}
+
+	contentTypes := []string{}
+	for _, t := range strings.Split(utils.GetEnv(REQUEST_CONTENT_TYPES, fiber.MIMEApplicationJSON), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			contentTypes = append(contentTypes, t)
+		}
+	}
+
+	covers := strings.ToUpper(utils.GetEnv(SIGNATURE_BODY_ENCODING, SIGNATURE_COVERS_DECODED))
+	if covers != SIGNATURE_COVERS_ENCODED {
+		covers = SIGNATURE_COVERS_DECODED
+	}
+
+	return BodyPolicy{
+		MaxSize:           maxSize,
+		ContentTypes:      contentTypes,
+		RouteContentTypes: make(map[string][]string),
+		SignatureCovers:   covers,
+	}
+}
+
+// Enforce checks the request body against the policy and returns the bytes covered by the
+// signature. The request body is replaced by its decoded form so downstream handlers never
+// see the Content-Encoding. On failure the http status and error response are returned.
+func (p BodyPolicy) Enforce(ctx *fiber.Ctx) ([]byte, int, *utils.ErrorResponse) {
+	raw := ctx.BodyRaw()
+	if p.MaxSize > 0 && len(raw) > p.MaxSize {
+		return nil, fiber.StatusRequestEntityTooLarge, utils.PayloadTooLargeError()
+	}
+
+	if len(raw) > 0 && !p.allowContentType(ctx.Path(), ctx.Get(fiber.HeaderContentType)) {
+		return nil, fiber.StatusUnsupportedMediaType, utils.UnsupportedContentTypeError()
+	}
+
+	encoding := ctx.Get(fiber.HeaderContentEncoding)
+	if encoding == "" {
+		return raw, 0, nil
+	}
+
+	decoded, err := p.decode(raw, encoding)
+	switch {
+	case errors.Is(err, errBodyTooLarge):
+		return nil, fiber.StatusRequestEntityTooLarge, utils.PayloadTooLargeError()
+	case errors.Is(err, errUnsupportedEncoding):
+		return nil, fiber.StatusUnsupportedMediaType, utils.UnsupportedContentEncodingError()
+	case err != nil:
+		return nil, fiber.StatusBadRequest, utils.BadRequestError()
+	}
+
+	signed := decoded
+	if p.SignatureCovers == SIGNATURE_COVERS_ENCODED {
+		signed = append([]byte{}, raw...)
+	}
+
+	ctx.Request().Header.Del(fiber.HeaderContentEncoding)
+	ctx.Request().SetBody(decoded)
+	return signed, 0, nil
+}
+
+func (p BodyPolicy) allowContentType(path, contentType string) bool {
+	allowed := p.ContentTypes
+	matched := -1
+	for prefix, types := range p.RouteContentTypes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > matched {
+			allowed = types
+			matched = len(prefix)
+		}
+	}
+
+	if len(allowed) == 0 {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range allowed {
+		if strings.EqualFold(mediaType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// decode removes the encodings in the reverse order they were applied (RFC 9110 section 8.4).
+func (p BodyPolicy) decode(body []byte, encoding string) ([]byte, error) {
+	encodings := strings.Split(encoding, ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var err error
+		switch strings.ToLower(strings.TrimSpace(encodings[i])) {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			body, err = p.gunzip(body)
+		case "br":
+			body, err = p.read(brotli.NewReader(bytes.NewReader(body)))
+		default:
+			return nil, errUnsupportedEncoding
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// gunzip refuses a truncated stream, its checksum and trailer are verified on close.
+func (p BodyPolicy) gunzip(body []byte) (decoded []byte, err error) {
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := gz.Close(); cerr != nil && err == nil {
+			decoded, err = nil, cerr
+		}
+	}()
+	return p.read(gz)
+}
+
+func (p BodyPolicy) read(reader io.Reader) ([]byte, error) {
+	if p.MaxSize > 0 {
+		reader = io.LimitReader(reader, int64(p.MaxSize)+1)
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if p.MaxSize > 0 && len(decoded) > p.MaxSize {
+		return nil, errBodyTooLarge
+	}
+	return decoded, nil
+}
@@ -0,0 +1,108 @@
+package fiber
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/onecombine/onecombine-msg-validator/src/algorithms"
+)
+
+const testBody = `{"partner_id": "500001", "order_ref": "2020040318061601678097480", "amount": "8.80"}`
+
+func newTestApp(policy BodyPolicy) (*fiber.App, *algorithms.OneCombineHmac) {
+	hmac := algorithms.NewOneCombineHmac("aaaa", 600000).(*algorithms.OneCombineHmac)
+	validator := algorithms.Validator(hmac)
+
+	config := Config{
+		ApiKeys: map[string]*AcquirerUtility{"ABCD-ABCD-ABCD": {validator: &validator, id: "100090"}},
+		Body:    policy,
+	}
+
+	app := fiber.New()
+	app.Post("/v1/qr", NewHandler(config), func(ctx *fiber.Ctx) error {
+		return ctx.Send(ctx.Body())
+	})
+	return app, hmac
+}
+
+func gzipped(data string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(data))
+	gz.Close()
+	return buf.Bytes()
+}
+
+func postBody(app *fiber.App, body []byte, signature, contentType, encoding string) (int, string) {
+	req := httptest.NewRequest(fiber.MethodPost, "/v1/qr", bytes.NewReader(body))
+	req.Header.Set("X-Api-Key", "ABCD-ABCD-ABCD")
+	req.Header.Set("Signature", signature)
+	req.Header.Set(fiber.HeaderContentType, contentType)
+	if encoding != "" {
+		req.Header.Set(fiber.HeaderContentEncoding, encoding)
+	}
+
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	resp, _ := app.Test(req)
+	out, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(out)
+}
+
+func TestBodyPolicyLimits(t *testing.T) {
+	app, hmac := newTestApp(BodyPolicy{MaxSize: 16, ContentTypes: []string{fiber.MIMEApplicationJSON}})
+	status, _ := postBody(app, []byte(testBody), hmac.Sign(testBody), fiber.MIMEApplicationJSON, "")
+	assert.Equal(t, fiber.StatusRequestEntityTooLarge, status, "Body too large")
+
+	app, hmac = newTestApp(BodyPolicy{MaxSize: 1024, ContentTypes: []string{fiber.MIMEApplicationJSON}})
+	status, _ = postBody(app, []byte(testBody), hmac.Sign(testBody), fiber.MIMETextPlain, "")
+	assert.Equal(t, fiber.StatusUnsupportedMediaType, status, "Content type not allowed")
+
+	status, _ = postBody(app, []byte(testBody), hmac.Sign(testBody), "application/json; charset=utf-8", "")
+	assert.Equal(t, fiber.StatusOK, status, "Content type with parameters")
+
+	status, _ = postBody(app, []byte(testBody), hmac.Sign(testBody), fiber.MIMEApplicationJSON, "deflate")
+	assert.Equal(t, fiber.StatusUnsupportedMediaType, status, "Encoding not supported")
+
+	status, _ = postBody(app, []byte("not gzip"), hmac.Sign(testBody), fiber.MIMEApplicationJSON, "gzip")
+	assert.Equal(t, fiber.StatusBadRequest, status, "Corrupted gzip body")
+
+	truncated := gzipped(testBody)
+	truncated = truncated[:len(truncated)-4]
+	status, _ = postBody(app, truncated, hmac.Sign(testBody), fiber.MIMEApplicationJSON, "gzip")
+	assert.Equal(t, fiber.StatusBadRequest, status, "Truncated gzip trailer")
+
+	app, hmac = newTestApp(BodyPolicy{MaxSize: 32})
+	status, _ = postBody(app, gzipped(testBody), hmac.Sign(testBody), fiber.MIMEApplicationJSON, "gzip")
+	assert.Equal(t, fiber.StatusRequestEntityTooLarge, status, "Decoded body too large")
+
+	policy := BodyPolicy{ContentTypes: []string{fiber.MIMEApplicationJSON}, RouteContentTypes: map[string][]string{"/v1": {fiber.MIMETextPlain}}}
+	app, hmac = newTestApp(policy)
+	status, _ = postBody(app, []byte(testBody), hmac.Sign(testBody), fiber.MIMETextPlain, "")
+	assert.Equal(t, fiber.StatusOK, status, "Route specific content type")
+}
+
+func TestBodyPolicyEncoding(t *testing.T) {
+	app, hmac := newTestApp(BodyPolicy{SignatureCovers: SIGNATURE_COVERS_DECODED})
+	status, body := postBody(app, gzipped(testBody), hmac.Sign(testBody), fiber.MIMEApplicationJSON, "gzip")
+	assert.Equal(t, fiber.StatusOK, status, "Signature over decoded body")
+	assert.Equal(t, testBody, body, "Handler receives the decoded body")
+
+	encoded := gzipped(testBody)
+	status, _ = postBody(app, encoded, hmac.Sign(string(encoded)), fiber.MIMEApplicationJSON, "gzip")
+	assert.Equal(t, fiber.StatusUnauthorized, status, "Signature over encoded body is rejected")
+
+	app, hmac = newTestApp(BodyPolicy{SignatureCovers: SIGNATURE_COVERS_ENCODED})
+	status, body = postBody(app, encoded, hmac.Sign(string(encoded)), fiber.MIMEApplicationJSON, "gzip")
+	assert.Equal(t, fiber.StatusOK, status, "Signature over encoded body")
+	assert.Equal(t, testBody, body, "Handler receives the decoded body")
+}
@@ -15,4 +15,5 @@ type AcquirerUtility struct {
 	validator *algorithms.Validator
 	id        string
 	Hook      string
+	suspended bool
 }
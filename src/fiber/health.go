@@ -0,0 +1,27 @@
+package fiber
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/onecombine/onecombine-msg-validator/src/health"
+)
+
+func NewLivenessHandler(h *health.Health) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		return sendHealthReport(ctx, h.Liveness(ctx.UserContext()))
+	}
+}
+
+func NewReadinessHandler(h *health.Health) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		return sendHealthReport(ctx, h.Readiness(ctx.UserContext()))
+	}
+}
+
+func sendHealthReport(ctx *fiber.Ctx, report *health.Report) error {
+	status := fiber.StatusOK
+	if report.Status != health.STATUS_UP {
+		status = fiber.StatusServiceUnavailable
+	}
+	return ctx.Status(status).JSON(report)
+}
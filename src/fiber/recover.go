@@ -0,0 +1,79 @@
+package fiber
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+type RecoverConfig struct {
+	Name string
+}
+
+func NewRecoverConfig(name string) *RecoverConfig {
+	var config RecoverConfig
+	config.Name = name
+	return &config
+}
+
+// NewRecoverHandler turns a panic in a downstream handler into an internal system error response.
+// NewHandler and NewXnapHandler already recover on their own, this handler is meant for routes
+// which are not behind them; it then emits the request log line itself when a panic is recovered.
+func NewRecoverHandler(config RecoverConfig) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if logger, ok := ctx.Locals("logger").(*utils.Logger); ok {
+			return next(ctx, logger)
+		}
+
+		logger := utils.Logger{}
+
+		opts := []utils.Option{}
+		reqId := assignRequestId(ctx)
+		opts = append(opts, utils.WithRequestId(reqId))
+		userAgent := ctx.GetReqHeaders()["User-Agent"]
+		if userAgent != "" {
+			opts = append(opts, utils.WithUserAgent(userAgent))
+		}
+		ip := ctx.IP()
+		if ip != "" {
+			opts = append(opts, utils.WithRemoteAddress(ip))
+		}
+		if config.Name != "" {
+			opts = append(opts, utils.WithService(config.Name))
+		}
+		opts = append(opts, utils.WithRawUrl(ctx.BaseURL()+ctx.OriginalURL()))
+		opts = append(opts, utils.WithHttpMethod(ctx.Method()))
+
+		logger.Intialize(opts...)
+		ctx.Locals("logger", &logger)
+
+		err := next(ctx, &logger)
+		if logger.Msg.StackTrace != "" {
+			defer logger.Print(ctx)
+		}
+		return err
+	}
+}
+
+// next calls the downstream handlers and recovers from any panic they raise.
+func next(ctx *fiber.Ctx, logger *utils.Logger) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoverPanic(ctx, logger, r)
+		}
+	}()
+	return ctx.Next()
+}
+
+func recoverPanic(ctx *fiber.Ctx, logger *utils.Logger, r interface{}) error {
+	logger.Msg.HttpStatus = utils.LOGGING_HTTPSTATUS_INTERNALSERVERERROR
+	logger.Msg.ErrorType = utils.LOGGING_ERRORTYPE_SYSTEMERROR
+	logger.Msg.StackTrace = fmt.Sprintf("panic: %v\n%s", r, debug.Stack())
+	ctx.Locals("logger", logger)
+
+	ctx.Response().ResetBody()
+	return ctx.Status(fiber.StatusInternalServerError).JSON(utils.InternalSystemError())
+}
@@ -0,0 +1,87 @@
+package fiber
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+func captureLog(fx func()) string {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	fx()
+	log.SetOutput(os.Stderr)
+	return buf.String()
+}
+
+func TestRecoverBehindXnapHandler(t *testing.T) {
+	app := fiber.New()
+	app.Post("/", NewXnapHandler(*NewXnapConfig("test")), func(ctx *fiber.Ctx) error {
+		panic("boom")
+	})
+
+	var status int
+	var body []byte
+	out := captureLog(func() {
+		resp, _ := app.Test(httptest.NewRequest(fiber.MethodPost, "/", nil))
+		status = resp.StatusCode
+		body, _ = io.ReadAll(resp.Body)
+	})
+
+	assert.Equal(t, fiber.StatusInternalServerError, status)
+	var errResp utils.ErrorResponse
+	json.Unmarshal(body, &errResp)
+	assert.Equal(t, *utils.InternalSystemError(), errResp, "Internal system error response")
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	assert.Equal(t, 1, len(lines), "Single log line")
+	var msg utils.LoggingMessage
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &msg))
+	assert.Equal(t, utils.LOGGING_ERRORTYPE_SYSTEMERROR, msg.ErrorType)
+	assert.Equal(t, utils.LOGGING_HTTPSTATUS_INTERNALSERVERERROR, msg.HttpStatus)
+	assert.Contains(t, msg.StackTrace, "panic: boom")
+}
+
+func TestRecoverStandalone(t *testing.T) {
+	app := fiber.New()
+	app.Use(NewRecoverHandler(*NewRecoverConfig("test")))
+	app.Get("/panic", func(ctx *fiber.Ctx) error {
+		panic("boom")
+	})
+	app.Get("/ok", func(ctx *fiber.Ctx) error {
+		return ctx.SendStatus(fiber.StatusOK)
+	})
+
+	var status int
+	var reqId string
+	out := captureLog(func() {
+		resp, _ := app.Test(httptest.NewRequest(fiber.MethodGet, "/panic", nil))
+		status = resp.StatusCode
+		reqId = resp.Header.Get(utils.REQUEST_ID_HEADER)
+	})
+	assert.Equal(t, fiber.StatusInternalServerError, status)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	assert.Equal(t, 1, len(lines), "Single log line")
+	var msg utils.LoggingMessage
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &msg))
+	assert.Equal(t, utils.LOGGING_ERRORTYPE_SYSTEMERROR, msg.ErrorType)
+	assert.NotEqual(t, "", reqId, "Generated request id echoed")
+	assert.Equal(t, reqId, msg.RequestId, "Logged with the request id")
+
+	out = captureLog(func() {
+		resp, _ := app.Test(httptest.NewRequest(fiber.MethodGet, "/ok", nil))
+		status = resp.StatusCode
+	})
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, "", out, "No log line without panic")
+}
@@ -0,0 +1,26 @@
+package fiber
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+func GetRequestId(ctx *fiber.Ctx) string {
+	reqId, _ := ctx.Locals(utils.REQUEST_ID_LOCALS).(string)
+	return reqId
+}
+
+// assignRequestId takes the client supplied X-Request-ID or generates a new one, echoes it
+// back on the response and makes it available to handlers via Locals and the user context.
+func assignRequestId(ctx *fiber.Ctx) string {
+	reqId := ctx.Get(utils.REQUEST_ID_HEADER)
+	if reqId == "" {
+		reqId = utils.NewRequestId()
+	}
+
+	ctx.Set(utils.REQUEST_ID_HEADER, reqId)
+	ctx.Locals(utils.REQUEST_ID_LOCALS, reqId)
+	ctx.SetUserContext(utils.ContextWithRequestId(ctx.UserContext(), reqId))
+	return reqId
+}
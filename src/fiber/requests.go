@@ -2,8 +2,8 @@ package fiber
 
 import (
 	"encoding/json"
-	"fmt"
 	"strconv"
+	"sync"
 
 	"github.com/gofiber/fiber/v2"
 
@@ -17,6 +17,68 @@ type Config struct {
 	ApiKeys      map[string]*AcquirerUtility
 	Xnap         XnapUtility
 	Name         string
+	Body         BodyPolicy
+	Partners     *partners.PartnerService
+	MaxAge       int32
+
+	validators *validatorCache
+}
+
+type cachedValidator struct {
+	secret    string
+	validator *algorithms.Validator
+}
+
+// Validators kept at most, the cache is emptied when it is full
+const VALIDATOR_CACHE_SIZE = 10000
+
+// validatorCache keeps the validator of every partner API key, it is rebuilt when the secret of
+// the key changes.
+type validatorCache struct {
+	mu      sync.Mutex
+	maxAge  int32
+	entries map[string]cachedValidator
+}
+
+func newValidatorCache(maxAge int32) *validatorCache {
+	return &validatorCache{maxAge: maxAge, entries: make(map[string]cachedValidator)}
+}
+
+// newPartnerValidatorCache drops the validators of the API keys rotated or deleted in s.
+func newPartnerValidatorCache(maxAge int32, s *partners.PartnerService) *validatorCache {
+	c := newValidatorCache(maxAge)
+	s.OnAcquirerChanged(func(change partners.AcquirerChange) {
+		if change.Type == partners.CHANGE_DELETED || change.Type == partners.CHANGE_UPDATED && change.Old.ApiKey != change.New.ApiKey {
+			c.evict(change.Old.ApiKey)
+		}
+	})
+	s.OnIssuerChanged(func(change partners.IssuerChange) {
+		if change.Type == partners.CHANGE_DELETED || change.Type == partners.CHANGE_UPDATED && change.Old.ApiKey != change.New.ApiKey {
+			c.evict(change.Old.ApiKey)
+		}
+	})
+	return c
+}
+
+func (c *validatorCache) get(apiKey, secret string) *algorithms.Validator {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.entries[apiKey]; ok && cached.secret == secret {
+		return cached.validator
+	}
+	// Bounds the entries of the changes missed by a lagging subscription
+	if len(c.entries) >= VALIDATOR_CACHE_SIZE {
+		c.entries = make(map[string]cachedValidator)
+	}
+	validator := (algorithms.NewOneCombineHmac(secret, c.maxAge)).(algorithms.Validator)
+	c.entries[apiKey] = cachedValidator{secret: secret, validator: &validator}
+	return &validator
+}
+
+func (c *validatorCache) evict(apiKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, apiKey)
 }
 
 func GetAcquirerApiKey(ctx *fiber.Ctx) string {
@@ -46,40 +108,22 @@ func NewConfig(name string) *Config {
 	xnapVal := (algorithms.NewOneCombineHmac(aws.XnapSecretKey, int32(age))).(algorithms.Validator)
 	config.Xnap.Validator = &xnapVal
 	config.Name = name
+	config.Body = NewBodyPolicy()
 	return &config
 }
 
+// NewPartnerConfig resolves API keys against the live partner stores, so profile changes
+// (including API key rotation) take effect on the next request.
 func NewPartnerConfig(name string, s *partners.PartnerService) *Config {
 	var config Config
 	aws := utils.NewAwsSecretValues(nil)
 	config.ApiKeys = make(map[string]*AcquirerUtility)
-	acqs, err := s.ListAcquirers()
-	if err != nil {
-		panic(err)
-	}
+	config.Partners = s
 
 	exp := utils.GetEnv(MESSAGE_EXPIRATION_MSEC, "600000")
 	age, _ := strconv.Atoi(exp)
-
-	fmt.Println("[DEBUG] List acquirer profile")
-
-	for _, a := range acqs {
-		fmt.Printf("AcqID: %s APIKey: %s Secret: %s", a.AcqID, a.ApiKey, a.Secret)
-		validator := (algorithms.NewOneCombineHmac(a.Secret, int32(age))).(algorithms.Validator)
-		config.ApiKeys[a.ApiKey] = &AcquirerUtility{validator: &validator, id: a.Name, Hook: a.NotificationHook}
-	}
-
-	// Issuers
-	iss, err := s.ListIssuers()
-	if err != nil {
-		panic(err)
-	}
-	fmt.Println("[DEBUG] List issuer profile")
-	for _, i := range iss {
-		fmt.Printf("IssuerID: %s APIKey: %s Secret: %s", i.IssuerID, i.ApiKey, i.Secret)
-		validator := (algorithms.NewOneCombineHmac(i.Secret, int32(age))).(algorithms.Validator)
-		config.ApiKeys[i.ApiKey] = &AcquirerUtility{validator: &validator, id: i.Name}
-	}
+	config.MaxAge = int32(age)
+	config.validators = newPartnerValidatorCache(config.MaxAge, s)
 
 	config.ErrorHandler = nil
 
@@ -87,10 +131,30 @@ func NewPartnerConfig(name string, s *partners.PartnerService) *Config {
 	xnapVal := (algorithms.NewOneCombineHmac(aws.XnapSecretKey, int32(age))).(algorithms.Validator)
 	config.Xnap.Validator = &xnapVal
 	config.Name = name
+	config.Body = NewBodyPolicy()
 	return &config
 }
 
+// lookupApiKey finds the partner owning the API key, in the partner stores when the config
+// is backed by a PartnerService, in the static ApiKeys map otherwise.
+func (config Config) lookupApiKey(apiKey string) *AcquirerUtility {
+	if config.Partners == nil {
+		return config.ApiKeys[apiKey]
+	}
+
+	if acq := config.Partners.GetAcquirerByApiKey(apiKey); acq != nil {
+		return &AcquirerUtility{validator: config.validators.get(apiKey, acq.Secret), id: acq.Name, Hook: acq.NotificationHook, suspended: !config.Partners.IsAcquirerActive(acq)}
+	}
+	if iss := config.Partners.GetIssuerByApiKey(apiKey); iss != nil {
+		return &AcquirerUtility{validator: config.validators.get(apiKey, iss.Secret), id: iss.Name, suspended: !config.Partners.IsIssuerActive(iss)}
+	}
+	return nil
+}
+
 func NewHandler(config Config) fiber.Handler {
+	if config.Partners != nil && config.validators == nil {
+		config.validators = newPartnerValidatorCache(config.MaxAge, config.Partners)
+	}
 	if config.ErrorHandler == nil {
 		config.ErrorHandler = func(ctx *fiber.Ctx) error {
 			logger := ctx.Locals("logger").(*utils.Logger)
@@ -113,15 +177,14 @@ func NewHandler(config Config) fiber.Handler {
 			apiKey = ctx.GetReqHeaders()["Liquid-Api-Key"]
 		}
 
-		acquirer, ok := config.ApiKeys[apiKey]
+		acquirer := config.lookupApiKey(apiKey)
+		ok = acquirer != nil
 
 		logger := utils.Logger{}
 
 		opts := []utils.Option{}
-		reqId := ctx.GetReqHeaders()["X-Request-ID"]
-		if reqId != "" {
-			opts = append(opts, utils.WithRequestId(reqId))
-		}
+		reqId := assignRequestId(ctx)
+		opts = append(opts, utils.WithRequestId(reqId))
 		userAgent := ctx.GetReqHeaders()["User-Agent"]
 		if userAgent != "" {
 			opts = append(opts, utils.WithUserAgent(userAgent))
@@ -153,17 +216,25 @@ func NewHandler(config Config) fiber.Handler {
 			return err
 		}
 
+		// Suspended partners are refused before any signature check
+		if acquirer.suspended {
+			logger.Msg.HttpStatus = utils.LOGGING_HTTPSTATUS_FORBIDDEN
+			logger.Msg.ErrorType = utils.LOGGING_ERRORTYPE_BUSINESSERROR
+			errResp := APIError{
+				ErrorCode:        PARTNER_SUSPENDED_ERROR_CODE,
+				ErrorDescription: PARTNER_SUSPENDED_ERROR_DESC,
+			}
+			raw, _ := json.Marshal(errResp)
+			err := ctx.Status(fiber.StatusForbidden).SendString(string(raw))
+			defer logger.Print(ctx)
+			return err
+		}
+
 		switch ctx.Method() {
 		case "GET":
-			if acquirer == nil {
-				err := config.ErrorHandler(ctx)
-				defer logger.Print(ctx)
-				return err
-			} else {
-				err := ctx.Next()
-				defer logger.Print(ctx)
-				return err
-			}
+			err := next(ctx, &logger)
+			defer logger.Print(ctx)
+			return err
 		case "POST":
 			fallthrough
 		case "PUT":
@@ -175,9 +246,17 @@ func NewHandler(config Config) fiber.Handler {
 				defer logger.Print(ctx)
 				return err
 			} else {
+				body, status, errResp := config.Body.Enforce(ctx)
+				if errResp != nil {
+					logger.Msg.ErrorType = utils.LOGGING_ERRORTYPE_BUSINESSERROR
+					err := ctx.Status(status).JSON(errResp)
+					defer logger.Print(ctx)
+					return err
+				}
+
 				signature := ctx.GetReqHeaders()["Signature"]
-				if (*validator).Verify(ctx.Body(), signature) {
-					err := ctx.Next()
+				if (*validator).Verify(body, signature) {
+					err := next(ctx, &logger)
 					defer logger.Print(ctx)
 					return err
 				} else {
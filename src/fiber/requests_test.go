@@ -0,0 +1,99 @@
+package fiber
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/onecombine/onecombine-msg-validator/src/partners"
+)
+
+func TestHandlerRefusesSuspendedPartner(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case partners.API_LIST_ACQUIRER_PATH:
+			json.NewEncoder(w).Encode([]*partners.AcquirerProfile{
+				{AcqID: "100090", ApiKey: "KEY-ACTIVE", Secret: "a7Fq2-Lx9Pw-3Zk8"},
+				{AcqID: "100091", ApiKey: "KEY-SUSPENDED", Secret: "a7Fq2-Lx9Pw-3Zk8", Status: partners.PARTNER_STATUS_SUSPENDED},
+			})
+		default:
+			w.Write([]byte("[]"))
+		}
+	}))
+	defer server.Close()
+
+	s := partners.NewPartnewServiceWithoutEvent(server.URL)
+	app := fiber.New()
+	app.Get("/", NewHandler(Config{Name: "test", Partners: s, MaxAge: 600000, Body: NewBodyPolicy()}), func(ctx *fiber.Ctx) error {
+		return ctx.SendStatus(fiber.StatusOK)
+	})
+
+	get := func(apiKey string) (int, APIError) {
+		var apiErr APIError
+		var status int
+		captureLog(func() {
+			req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+			req.Header.Set("X-Api-Key", apiKey)
+			resp, _ := app.Test(req)
+			status = resp.StatusCode
+			body, _ := io.ReadAll(resp.Body)
+			json.Unmarshal(body, &apiErr)
+		})
+		return status, apiErr
+	}
+
+	status, _ := get("KEY-ACTIVE")
+	assert.Equal(t, fiber.StatusOK, status, "Active partner")
+
+	status, apiErr := get("KEY-SUSPENDED")
+	assert.Equal(t, fiber.StatusForbidden, status, "Suspended by profile status")
+	assert.Equal(t, PARTNER_SUSPENDED_ERROR_CODE, apiErr.ErrorCode)
+
+	s.SuspendPartner(partners.PARTNER_TYPE_ACQUIRER, "100090", "compromised")
+	status, apiErr = get("KEY-ACTIVE")
+	assert.Equal(t, fiber.StatusForbidden, status, "Suspended by kill switch")
+	assert.Equal(t, PARTNER_SUSPENDED_ERROR_CODE, apiErr.ErrorCode)
+
+	s.LiftSuspension(partners.PARTNER_TYPE_ACQUIRER, "100090")
+	status, _ = get("KEY-ACTIVE")
+	assert.Equal(t, fiber.StatusOK, status, "Suspension lifted")
+}
+
+func TestValidatorCache(t *testing.T) {
+	cache := newValidatorCache(600000)
+	first := cache.get("KEY-ACTIVE", "a7Fq2-Lx9Pw-3Zk8")
+	assert.Same(t, first, cache.get("KEY-ACTIVE", "a7Fq2-Lx9Pw-3Zk8"), "Validator reused")
+	assert.NotSame(t, first, cache.get("KEY-ACTIVE", "b4Rt8-Mn2Qs-6Yh1"), "Rebuilt on secret rotation")
+}
+
+func TestValidatorCacheEviction(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	s := partners.NewPartnewServiceWithoutEvent(server.URL)
+	cache := newPartnerValidatorCache(600000, s)
+	cached := func(apiKey string) bool {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		_, ok := cache.entries[apiKey]
+		return ok
+	}
+
+	store := s.GetAcquirerStore()
+	store.UpsertLatest(&partners.AcquirerProfile{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"})
+	cache.get("KEY-1", "a7Fq2-Lx9Pw-3Zk8")
+	store.UpsertLatest(&partners.AcquirerProfile{AcqID: "100090", ApiKey: "KEY-2", Secret: "a7Fq2-Lx9Pw-3Zk8"})
+	assert.Eventually(t, func() bool { return !cached("KEY-1") }, time.Second, 10*time.Millisecond, "Rotated key evicted")
+
+	cache.get("KEY-2", "a7Fq2-Lx9Pw-3Zk8")
+	store.Delete("100090")
+	assert.Eventually(t, func() bool { return !cached("KEY-2") }, time.Second, 10*time.Millisecond, "Deleted partner evicted")
+}
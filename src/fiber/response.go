@@ -4,6 +4,8 @@ const UNAUTHORIZED_ERROR_CODE string = "00400001"
 const UNAUTHORIZED_ERROR_DESC string = "Apikey is missing or invalid"
 const INVALID_SIGNATURE_ERROR_CODE string = "00400002"
 const INVALID_SIGNATURE_ERROR_DESC string = "Invalid signature"
+const PARTNER_SUSPENDED_ERROR_CODE string = "00403001"
+const PARTNER_SUSPENDED_ERROR_DESC string = "Partner is suspended"
 
 type APIError struct {
 	ErrorCode        string `json:"error_code"`
@@ -33,10 +33,8 @@ func NewXnapHandler(config XnapConfig) fiber.Handler {
 		logger := utils.Logger{}
 
 		opts := []utils.Option{}
-		reqId := ctx.GetReqHeaders()["X-Request-ID"]
-		if reqId != "" {
-			opts = append(opts, utils.WithRequestId(reqId))
-		}
+		reqId := assignRequestId(ctx)
+		opts = append(opts, utils.WithRequestId(reqId))
 		userAgent := ctx.GetReqHeaders()["User-Agent"]
 		if userAgent != "" {
 			opts = append(opts, utils.WithUserAgent(userAgent))
@@ -61,7 +59,7 @@ func NewXnapHandler(config XnapConfig) fiber.Handler {
 
 		switch ctx.Method() {
 		case "POST":
-			err := ctx.Next()
+			err := next(ctx, &logger)
 			defer logger.Print(ctx)
 			return err
 		case "GET":
@@ -0,0 +1,148 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/onecombine/onecombine-msg-validator/src/partners"
+	"github.com/onecombine/onecombine-msg-validator/src/settlementfx"
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+// LoadedCheck fails until the first successful load from the profile API.
+func LoadedCheck(status *partners.RefreshStatus) Check {
+	return func(ctx context.Context) error {
+		if status.Loaded() {
+			return nil
+		}
+		if err := status.LastError(); err != nil {
+			return fmt.Errorf("initial load failed: %w", err)
+		}
+		return errors.New("initial load not completed")
+	}
+}
+
+// FreshnessCheck fails when the last successful load is older than maxAge.
+func FreshnessCheck(status *partners.RefreshStatus, maxAge time.Duration) Check {
+	return func(ctx context.Context) error {
+		if !status.Loaded() {
+			return errors.New("never refreshed")
+		}
+		if age := time.Since(status.LastSuccess()); age > maxAge {
+			return fmt.Errorf("last successful refresh %s ago", age.Truncate(time.Second))
+		}
+		return nil
+	}
+}
+
+// SnapshotCheck warns while the profiles are served from the snapshot taken when the profile API
+// was last reachable.
+func SnapshotCheck(status *partners.RefreshStatus) Check {
+	return func(ctx context.Context) error {
+		if status.Stale() {
+			return Warning(fmt.Errorf("serving stale profiles of snapshot taken at %s", status.LastSuccess().Format(time.RFC3339)))
+		}
+		return nil
+	}
+}
+
+// StaleRatesCheck warns while some settlement fx rates were not updated for FX_STALE_AFTER.
+func StaleRatesCheck(fx *settlementfx.SettlementFXService) Check {
+	return func(ctx context.Context) error {
+		if stale := fx.GetStaleRates(); len(stale) > 0 {
+			return Warning(fmt.Errorf("stale settlement fx rates: %s", strings.Join(stale, ",")))
+		}
+		return nil
+	}
+}
+
+// ConsumerCheck fails while the consumer is stopped or failed within window. The error of a quiet
+// topic is only cleared by the next fetch, the window keeps a transient error from failing the
+// check until then.
+func ConsumerCheck(stats *partners.ConsumerStats, window time.Duration) Check {
+	return func(ctx context.Context) error {
+		if !stats.Running() {
+			return fmt.Errorf("consumer of topic %s is not running", stats.Topic())
+		}
+		if err := stats.RecentError(window); err != nil {
+			return fmt.Errorf("consumer of topic %s failed: %w", stats.Topic(), err)
+		}
+		return nil
+	}
+}
+
+func CacheCheck(cache *utils.Cache) Check {
+	return func(ctx context.Context) error {
+		return cache.Ping(ctx)
+	}
+}
+
+func SecretsCheck(secrets *utils.AwsSecretValues) Check {
+	return func(ctx context.Context) error {
+		if !secrets.IsLoaded() {
+			return errors.New("secrets are not loaded")
+		}
+		return nil
+	}
+}
+
+// NewValidatorHealth wires the checks of the validator dependencies, nil dependencies are skipped.
+// Freshness checks are only registered when HEALTH_REFRESH_MAX_AGE is set (e.g. "5m"). Consumer
+// errors fail readiness for HEALTH_CONSUMER_ERROR_WINDOW, 1m by default.
+func NewValidatorHealth(s *partners.PartnerService, fx *settlementfx.SettlementFXService, cache *utils.Cache, secrets *utils.AwsSecretValues) *Health {
+	timeout, err := time.ParseDuration(utils.GetEnv(HEALTH_CHECK_TIMEOUT, "2s"))
+	if err != nil {
+		timeout = 2 * time.Second
+	}
+	maxAge, _ := time.ParseDuration(utils.GetEnv(HEALTH_REFRESH_MAX_AGE, ""))
+	errorWindow, err := time.ParseDuration(utils.GetEnv(HEALTH_CONSUMER_ERROR_WINDOW, "1m"))
+	if err != nil {
+		errorWindow = time.Minute
+	}
+
+	h := NewHealth(timeout)
+
+	if s != nil {
+		h.AddReadinessCheck("acquirers", LoadedCheck(s.GetAcquirerRefreshStatus()))
+		h.AddReadinessCheck("issuers", LoadedCheck(s.GetIssuerRefreshStatus()))
+		h.AddReadinessCheck("acquirersSnapshot", SnapshotCheck(s.GetAcquirerRefreshStatus()))
+		h.AddReadinessCheck("issuersSnapshot", SnapshotCheck(s.GetIssuerRefreshStatus()))
+		if maxAge > 0 {
+			h.AddReadinessCheck("acquirersRefresh", FreshnessCheck(s.GetAcquirerRefreshStatus(), maxAge))
+			h.AddReadinessCheck("issuersRefresh", FreshnessCheck(s.GetIssuerRefreshStatus(), maxAge))
+		}
+		if err := s.ConsumerError(); err != nil {
+			h.AddReadinessCheck("profileConsumers", func(ctx context.Context) error { return err })
+		}
+		for _, stats := range s.GetConsumerStats() {
+			h.AddReadinessCheck("consumer:"+stats.Topic(), ConsumerCheck(stats, errorWindow))
+		}
+	}
+
+	if fx != nil {
+		h.AddReadinessCheck("settlementFx", LoadedCheck(fx.GetRefreshStatus()))
+		h.AddReadinessCheck("settlementFxStaleRates", StaleRatesCheck(fx))
+		if maxAge > 0 {
+			h.AddReadinessCheck("settlementFxRefresh", FreshnessCheck(fx.GetRefreshStatus(), maxAge))
+		}
+		if err := fx.ConsumerError(); err != nil {
+			h.AddReadinessCheck("settlementFxConsumer", func(ctx context.Context) error { return err })
+		}
+		if stats := fx.GetConsumerStats(); stats != nil {
+			h.AddReadinessCheck("consumer:"+stats.Topic(), ConsumerCheck(stats, errorWindow))
+		}
+	}
+
+	if cache != nil {
+		h.AddReadinessCheck("redis", CacheCheck(cache))
+	}
+
+	if secrets != nil {
+		h.AddReadinessCheck("secrets", SecretsCheck(secrets))
+	}
+
+	return h
+}
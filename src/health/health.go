@@ -0,0 +1,137 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const HEALTH_CHECK_TIMEOUT string = "HEALTH_CHECK_TIMEOUT"
+const HEALTH_REFRESH_MAX_AGE string = "HEALTH_REFRESH_MAX_AGE"
+const HEALTH_CONSUMER_ERROR_WINDOW string = "HEALTH_CONSUMER_ERROR_WINDOW"
+
+const (
+	STATUS_UP   = "UP"
+	STATUS_DOWN = "DOWN"
+)
+
+// Check returns nil when the dependency is healthy.
+type Check func(ctx context.Context) error
+
+type warning struct {
+	error
+}
+
+// Warning marks a degraded dependency, it is reported without failing the check.
+func Warning(err error) error {
+	return &warning{err}
+}
+
+type CheckResult struct {
+	Status        string `json:"status"`
+	Error         string `json:"error,omitempty"`
+	Warning       string `json:"warning,omitempty"`
+	ExecutionMsec int64  `json:"executionTime"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+type Health struct {
+	mu        sync.RWMutex
+	timeout   time.Duration
+	liveness  []namedCheck
+	readiness []namedCheck
+}
+
+func NewHealth(timeout time.Duration) *Health {
+	return &Health{
+		timeout: timeout,
+	}
+}
+
+// AddLivenessCheck registers a check whose failure means the process should be restarted.
+func (h *Health) AddLivenessCheck(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.liveness = append(h.liveness, namedCheck{name: name, check: check})
+}
+
+// AddReadinessCheck registers a check whose failure means the process should not receive traffic.
+func (h *Health) AddReadinessCheck(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readiness = append(h.readiness, namedCheck{name: name, check: check})
+}
+
+func (h *Health) Liveness(ctx context.Context) *Report {
+	h.mu.RLock()
+	checks := append([]namedCheck{}, h.liveness...)
+	h.mu.RUnlock()
+	return h.run(ctx, checks)
+}
+
+// Readiness runs the liveness checks as well, a pod which is not alive is never ready.
+func (h *Health) Readiness(ctx context.Context) *Report {
+	h.mu.RLock()
+	checks := append(append([]namedCheck{}, h.liveness...), h.readiness...)
+	h.mu.RUnlock()
+	return h.run(ctx, checks)
+}
+
+func (h *Health) run(ctx context.Context, checks []namedCheck) *Report {
+	report := &Report{
+		Status: STATUS_UP,
+		Checks: make(map[string]CheckResult),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c namedCheck) {
+			defer wg.Done()
+
+			checkCtx := ctx
+			if h.timeout > 0 {
+				var cancel context.CancelFunc
+				checkCtx, cancel = context.WithTimeout(ctx, h.timeout)
+				defer cancel()
+			}
+
+			start := time.Now()
+			err := c.check(checkCtx)
+			result := CheckResult{
+				Status:        STATUS_UP,
+				ExecutionMsec: time.Since(start).Milliseconds(),
+			}
+			var w *warning
+			if errors.As(err, &w) {
+				result.Warning = w.Error()
+				err = nil
+			}
+			if err != nil {
+				result.Status = STATUS_DOWN
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[c.name] = result
+			if err != nil {
+				report.Status = STATUS_DOWN
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	return report
+}
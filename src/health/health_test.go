@@ -0,0 +1,90 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/onecombine/onecombine-msg-validator/src/partners"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthReport(t *testing.T) {
+	h := NewHealth(time.Second)
+	h.AddLivenessCheck("alive", func(ctx context.Context) error { return nil })
+	h.AddReadinessCheck("broken", func(ctx context.Context) error { return errors.New("boom") })
+
+	live := h.Liveness(context.TODO())
+	assert.Equal(t, STATUS_UP, live.Status, "Liveness ignores readiness checks")
+	assert.Equal(t, 1, len(live.Checks))
+
+	ready := h.Readiness(context.TODO())
+	assert.Equal(t, STATUS_DOWN, ready.Status, "Readiness fails on a broken check")
+	assert.Equal(t, STATUS_UP, ready.Checks["alive"].Status)
+	assert.Equal(t, STATUS_DOWN, ready.Checks["broken"].Status)
+	assert.Equal(t, "boom", ready.Checks["broken"].Error)
+}
+
+func TestHealthCheckTimeout(t *testing.T) {
+	h := NewHealth(10 * time.Millisecond)
+	h.AddReadinessCheck("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	report := h.Readiness(context.TODO())
+	assert.Equal(t, STATUS_DOWN, report.Status, "Slow check times out")
+}
+
+func TestRefreshChecks(t *testing.T) {
+	status := partners.NewRefreshStatus()
+	assert.NotNil(t, LoadedCheck(status)(context.TODO()), "Not loaded yet")
+	assert.NotNil(t, FreshnessCheck(status, time.Minute)(context.TODO()), "Never refreshed")
+
+	status.Record(errors.New("profile api down"))
+	assert.ErrorContains(t, LoadedCheck(status)(context.TODO()), "profile api down")
+
+	status.Record(nil)
+	assert.Nil(t, LoadedCheck(status)(context.TODO()), "Loaded")
+	assert.Nil(t, FreshnessCheck(status, time.Minute)(context.TODO()), "Fresh")
+	assert.NotNil(t, FreshnessCheck(status, time.Nanosecond)(context.TODO()), "Stale")
+
+	status.Record(errors.New("profile api down"))
+	assert.Nil(t, LoadedCheck(status)(context.TODO()), "Still loaded after a failed refresh")
+}
+
+func TestSnapshotCheck(t *testing.T) {
+	status := partners.NewRefreshStatus()
+	status.Restore(time.Date(2025, 2, 21, 6, 39, 0, 0, time.UTC))
+	assert.Nil(t, LoadedCheck(status)(context.TODO()), "Loaded from snapshot")
+	assert.Equal(t, true, status.Stale())
+
+	h := NewHealth(time.Second)
+	h.AddReadinessCheck("snapshot", SnapshotCheck(status))
+	report := h.Readiness(context.TODO())
+	assert.Equal(t, STATUS_UP, report.Status, "Stale profiles are served")
+	assert.Equal(t, "serving stale profiles of snapshot taken at 2025-02-21T06:39:00Z", report.Checks["snapshot"].Warning)
+
+	status.Record(nil)
+	assert.Equal(t, false, status.Stale(), "Fresh after a successful load")
+	assert.Equal(t, "", h.Readiness(context.TODO()).Checks["snapshot"].Warning)
+}
+
+func TestConsumerCheckErrorWindow(t *testing.T) {
+	stats := partners.NewConsumerStats("profile")
+	check := ConsumerCheck(stats, 50*time.Millisecond)
+	assert.ErrorContains(t, check(context.TODO()), "not running")
+
+	stats.SetRunning(true)
+	stats.SetError(errors.New("broker unreachable"))
+	assert.ErrorContains(t, check(context.TODO()), "broker unreachable")
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Nil(t, check(context.TODO()), "Transient error expired on a quiet topic")
+	assert.NotNil(t, stats.LastError(), "Error kept until the next fetch")
+
+	stats.SetError(errors.New("broker unreachable"))
+	stats.SetError(nil)
+	assert.Nil(t, check(context.TODO()), "Cleared by a successful fetch")
+}
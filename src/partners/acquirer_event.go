@@ -2,13 +2,13 @@ package partners
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/onecombine/onecombine-msg-validator/src/secrets"
 	"github.com/onecombine/onecombine-msg-validator/src/utils"
 	kafka "github.com/segmentio/kafka-go"
 	"github.com/segmentio/kafka-go/sasl/aws_msk_iam_v2"
@@ -56,85 +56,92 @@ type AcquirerProfileEvent struct {
 	SettlementReportBucket string `json:"settlement_report_bucket"`
 	Created                string `json:"created"`
 	Modified               string `json:"modified"`
+	Status                 string `json:"status"`
 }
 
 type AcquirerProfileConsumer interface {
-	Subscribe(wg *sync.WaitGroup) chan string
+	Subscribe(ctx context.Context, wg *sync.WaitGroup) <-chan error
 	Process(e *AcquirerProfileEvent) error
+	Delete(id string) error
+	Stats() *ConsumerStats
 }
 
 type acquirerConsumer struct {
-	store   *MemoryStore
+	store   *AcquirerStore
 	kreader *kafka.Reader
 	cfg     *KafkaConfig
+	stats   *ConsumerStats
+	policy  ConsumePolicy
+	cipher  secrets.Cipher
 }
 
 // Process implements IssuerProfileConsumer.
 func (a *acquirerConsumer) Process(e *AcquirerProfileEvent) error {
-	var key string
-	for k, v := range a.store.GetAll() {
-		if v.(*AcquirerProfile).AcqID == e.AcqID {
-			key = k
-			break
-		}
+	transition, err := upsertAcquirer(a.store, eventToAcquirerProfile(e))
+	if err != nil {
+		return err
 	}
+	fmt.Printf("Update acquirer profile in memory storage (AcqID: %s, %s)\n", e.AcqID, transition)
+	return nil
+}
 
-	a.store.Set(key, eventToAcquirerProfile(e))
+// Delete implements AcquirerProfileConsumer, it handles tombstones and DELETED events.
+func (a *acquirerConsumer) Delete(id string) error {
+	if id == "" {
+		return fmt.Errorf("%w: message key", ErrMissingField)
+	}
+	deleteAcquirer(a.store, id)
 	return nil
 }
 
-// Subscribe implements IssuerProfileConsumer.
-func (i *acquirerConsumer) Subscribe(wg *sync.WaitGroup) chan string {
-
-	cls := make(chan string)
-	topic := i.cfg.TopicName
-
-	go func() {
-		fmt.Printf("Subscribe to issuer profile event stream, topic: %s\n", topic)
-
-		for {
-			select {
-			case <-cls:
-				fmt.Printf("Stop subscribe topic: %s\n", i.cfg.TopicName)
-				i.kreader.Close()
-				wg.Done()
-				close(cls)
-				return
-			default:
-				fmt.Println("Fetching message")
-				msg, err := i.kreader.FetchMessage(context.TODO())
-				if err != nil {
-					fmt.Printf("Error fetch message from kafka, error: %v\n", err)
-					continue
-				}
-
-				var event AcquirerProfileEvent
-				err = json.Unmarshal(msg.Value, &event)
-				if err != nil {
-					fmt.Printf("Unable to unmarshal event message, error: %v\n", err)
-					i.kreader.CommitMessages(context.TODO(), msg)
-					continue
-				}
-
-				err = i.Process(&event)
-				if err != nil {
-					fmt.Printf("Unable to process profile event, error: %v\n", err)
-				} else {
-					fmt.Printf("Process acquirer profile (id: %s) successfully\n", event.AcqID)
-				}
-
-				i.kreader.CommitMessages(context.TODO(), msg)
-				time.Sleep(100 * time.Millisecond)
-			}
+// Stats implements AcquirerProfileConsumer.
+func (a *acquirerConsumer) Stats() *ConsumerStats {
+	return a.stats
+}
+
+// Subscribe implements AcquirerProfileConsumer, it consumes the profile topic until ctx is cancelled.
+func (a *acquirerConsumer) Subscribe(ctx context.Context, wg *sync.WaitGroup) <-chan error {
+	return Consume(ctx, wg, a.kreader, a.stats, a.policy, a.handle)
+}
+
+func (a *acquirerConsumer) handle(ctx context.Context, msg kafka.Message) error {
+	event, env, err := decodeAcquirerEvent(msg)
+	if err != nil {
+		fmt.Printf("Unable to decode event message, error: %v\n", err)
+		return Permanent(err)
+	}
+
+	if event == nil {
+		err = a.Delete(string(msg.Key))
+		if err != nil {
+			fmt.Printf("Unable to process profile deletion, error: %v\n", err)
 		}
+		return err
+	}
 
-	}()
+	event.Secret, err = secrets.Decrypt(ctx, a.cipher, event.Secret, event.AcqID)
+	if err != nil {
+		fmt.Printf("Unable to decrypt acquirer secret (AcqID: %s), error: %v\n", event.AcqID, err)
+		return err
+	}
 
-	wg.Add(1)
-	return cls
+	err = a.Process(event)
+	if err != nil {
+		fmt.Printf("Unable to process profile event, error: %v\n", err)
+		return err
+	}
+	fmt.Printf("Process acquirer profile (id: %s, eventId: %s, requestId: %s) successfully\n", event.AcqID, eventId(env), utils.RequestIdFromHeaders(msg.Headers))
+	return nil
 }
 
-func NewKafkaAcquirerProfileConsumer(store *MemoryStore, cfg *KafkaConfig) AcquirerProfileConsumer {
+// NewKafkaAcquirerProfileConsumer fails when the secret key provider or the kafka authentication
+// cannot be configured.
+func NewKafkaAcquirerProfileConsumer(store *AcquirerStore, cfg *KafkaConfig) (AcquirerProfileConsumer, error) {
+	cipher, err := secrets.FromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("secret key provider: %w", err)
+	}
+
 	hosts := strings.Split(cfg.Bootstrap, ",")
 
 	var dialer *kafka.Dialer
@@ -142,7 +149,7 @@ func NewKafkaAcquirerProfileConsumer(store *MemoryStore, cfg *KafkaConfig) Acqui
 	case utils.MSK:
 		awsConfig, err := config.LoadDefaultConfig(context.Background())
 		if err != nil {
-			return nil
+			return nil, fmt.Errorf("msk authentication: %w", err)
 		}
 		dialer = &kafka.Dialer{
 			DualStack:     false,
@@ -184,7 +191,10 @@ func NewKafkaAcquirerProfileConsumer(store *MemoryStore, cfg *KafkaConfig) Acqui
 		kreader: reader,
 		store:   store,
 		cfg:     cfg,
-	}
+		stats:   NewConsumerStats(cfg.TopicName),
+		policy:  NewConsumePolicy(cfg, dialer, 100*time.Millisecond),
+		cipher:  cipher,
+	}, nil
 }
 
 func eventToAcquirerProfile(e *AcquirerProfileEvent) *AcquirerProfile {
@@ -195,16 +205,18 @@ func eventToAcquirerProfile(e *AcquirerProfileEvent) *AcquirerProfile {
 		Description:            e.Description,
 		ApiKey:                 e.ApiKey,
 		Secret:                 e.Secret,
+		NotificationHook:       e.NotificationHook,
 		OrganizationID:         e.OrganizationID,
 		SettlementFee:          e.SettlementFee,
 		SettlementType:         e.SettlementType,
 		SettlementWaived:       e.SettlementWaived,
 		SwitchingFee:           e.SwitchingFee,
 		SwitchingType:          e.SwitchingType,
-		SwitchingWaived:        e.SettlementWaived,
+		SwitchingWaived:        e.SwitchingWaived,
 		SettlementCurrencyCode: e.SettlementCurrencyCode,
 		SettlementReportBucket: e.SettlementReportBucket,
 		Created:                e.Created,
 		Modified:               e.Modified,
+		Status:                 e.Status,
 	}
 }
@@ -0,0 +1,139 @@
+package partners
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	CHANGE_CREATED = "CREATED"
+	CHANGE_UPDATED = "UPDATED"
+	CHANGE_DELETED = "DELETED"
+)
+
+// Changes queued per subscriber, the oldest ones are dropped when a subscriber lags behind
+const CHANGE_QUEUE_SIZE = 1024
+
+// Change describes a store write. Old is the zero value on creation and New on deletion.
+type Change[T any] struct {
+	Type string
+	Key  string
+	Old  T
+	New  T
+}
+
+type AcquirerChange = Change[*AcquirerProfile]
+type IssuerChange = Change[*IssuerProfile]
+
+// ChangeFeed delivers the changes of a store to its subscribers. Each subscriber has its own
+// queue and goroutine, it receives the changes in write order and never blocks the writers.
+type ChangeFeed[T any] struct {
+	mu          sync.Mutex
+	next        int
+	subscribers map[int]*subscriber[T]
+}
+
+type subscriber[T any] struct {
+	fn      func(Change[T])
+	mu      sync.Mutex
+	queue   []Change[T]
+	wake    chan struct{}
+	done    chan struct{}
+	dropped atomic.Uint64
+}
+
+func NewChangeFeed[T any]() *ChangeFeed[T] {
+	return &ChangeFeed[T]{subscribers: make(map[int]*subscriber[T])}
+}
+
+// Subscribe calls fn for every change until the returned function is called.
+func (f *ChangeFeed[T]) Subscribe(fn func(Change[T])) func() {
+	sub := &subscriber[T]{
+		fn:   fn,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+
+	f.mu.Lock()
+	id := f.next
+	f.next++
+	f.subscribers[id] = sub
+	f.mu.Unlock()
+
+	go sub.run()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			f.mu.Lock()
+			delete(f.subscribers, id)
+			f.mu.Unlock()
+			close(sub.done)
+		})
+	}
+}
+
+// Publish queues change for every subscriber and returns without waiting for them.
+func (f *ChangeFeed[T]) Publish(change Change[T]) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, sub := range f.subscribers {
+		sub.push(change)
+	}
+}
+
+func (s *subscriber[T]) push(change Change[T]) {
+	s.mu.Lock()
+	if len(s.queue) >= CHANGE_QUEUE_SIZE {
+		s.queue = s.queue[1:]
+		if s.dropped.Add(1) == 1 {
+			fmt.Printf("Change subscriber lags behind, drop oldest changes\n")
+		}
+	}
+	s.queue = append(s.queue, change)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscriber[T]) run() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		}
+
+		for {
+			s.mu.Lock()
+			if len(s.queue) == 0 {
+				s.mu.Unlock()
+				break
+			}
+			change := s.queue[0]
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			s.deliver(change)
+		}
+	}
+}
+
+// deliver isolates the subscriber panics, a faulty subscriber keeps receiving the next changes.
+func (s *subscriber[T]) deliver(change Change[T]) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("Change subscriber failed on %s %s, error: %v\n", change.Type, change.Key, r)
+		}
+	}()
+	s.fn(change)
+}
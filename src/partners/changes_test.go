@@ -0,0 +1,102 @@
+package partners
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreChanges(t *testing.T) {
+	store := NewAcquirerStore()
+	changes := make(chan AcquirerChange, 10)
+	unsubscribe := store.Changes().Subscribe(func(c AcquirerChange) { changes <- c })
+
+	v1 := &AcquirerProfile{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"}
+	v2 := &AcquirerProfile{AcqID: "100090", ApiKey: "KEY-2", Secret: "a7Fq2-Lx9Pw-3Zk8"}
+	upsertAcquirer(store, v1)
+	version, _ := store.EntityVersion("100090")
+	upsertAcquirer(store, &AcquirerProfile{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"})
+	assert.Equal(t, uint64(1), store.Version(), "Identical write not versioned")
+	unchanged, _ := store.EntityVersion("100090")
+	assert.Equal(t, version, unchanged)
+	upsertAcquirer(store, v2)
+	deleteAcquirer(store, "100090")
+
+	assert.Equal(t, AcquirerChange{Type: CHANGE_CREATED, Key: "100090", New: v1}, <-changes)
+	assert.Equal(t, AcquirerChange{Type: CHANGE_UPDATED, Key: "100090", Old: v1, New: v2}, <-changes, "Identical write skipped")
+	assert.Equal(t, AcquirerChange{Type: CHANGE_DELETED, Key: "100090", Old: v2}, <-changes)
+
+	unsubscribe()
+	upsertAcquirer(store, v1)
+	select {
+	case c := <-changes:
+		t.Fatalf("Change delivered after unsubscribe: %v", c)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestWriteHooks(t *testing.T) {
+	store := NewStore(func(s string) string { return s }, nil)
+	var types []string
+	store.AddWriteHook(func(c Change[string]) {
+		_, ok := store.Get(c.Key)
+		assert.Equal(t, c.Type != CHANGE_DELETED, ok, "Store unlocked and written")
+		types = append(types, c.Type)
+	})
+
+	for i := 0; i < CHANGE_QUEUE_SIZE+10; i++ {
+		store.Upsert(strconv.Itoa(i))
+	}
+	store.Upsert("0")
+	store.Delete("0")
+	assert.Equal(t, CHANGE_QUEUE_SIZE+12, len(types), "Every change delivered before the write returns")
+	assert.Equal(t, CHANGE_DELETED, types[len(types)-1])
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	store := NewAcquirerStore()
+	release := make(chan struct{})
+	var keys []string
+	done := make(chan struct{})
+	store.Changes().Subscribe(func(c AcquirerChange) {
+		<-release
+		keys = append(keys, c.Key)
+		if len(keys) == 3 {
+			close(done)
+		}
+	})
+	store.Changes().Subscribe(func(c AcquirerChange) { panic("faulty subscriber") })
+
+	// Writes complete while the subscriber is blocked
+	for _, id := range []string{"1", "2", "3"} {
+		_, err := upsertAcquirer(store, &AcquirerProfile{AcqID: id, ApiKey: "KEY-" + id, Secret: "a7Fq2-Lx9Pw-3Zk8"})
+		assert.Nil(t, err)
+	}
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Changes not delivered")
+	}
+	assert.Equal(t, []string{"1", "2", "3"}, keys, "Delivered in write order")
+}
+
+func TestOnAcquirerChangedFromRefresh(t *testing.T) {
+	s := newPartnerService(context.Background(), NewStaticSource([]*AcquirerProfile{{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"}}, nil))
+	changes := make(chan AcquirerChange, 10)
+	s.OnAcquirerChanged(func(c AcquirerChange) { changes <- c })
+
+	s.initialLoad()
+	assert.Equal(t, CHANGE_CREATED, (<-changes).Type)
+
+	s.source = NewStaticSource([]*AcquirerProfile{{AcqID: "100091", ApiKey: "KEY-2", Secret: "a7Fq2-Lx9Pw-3Zk8"}}, nil)
+	assert.Nil(t, s.Reload())
+	assert.Equal(t, CHANGE_CREATED, (<-changes).Type)
+	change := <-changes
+	assert.Equal(t, CHANGE_DELETED, change.Type, "Pruned partner")
+	assert.Equal(t, "KEY-1", change.Old.ApiKey)
+}
@@ -0,0 +1,160 @@
+package partners
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/onecombine/onecombine-msg-validator/src/messages"
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+// Version written in the protobuf profiles, consumers accept any v1.x
+const PROFILE_PROTO_VERSION = "v1.0"
+
+var ErrUnsupportedVersion = errors.New("unsupported profile version")
+var ErrUnsupportedContentType = errors.New("unsupported content type")
+
+// Payload types carried in the event envelope
+const (
+	PAYLOAD_TYPE_ACQUIRER_PROFILE = "messages.AcquirerProfile"
+	PAYLOAD_TYPE_ISSUER_PROFILE   = "messages.IssuerProfile"
+)
+
+func checkProfileVersion(version string) error {
+	// Profiles published before the version field was filled carry none
+	if version == "" || version == "v1" || strings.HasPrefix(version, "v1.") {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrUnsupportedVersion, version)
+}
+
+// decodeAcquirerEvent decodes a JSON or protobuf acquirer profile message, enveloped or bare, so
+// topics can carry every format while producers migrate. The event is nil for tombstones and
+// DELETED events, the partner id is then the message key.
+func decodeAcquirerEvent(msg kafka.Message) (*AcquirerProfileEvent, *utils.EventEnvelope, error) {
+	env, payload, deleted, err := openProfileMessage(msg)
+	if err != nil || deleted {
+		return nil, env, err
+	}
+
+	var event AcquirerProfileEvent
+	switch contentType := utils.ContentTypeOf(msg); contentType {
+	case utils.CONTENT_TYPE_JSON:
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, env, err
+		}
+	case utils.CONTENT_TYPE_PROTOBUF:
+		var profile pb.AcquirerProfile
+		if err := proto.Unmarshal(payload, &profile); err != nil {
+			return nil, env, err
+		}
+		if err := checkProfileVersion(profile.Version); err != nil {
+			return nil, env, err
+		}
+		event = AcquirerProfileEvent{
+			ID:                     uint(profile.Id),
+			AcqID:                  profile.AcqId,
+			Name:                   profile.Name,
+			Description:            profile.Description,
+			ApiKey:                 profile.ApiKey,
+			Secret:                 profile.Secret,
+			NotificationHook:       profile.NotificationWebhook,
+			OrganizationID:         uint(profile.OrgId),
+			SettlementFee:          profile.SettlementFee,
+			SettlementType:         profile.SettlementFeeType,
+			SettlementWaived:       profile.SettlementFeeWaived,
+			SwitchingFee:           profile.SwitchingFee,
+			SwitchingType:          profile.SwitchingFeeType,
+			SwitchingWaived:        profile.SwitchingFeeWaived,
+			SettlementCurrencyCode: profile.SettlementCurrencyCode,
+			SettlementReportBucket: profile.SettlementReportBucket,
+			Created:                profile.Created,
+			Modified:               profile.Modified,
+			Status:                 profile.Status,
+		}
+	default:
+		return nil, env, fmt.Errorf("%w: %s", ErrUnsupportedContentType, contentType)
+	}
+	return &event, env, nil
+}
+
+func decodeIssuerEvent(msg kafka.Message) (*IssuerProfileEvent, *utils.EventEnvelope, error) {
+	env, payload, deleted, err := openProfileMessage(msg)
+	if err != nil || deleted {
+		return nil, env, err
+	}
+
+	var event IssuerProfileEvent
+	switch contentType := utils.ContentTypeOf(msg); contentType {
+	case utils.CONTENT_TYPE_JSON:
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, env, err
+		}
+	case utils.CONTENT_TYPE_PROTOBUF:
+		var profile pb.IssuerProfile
+		if err := proto.Unmarshal(payload, &profile); err != nil {
+			return nil, env, err
+		}
+		if err := checkProfileVersion(profile.Version); err != nil {
+			return nil, env, err
+		}
+		event = IssuerProfileEvent{
+			ID:                              uint(profile.Id),
+			IssuerID:                        profile.IssuerId,
+			Name:                            profile.Name,
+			Description:                     profile.Description,
+			ApiKey:                          profile.ApiKey,
+			Secret:                          profile.Secret,
+			OrganizationID:                  uint(profile.OrgId),
+			FxName:                          profile.FxName,
+			FXValue:                         profile.FxValue,
+			SettlementFee:                   profile.SettlementFee,
+			SettlementFeeType:               profile.SettlementFeeType,
+			SettlementFeeWaived:             profile.SettlementFeeWaived,
+			SwitchingFee:                    profile.SwitchingFee,
+			SwitchingFeeType:                profile.SwitchingFeeType,
+			SwitchingFeeWaived:              profile.SwitchingFeeWaived,
+			SettlementCurrencyCode:          profile.SettlementCurrencyCode,
+			SettlementReportBucket:          profile.SettlementReportBucket,
+			RefundNotificationWebHook:       profile.RefundNotificationWebhook,
+			CancellationNotificationWebHook: profile.CalcellationNotificationWebhook,
+			Created:                         profile.Created,
+			Modified:                        profile.Modified,
+			Status:                          profile.Status,
+		}
+	default:
+		return nil, env, fmt.Errorf("%w: %s", ErrUnsupportedContentType, contentType)
+	}
+	return &event, env, nil
+}
+
+func openProfileMessage(msg kafka.Message) (*utils.EventEnvelope, []byte, bool, error) {
+	// A message without value is the tombstone of a deleted partner
+	if len(msg.Value) == 0 {
+		return nil, nil, true, nil
+	}
+
+	env, payload, err := utils.OpenEnvelope(msg)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if env == nil {
+		return nil, payload, false, nil
+	}
+	if err := checkProfileVersion(env.SchemaVersion); err != nil {
+		return env, nil, false, err
+	}
+	return env, payload, env.EventType == utils.EVENT_TYPE_DELETED || len(payload) == 0, nil
+}
+
+func eventId(env *utils.EventEnvelope) string {
+	if env == nil {
+		return ""
+	}
+	return env.EventID
+}
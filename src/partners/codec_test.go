@@ -0,0 +1,161 @@
+package partners
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/onecombine/onecombine-msg-validator/src/messages"
+	"github.com/onecombine/onecombine-msg-validator/src/secrets"
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+func TestDecodeAcquirerEvent(t *testing.T) {
+	acq := &AcquirerProfile{
+		ID:                     2,
+		AcqID:                  "100090",
+		Name:                   "Legacy Mock Acquirer",
+		ApiKey:                 "ABCD-ABCD-ABCD",
+		Secret:                 "a7Fq2-Lx9Pw-3Zk8",
+		NotificationHook:       "https://hook",
+		OrganizationID:         4,
+		SettlementFee:          "0.60",
+		SettlementType:         "PCT",
+		SwitchingFee:           "0.61",
+		SwitchingType:          "ABS",
+		SwitchingWaived:        true,
+		SettlementCurrencyCode: "THB",
+		Modified:               "2025-02-21T06:39:00Z",
+		Status:                 PARTNER_STATUS_SUSPENDED,
+	}
+
+	pbMsg, err := acquirerProfileMessage(context.Background(), acq)
+	assert.Nil(t, err)
+	event, _, err := decodeAcquirerEvent(pbMsg)
+	assert.Nil(t, err)
+	assert.Equal(t, acq, eventToAcquirerProfile(event), "Protobuf round trip")
+
+	// JSON published before the content-type header existed
+	raw, _ := json.Marshal(acq)
+	event, _, err = decodeAcquirerEvent(kafka.Message{Value: raw})
+	assert.Nil(t, err)
+	assert.Equal(t, acq, eventToAcquirerProfile(event), "JSON without header")
+
+	// Bare protobuf without header is sniffed
+	raw, _ = proto.Marshal(&pb.AcquirerProfile{Version: PROFILE_PROTO_VERSION, AcqId: acq.AcqID})
+	event, _, err = decodeAcquirerEvent(kafka.Message{Value: raw})
+	assert.Nil(t, err)
+	assert.Equal(t, acq.AcqID, event.AcqID, "Protobuf without header")
+
+	_, _, err = decodeAcquirerEvent(kafka.Message{Value: []byte("<xml/>"), Headers: []kafka.Header{utils.ContentTypeHeader("text/xml")}})
+	assert.ErrorIs(t, err, ErrUnsupportedContentType)
+}
+
+func TestDecodeIssuerEvent(t *testing.T) {
+	iss := &IssuerProfile{
+		ID:                           4,
+		IssuerID:                     "200099",
+		Name:                         "mock issuer for test",
+		ApiKey:                       "0439BA10-205B-46AE-9963-1FB22642D8AF",
+		Secret:                       "6dc010a7952422a",
+		OrganizationID:               4,
+		FXName:                       "RUBTHB",
+		FXValue:                      "0.35",
+		SettlementWaived:             true,
+		RefundNotificationWebHook:    "https://refund",
+		CancelledNotificationWebHook: "https://cancel",
+		Created:                      "2025-01-01T00:00:00Z",
+	}
+
+	pbMsg, err := issuerProfileMessage(context.Background(), iss)
+	assert.Nil(t, err)
+	event, _, err := decodeIssuerEvent(pbMsg)
+	assert.Nil(t, err)
+	assert.Equal(t, iss, eventToIssuerProfile(event), "Protobuf round trip")
+
+	raw, _ := json.Marshal(iss)
+	event, _, err = decodeIssuerEvent(kafka.Message{Value: raw, Headers: []kafka.Header{utils.ContentTypeHeader(utils.CONTENT_TYPE_JSON)}})
+	assert.Nil(t, err)
+	assert.Equal(t, iss, eventToIssuerProfile(event), "JSON with header")
+}
+
+func TestDecodeProfileVersion(t *testing.T) {
+	for version, supported := range map[string]bool{"": true, "v1": true, "v1.0": true, "v1.3": true, "v2.0": false, "v10.0": false} {
+		raw, _ := proto.Marshal(&pb.AcquirerProfile{Version: version, AcqId: "100090"})
+		_, _, err := decodeAcquirerEvent(kafka.Message{Value: raw, Headers: []kafka.Header{utils.ContentTypeHeader(utils.CONTENT_TYPE_PROTOBUF)}})
+		if supported {
+			assert.Nil(t, err, "Version %q", version)
+		} else {
+			assert.ErrorIs(t, err, ErrUnsupportedVersion, "Version %q", version)
+		}
+	}
+}
+
+func TestDecodeEnvelopedEvents(t *testing.T) {
+	t.Setenv(utils.EVENT_ENVELOPE_ENABLED, "true")
+	ctx := utils.ContextWithRequestId(context.Background(), "REQ-001")
+	acq := &AcquirerProfile{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8", Created: "2025-02-21T06:39:00Z", Modified: "2025-02-21T06:39:00Z"}
+
+	pbMsg, _ := acquirerProfileMessage(ctx, acq)
+	event, env, err := decodeAcquirerEvent(pbMsg)
+	assert.Nil(t, err)
+	assert.Equal(t, acq, eventToAcquirerProfile(event))
+	assert.Equal(t, utils.EVENT_TYPE_CREATED, env.EventType)
+	assert.Equal(t, PAYLOAD_TYPE_ACQUIRER_PROFILE, env.PayloadType)
+	assert.Equal(t, "REQ-001", env.CorrelationID)
+	assert.NotEqual(t, "", env.EventID)
+
+	for _, contentType := range []string{utils.CONTENT_TYPE_JSON, utils.CONTENT_TYPE_PROTOBUF} {
+		msg, err := deletedMessage(ctx, PAYLOAD_TYPE_ISSUER_PROFILE, "200099", contentType)
+		assert.Nil(t, err)
+		event, env, err := decodeIssuerEvent(msg)
+		assert.Nil(t, err)
+		assert.Nil(t, event, "DELETED event (%s)", contentType)
+		assert.Equal(t, utils.EVENT_TYPE_DELETED, env.EventType)
+		assert.Equal(t, "200099", string(msg.Key))
+	}
+
+	// Legacy tombstone
+	event, env, err = decodeAcquirerEvent(kafka.Message{Key: []byte("100090")})
+	assert.Nil(t, err)
+	assert.Nil(t, event)
+	assert.Nil(t, env)
+
+	// Envelope of a newer major schema version
+	newer := utils.NewEventEnvelope(ctx, utils.EVENT_TYPE_UPDATED, PAYLOAD_TYPE_ACQUIRER_PROFILE, "v2.0")
+	raw, _ := json.Marshal(acq)
+	msg, _ := newer.Message([]byte(acq.AcqID), utils.CONTENT_TYPE_JSON, raw)
+	_, _, err = decodeAcquirerEvent(msg)
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+}
+
+func TestHandleEncryptedSecret(t *testing.T) {
+	ctx := context.Background()
+	provider, err := secrets.NewStaticKeyProvider(bytes.Repeat([]byte{7}, secrets.DATA_KEY_SIZE))
+	assert.Nil(t, err)
+	cipher := secrets.NewEnvelopeCipher(provider, time.Hour, true)
+
+	acq := &AcquirerProfile{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8", Modified: "2025-02-21T06:39:00Z"}
+	sealed, err := sealAcquirer(ctx, cipher, acq)
+	assert.Nil(t, err)
+	assert.Equal(t, "a7Fq2-Lx9Pw-3Zk8", acq.Secret, "Profile left untouched")
+	assert.Equal(t, true, secrets.IsEncrypted(sealed.Secret))
+
+	msg, _ := acquirerProfileMessage(ctx, sealed)
+	consumer := &acquirerConsumer{store: NewAcquirerStore(), cipher: cipher}
+	assert.Nil(t, consumer.handle(ctx, msg))
+	stored, _ := consumer.store.Get("100090")
+	assert.Equal(t, "a7Fq2-Lx9Pw-3Zk8", stored.Secret, "Secret decrypted on receipt")
+
+	// Strict mode rejects plaintext secrets
+	plain, _ := acquirerProfileMessage(ctx, &AcquirerProfile{AcqID: "100091", ApiKey: "KEY-2", Secret: "b4Rt8-Mn2Qs-6Yh1"})
+	err = consumer.handle(ctx, plain)
+	assert.ErrorIs(t, err, secrets.ErrPlaintextSecret)
+	assert.Equal(t, true, isPermanent(err), "Dead-lettered without retry")
+}
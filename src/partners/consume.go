@@ -0,0 +1,269 @@
+package partners
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/onecombine/onecombine-msg-validator/src/secrets"
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// Time given to commit the offset of the message in flight when the consumer is cancelled
+const CONSUMER_COMMIT_TIMEOUT = 5 * time.Second
+
+// Size of the error channels returned by the consumers, errors are dropped when it is full
+const CONSUMER_ERROR_BUFFER = 16
+
+// Headers added to the messages written to the dead-letter topic
+const (
+	DLQ_HEADER_ERROR     = "dlq-error"
+	DLQ_HEADER_TOPIC     = "dlq-original-topic"
+	DLQ_HEADER_PARTITION = "dlq-original-partition"
+	DLQ_HEADER_OFFSET    = "dlq-original-offset"
+	DLQ_HEADER_ATTEMPTS  = "dlq-attempts"
+	DLQ_HEADER_FAILED_AT = "dlq-failed-at"
+)
+
+// ErrPermanent marks the errors which no retry can fix, e.g. a payload which cannot be decoded.
+var ErrPermanent = errors.New("permanent failure")
+
+// Permanent wraps err so the message is dead-lettered without retry.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrPermanent, err)
+}
+
+func isPermanent(err error) bool {
+	for _, target := range []error{ErrPermanent, ErrMissingField, ErrInvalidField, ErrIndexConflict, ErrUnsupportedVersion, ErrUnsupportedContentType, utils.ErrUnsupportedEnvelope, secrets.ErrDecrypt, secrets.ErrPlaintextSecret, secrets.ErrNoKeyProvider} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// MessageHandler processes a message fetched from kafka. Failed messages are retried, then
+// written to the dead-letter topic if any, and committed.
+type MessageHandler func(ctx context.Context, msg kafka.Message) error
+
+// ConsumePolicy configures the pace, retries and dead-lettering of a consumer.
+type ConsumePolicy struct {
+	// Pause between two messages
+	Pause time.Duration
+	// Number of retries of a failed message, 0 disables the retries
+	MaxRetries int
+	// First retry delay, doubled on every attempt up to MaxBackoff. Also used on fetch errors.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Writer of the dead-letter topic, nil drops the failed messages
+	DeadLetter utils.IKafkaWriter
+}
+
+// NewConsumePolicy reads the retry settings of cfg, using 3 retries from 200ms up to 30s by
+// default. The dead-letter writer shares the dialer of the consumer.
+func NewConsumePolicy(cfg *KafkaConfig, dialer *kafka.Dialer, pause time.Duration) ConsumePolicy {
+	policy := ConsumePolicy{
+		Pause:      pause,
+		MaxRetries: 3,
+		Backoff:    200 * time.Millisecond,
+		MaxBackoff: 30 * time.Second,
+	}
+
+	if cfg.MaxRetries != "" {
+		if n, err := strconv.Atoi(cfg.MaxRetries); err == nil && n >= 0 {
+			policy.MaxRetries = n
+		}
+	}
+	if d, err := time.ParseDuration(cfg.RetryBackoff); err == nil && d > 0 {
+		policy.Backoff = d
+	}
+	if d, err := time.ParseDuration(cfg.MaxRetryBackoff); err == nil && d > 0 {
+		policy.MaxBackoff = d
+	}
+
+	if cfg.DeadLetterTopicName != "" {
+		policy.DeadLetter = kafka.NewWriter(kafka.WriterConfig{
+			Brokers:  strings.Split(cfg.Bootstrap, ","),
+			Topic:    cfg.DeadLetterTopicName,
+			Balancer: &kafka.Hash{},
+			Dialer:   dialer,
+		})
+	}
+	return policy
+}
+
+// backoff returns the delay before the given retry, starting at 1.
+func (p ConsumePolicy) backoff(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// Consume fetches and handles the messages of reader until ctx is cancelled, then closes the
+// reader and the dead-letter writer, flushing its pending messages. Fetch, handling and commit errors are reported on the returned channel, which is
+// closed when the consumer stops.
+func Consume(ctx context.Context, wg *sync.WaitGroup, reader utils.IKafkaReader, stats *ConsumerStats, policy ConsumePolicy, handle MessageHandler) <-chan error {
+	errs := make(chan error, CONSUMER_ERROR_BUFFER)
+	topic := stats.Topic()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(errs)
+
+		fmt.Printf("Subscribe to event stream, topic: %s\n", topic)
+		stats.SetRunning(true)
+		defer func() {
+			stats.SetRunning(false)
+			reader.Close()
+			if closer, ok := policy.DeadLetter.(io.Closer); ok {
+				if err := closer.Close(); err != nil {
+					fmt.Printf("Unable to close dead-letter writer (topic: %s), error: %v\n", topic, err)
+				}
+			}
+			fmt.Printf("Stop subscribe topic: %s\n", topic)
+		}()
+
+		fetchFailures := 0
+		for {
+			msg, err := reader.FetchMessage(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				fetchFailures++
+				fmt.Printf("Error fetch message from kafka (topic: %s), error: %v\n", topic, err)
+				stats.SetError(err)
+				ReportError(errs, err)
+				if !sleep(ctx, policy.backoff(fetchFailures)) {
+					return
+				}
+				continue
+			}
+			fetchFailures = 0
+			stats.SetError(nil)
+
+			if !handleMessage(ctx, msg, stats, policy, handle, errs) {
+				// Cancelled before the message was handled, it is delivered again after restart
+				return
+			}
+
+			// The message has been handled, commit it even when the consumer is being cancelled
+			commitCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), CONSUMER_COMMIT_TIMEOUT)
+			err = reader.CommitMessages(commitCtx, msg)
+			cancel()
+			if err != nil {
+				fmt.Printf("Unable to commit message (topic: %s, offset: %d), error: %v\n", topic, msg.Offset, err)
+				stats.SetError(err)
+				ReportError(errs, err)
+			} else {
+				stats.Track(msg)
+			}
+
+			if !sleep(ctx, policy.Pause) {
+				return
+			}
+		}
+	}()
+
+	return errs
+}
+
+// handleMessage runs the handler with retries and dead-letters the message when it keeps
+// failing. It returns false when ctx is cancelled before the message is settled.
+func handleMessage(ctx context.Context, msg kafka.Message, stats *ConsumerStats, policy ConsumePolicy, handle MessageHandler, errs chan<- error) bool {
+	attempts := 0
+	for {
+		attempts++
+		err := handle(ctx, msg)
+		if err == nil {
+			return true
+		}
+		if errors.Is(err, ErrStaleWrite) {
+			// An older event delivered late, the store already holds a newer version
+			fmt.Printf("Skip stale message (topic: %s, offset: %d), error: %v\n", msg.Topic, msg.Offset, err)
+			return true
+		}
+		ReportError(errs, err)
+
+		if isPermanent(err) || attempts > policy.MaxRetries {
+			return deadLetter(ctx, msg, err, attempts, stats, policy, errs)
+		}
+
+		stats.AddRetry()
+		fmt.Printf("Retry message (topic: %s, offset: %d, attempt: %d), error: %v\n", msg.Topic, msg.Offset, attempts, err)
+		if !sleep(ctx, policy.backoff(attempts)) {
+			return false
+		}
+	}
+}
+
+func deadLetter(ctx context.Context, msg kafka.Message, cause error, attempts int, stats *ConsumerStats, policy ConsumePolicy, errs chan<- error) bool {
+	stats.AddDeadLetter()
+	if policy.DeadLetter == nil {
+		fmt.Printf("Drop message (topic: %s, offset: %d) after %d attempts, error: %v\n", msg.Topic, msg.Offset, attempts, cause)
+		return true
+	}
+
+	headers := append([]kafka.Header{}, msg.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: DLQ_HEADER_ERROR, Value: []byte(cause.Error())},
+		kafka.Header{Key: DLQ_HEADER_TOPIC, Value: []byte(msg.Topic)},
+		kafka.Header{Key: DLQ_HEADER_PARTITION, Value: []byte(strconv.Itoa(msg.Partition))},
+		kafka.Header{Key: DLQ_HEADER_OFFSET, Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		kafka.Header{Key: DLQ_HEADER_ATTEMPTS, Value: []byte(strconv.Itoa(attempts))},
+		kafka.Header{Key: DLQ_HEADER_FAILED_AT, Value: []byte(time.Now().UTC().Format(time.RFC3339))},
+	)
+	dlq := kafka.Message{Key: msg.Key, Value: msg.Value, Headers: headers}
+
+	// The message is committed once dead-lettered, keep trying until the write succeeds
+	for retry := 1; ; retry++ {
+		err := policy.DeadLetter.WriteMessages(ctx, dlq)
+		if err == nil {
+			fmt.Printf("Dead-letter message (topic: %s, offset: %d) after %d attempts, error: %v\n", msg.Topic, msg.Offset, attempts, cause)
+			return true
+		}
+		fmt.Printf("Unable to write dead-letter message (topic: %s, offset: %d), error: %v\n", msg.Topic, msg.Offset, err)
+		ReportError(errs, err)
+		if !sleep(ctx, policy.backoff(retry)) {
+			return false
+		}
+	}
+}
+
+// sleep waits for d and returns false when ctx is cancelled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// ReportError sends err without blocking, it is dropped when nobody drains the channel.
+func ReportError(errs chan<- error, err error) {
+	select {
+	case errs <- err:
+	default:
+	}
+}
@@ -0,0 +1,260 @@
+package partners
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/onecombine/onecombine-msg-validator/src/secrets"
+)
+
+// blockingReader serves its messages then blocks until the fetch context is cancelled.
+type blockingReader struct {
+	mu        sync.Mutex
+	msgs      []kafka.Message
+	committed []int64
+	commitErr error
+	closed    bool
+}
+
+func (r *blockingReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	r.mu.Lock()
+	if len(r.msgs) > 0 {
+		msg := r.msgs[0]
+		r.msgs = r.msgs[1:]
+		r.mu.Unlock()
+		return msg, nil
+	}
+	r.mu.Unlock()
+
+	<-ctx.Done()
+	return kafka.Message{}, ctx.Err()
+}
+
+func (r *blockingReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range msgs {
+		r.committed = append(r.committed, m.Offset)
+	}
+	return nil
+}
+
+func (r *blockingReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return nil
+}
+
+func TestConsumeStopsOnCancel(t *testing.T) {
+	reader := &blockingReader{msgs: []kafka.Message{{Offset: 1}, {Offset: 2}}}
+	stats := NewConsumerStats("topic")
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	handled := make(chan int64, 2)
+	errs := Consume(ctx, &wg, reader, stats, ConsumePolicy{Pause: time.Millisecond}, func(ctx context.Context, msg kafka.Message) error {
+		handled <- msg.Offset
+		if msg.Offset == 2 {
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	assert.Equal(t, int64(1), <-handled)
+	assert.Equal(t, int64(2), <-handled)
+	assert.EqualError(t, <-errs, "boom", "Handler error reported")
+
+	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Consumer did not stop on cancel")
+	}
+
+	_, open := <-errs
+	assert.Equal(t, false, open, "Error channel closed")
+	assert.Equal(t, []int64{1, 2}, reader.committed, "Handled messages committed")
+	assert.Equal(t, true, reader.closed)
+	assert.Equal(t, false, stats.Running())
+}
+
+func TestConsumeCommitsInFlightMessage(t *testing.T) {
+	reader := &blockingReader{msgs: []kafka.Message{{Offset: 7}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	Consume(ctx, &wg, reader, NewConsumerStats("topic"), ConsumePolicy{Pause: time.Millisecond}, func(ctx context.Context, msg kafka.Message) error {
+		// Cancelled while the message is being handled
+		cancel()
+		return nil
+	})
+	wg.Wait()
+
+	assert.Equal(t, []int64{7}, reader.committed)
+}
+
+type deadLetterWriter struct {
+	mu     sync.Mutex
+	msgs   []kafka.Message
+	fail   int
+	closed bool
+}
+
+func (w *deadLetterWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	return nil
+}
+
+func (w *deadLetterWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.fail > 0 {
+		w.fail--
+		return errors.New("broker unavailable")
+	}
+	w.msgs = append(w.msgs, msgs...)
+	return nil
+}
+
+func header(msg kafka.Message, key string) string {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func TestConsumeRetriesAndDeadLetters(t *testing.T) {
+	reader := &blockingReader{msgs: []kafka.Message{
+		{Topic: "topic", Offset: 1, Key: []byte("flaky")},
+		{Topic: "topic", Offset: 2, Key: []byte("broken"), Value: []byte("{"), Headers: []kafka.Header{{Key: "x-request-id", Value: []byte("REQ-1")}}},
+		{Topic: "topic", Offset: 3, Key: []byte("poison")},
+		{Topic: "topic", Offset: 4, Key: []byte("stale")},
+	}}
+	dlq := &deadLetterWriter{fail: 1}
+	stats := NewConsumerStats("topic")
+	policy := ConsumePolicy{MaxRetries: 2, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond, DeadLetter: dlq}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	attempts := map[string]int{}
+	Consume(ctx, &wg, reader, stats, policy, func(ctx context.Context, msg kafka.Message) error {
+		key := string(msg.Key)
+		attempts[key]++
+		switch key {
+		case "flaky":
+			if attempts[key] < 3 {
+				return errors.New("store busy")
+			}
+		case "broken":
+			return Permanent(errors.New("unexpected end of JSON input"))
+		case "poison":
+			return errors.New("always failing")
+		case "stale":
+			return ErrStaleWrite
+		}
+		return nil
+	})
+
+	assert.Eventually(t, func() bool {
+		reader.mu.Lock()
+		defer reader.mu.Unlock()
+		return len(reader.committed) == 4
+	}, time.Second, time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	assert.Equal(t, map[string]int{"flaky": 3, "broken": 1, "poison": 3, "stale": 1}, attempts)
+	assert.Equal(t, []int64{1, 2, 3, 4}, reader.committed, "Failed messages committed once dead-lettered")
+	assert.Equal(t, ConsumerFailures{Topic: "topic", Retries: 4, DeadLettered: 2}, stats.Failures())
+	assert.Equal(t, true, dlq.closed, "Dead-letter writer closed with the consumer")
+
+	assert.Equal(t, 2, len(dlq.msgs))
+	broken := dlq.msgs[0]
+	assert.Equal(t, "broken", string(broken.Key))
+	assert.Equal(t, "{", string(broken.Value), "Original payload")
+	assert.Equal(t, "REQ-1", header(broken, "x-request-id"), "Original headers kept")
+	assert.Equal(t, "permanent failure: unexpected end of JSON input", header(broken, DLQ_HEADER_ERROR))
+	assert.Equal(t, "topic", header(broken, DLQ_HEADER_TOPIC))
+	assert.Equal(t, "2", header(broken, DLQ_HEADER_OFFSET))
+	assert.Equal(t, "1", header(broken, DLQ_HEADER_ATTEMPTS))
+	assert.Equal(t, "3", header(dlq.msgs[1], DLQ_HEADER_ATTEMPTS))
+}
+
+func TestConsumeCancelDuringBackoff(t *testing.T) {
+	reader := &blockingReader{msgs: []kafka.Message{{Offset: 1}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	policy := ConsumePolicy{MaxRetries: 5, Backoff: time.Minute}
+	Consume(ctx, &wg, reader, NewConsumerStats("topic"), policy, func(ctx context.Context, msg kafka.Message) error {
+		cancel()
+		return errors.New("store busy")
+	})
+	wg.Wait()
+
+	assert.Equal(t, 0, len(reader.committed), "Unsettled message is delivered again")
+}
+
+func TestConsumePolicyBackoff(t *testing.T) {
+	policy := NewConsumePolicy(&KafkaConfig{MaxRetries: "5", RetryBackoff: "100ms", MaxRetryBackoff: "1s"}, nil, 0)
+	assert.Equal(t, 5, policy.MaxRetries)
+	assert.Nil(t, policy.DeadLetter)
+	assert.Equal(t, 100*time.Millisecond, policy.backoff(1))
+	assert.Equal(t, 400*time.Millisecond, policy.backoff(3))
+	assert.Equal(t, time.Second, policy.backoff(10))
+
+	policy = NewConsumePolicy(&KafkaConfig{}, nil, 0)
+	assert.Equal(t, 3, policy.MaxRetries)
+	assert.Equal(t, 200*time.Millisecond, policy.backoff(1))
+}
+
+func TestPartnerServiceShutdown(t *testing.T) {
+	s := NewPartnewServiceWithoutEvent("http://127.0.0.1:0")
+	s.StartAcquirerScheduler()
+	s.StartIssuerScheduler()
+
+	// The profile API is unreachable, refresh errors are reported
+	assert.NotNil(t, <-s.Errors())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(t, s.Shutdown(ctx), "Schedulers stopped")
+}
+
+func TestPartnerServiceWithBadKeyProvider(t *testing.T) {
+	t.Setenv(secrets.SECRET_KEY_PROVIDER, "vault")
+	cfg := &KafkaConfig{Bootstrap: "127.0.0.1:0", TopicName: "profile"}
+
+	var s *PartnerService
+	var err error
+	assert.NotPanics(t, func() {
+		s, err = NewPartnerServiceWithContext(context.Background(), "http://127.0.0.1:0", cfg, cfg)
+	})
+	assert.ErrorContains(t, err, "unknown provider vault")
+	assert.NotNil(t, s, "Profiles are still served from the profile API")
+	assert.ErrorIs(t, s.ConsumerError(), secrets.ErrInvalidKey)
+	assert.Equal(t, 0, len(s.GetConsumerStats()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(t, s.Shutdown(ctx))
+}
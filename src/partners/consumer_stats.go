@@ -0,0 +1,133 @@
+package partners
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+type TopicOffset struct {
+	Topic     string    `json:"topic"`
+	Partition int       `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Consumed  time.Time `json:"consumed"`
+}
+
+// ConsumerStats keeps the running state and the last offset consumed per topic partition.
+type ConsumerStats struct {
+	mu        sync.RWMutex
+	topic     string
+	running   bool
+	lastError error
+	errorAt   time.Time
+	offsets   map[string]*TopicOffset
+
+	retries      uint64
+	deadLettered uint64
+}
+
+// ConsumerFailures counts the retried and dead-lettered messages of a topic.
+type ConsumerFailures struct {
+	Topic        string `json:"topic"`
+	Retries      uint64 `json:"retries"`
+	DeadLettered uint64 `json:"deadLettered"`
+}
+
+func NewConsumerStats(topic string) *ConsumerStats {
+	return &ConsumerStats{
+		topic:   topic,
+		offsets: make(map[string]*TopicOffset),
+	}
+}
+
+func (cs *ConsumerStats) Topic() string {
+	return cs.topic
+}
+
+func (cs *ConsumerStats) SetRunning(running bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.running = running
+}
+
+func (cs *ConsumerStats) Running() bool {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.running
+}
+
+// SetError records the outcome of the last fetch from the broker, nil clears a previous error.
+func (cs *ConsumerStats) SetError(err error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.lastError = err
+	cs.errorAt = time.Now()
+}
+
+func (cs *ConsumerStats) LastError() error {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.lastError
+}
+
+// RecentError returns the last error when it occurred within window, a zero window returns it
+// whatever its age.
+func (cs *ConsumerStats) RecentError(window time.Duration) error {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if cs.lastError == nil || (window > 0 && time.Since(cs.errorAt) > window) {
+		return nil
+	}
+	return cs.lastError
+}
+
+func (cs *ConsumerStats) Track(msg kafka.Message) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.lastError = nil
+	cs.offsets[fmt.Sprintf("%s/%d", msg.Topic, msg.Partition)] = &TopicOffset{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Consumed:  time.Now(),
+	}
+}
+
+func (cs *ConsumerStats) Offsets() []TopicOffset {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	offsets := make([]TopicOffset, 0, len(cs.offsets))
+	for _, o := range cs.offsets {
+		offsets = append(offsets, *o)
+	}
+
+	sort.Slice(offsets, func(i, j int) bool {
+		if offsets[i].Topic != offsets[j].Topic {
+			return offsets[i].Topic < offsets[j].Topic
+		}
+		return offsets[i].Partition < offsets[j].Partition
+	})
+	return offsets
+}
+
+func (cs *ConsumerStats) AddRetry() {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.retries++
+}
+
+func (cs *ConsumerStats) AddDeadLetter() {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.deadLettered++
+}
+
+func (cs *ConsumerStats) Failures() ConsumerFailures {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return ConsumerFailures{Topic: cs.topic, Retries: cs.retries, DeadLettered: cs.deadLettered}
+}
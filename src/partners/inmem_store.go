@@ -2,57 +2,520 @@ package partners
 
 import (
 	"errors"
+	"fmt"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 )
 
-type MemoryStore struct {
-	mu    sync.RWMutex
-	store map[string]interface{}
+const (
+	INDEX_API_KEY = "apiKey"
+	INDEX_ORG_ID  = "orgId"
+)
+
+var ErrIndexConflict = errors.New("index value is used by another item")
+var ErrStaleWrite = errors.New("item is older than the stored one")
+
+// IndexFunc returns the value an item is indexed under, empty values are not indexed.
+type IndexFunc[T any] func(item T) string
+
+// EqualFunc tells whether a write leaves an item unchanged, such writes are not published.
+type EqualFunc[T any] func(old, new T) bool
+
+// GuardFunc refuses the writes of invalid items with an error.
+type GuardFunc[T any] func(item T) error
+
+// CheckFunc refuses the write of next over current with an error, exists is false when there is
+// no current item.
+type CheckFunc[T any] func(current T, exists bool, next T) error
+
+// WriteHook is called with every change of a store, see AddWriteHook.
+type WriteHook[T any] func(change Change[T])
+
+// ModifiedFunc returns the last modification time of an item, zero when unknown.
+type ModifiedFunc[T any] func(item T) time.Time
+
+// EntityVersion describes the write currently stored under a key. Revision is the store version
+// at the time of that write.
+type EntityVersion struct {
+	Key      string    `json:"key"`
+	Revision uint64    `json:"revision"`
+	Modified time.Time `json:"modified"`
+	Applied  time.Time `json:"applied"`
+}
+
+// Store is an in-memory store keyed by a primary key with secondary indexes which are kept
+// consistent with the items on every write. Every write bumps the store version.
+type Store[T any] struct {
+	mu       sync.RWMutex
+	key      IndexFunc[T]
+	indexes  map[string]IndexFunc[T]
+	items    map[string]T
+	lookup   map[string]map[string]map[string]struct{}
+	indexed  map[string]map[string]string
+	version  uint64
+	modified ModifiedFunc[T]
+	versions map[string]EntityVersion
+	stale    uint64
+	changes  *ChangeFeed[T]
+	equal    EqualFunc[T]
+	guards   []GuardFunc[T]
+	checks   []CheckFunc[T]
+	hooks    []WriteHook[T]
+	serial   *sync.Mutex
+	rejected map[string]uint64
+}
+
+type AcquirerStore = Store[*AcquirerProfile]
+type IssuerStore = Store[*IssuerProfile]
+
+func NewStore[T any](key IndexFunc[T], indexes map[string]IndexFunc[T]) *Store[T] {
+	st := &Store[T]{
+		key:      key,
+		indexes:  make(map[string]IndexFunc[T]),
+		items:    make(map[string]T),
+		lookup:   make(map[string]map[string]map[string]struct{}),
+		indexed:  make(map[string]map[string]string),
+		versions: make(map[string]EntityVersion),
+		changes:  NewChangeFeed[T](),
+		rejected: make(map[string]uint64),
+	}
+	for name, index := range indexes {
+		st.indexes[name] = index
+		st.lookup[name] = make(map[string]map[string]struct{})
+	}
+	return st
+}
+
+// NewAcquirerStore keys acquirers by partner id (AcqID) and indexes them by API key and org id.
+// Invalid profiles and updates older than the stored profile, by Modified, are refused.
+func NewAcquirerStore() *AcquirerStore {
+	st := NewStore(func(a *AcquirerProfile) string { return a.AcqID }, map[string]IndexFunc[*AcquirerProfile]{
+		INDEX_API_KEY: func(a *AcquirerProfile) string { return a.ApiKey },
+		INDEX_ORG_ID:  func(a *AcquirerProfile) string { return orgIndex(a.OrganizationID) },
+	})
+	st.SetModifiedFunc(func(a *AcquirerProfile) time.Time { return ParseModified(a.Modified) })
+	st.SetEqualFunc(func(old, new *AcquirerProfile) bool { return *old == *new })
+	st.AddGuard(validateAcquirerProfile)
+	policy := NewSecretPolicyFromEnv()
+	st.AddGuard(func(a *AcquirerProfile) error {
+		old, ok := st.Get(a.AcqID)
+		return policy.Check(a.AcqID, a.Secret, ok && old.Secret == a.Secret)
+	})
+	return st
+}
+
+// NewIssuerStore keys issuers by partner id (IssuerID) and indexes them by API key and org id.
+// Invalid profiles and updates older than the stored profile, by Modified, are refused.
+func NewIssuerStore() *IssuerStore {
+	st := NewStore(func(i *IssuerProfile) string { return i.IssuerID }, map[string]IndexFunc[*IssuerProfile]{
+		INDEX_API_KEY: func(i *IssuerProfile) string { return i.ApiKey },
+		INDEX_ORG_ID:  func(i *IssuerProfile) string { return orgIndex(i.OrganizationID) },
+	})
+	st.SetModifiedFunc(func(i *IssuerProfile) time.Time { return ParseModified(i.Modified) })
+	st.SetEqualFunc(func(old, new *IssuerProfile) bool { return *old == *new })
+	st.AddGuard(validateIssuerProfile)
+	policy := NewSecretPolicyFromEnv()
+	st.AddGuard(func(i *IssuerProfile) error {
+		old, ok := st.Get(i.IssuerID)
+		return policy.Check(i.IssuerID, i.Secret, ok && old.Secret == i.Secret)
+	})
+	return st
+}
+
+// ParseModified parses an RFC 3339 modification timestamp, it returns the zero time when the
+// value is empty or malformed.
+func ParseModified(modified string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, modified)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func orgIndex(id uint) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// Upsert inserts or replaces the item stored under its primary key and returns the replaced item.
+func (st *Store[T]) Upsert(item T) (T, bool) {
+	st.mu.Lock()
+	old, ok, change := st.put(st.key(item), item)
+	st.mu.Unlock()
+
+	st.runHooks(change)
+	return old, ok
+}
+
+// SetModifiedFunc enables the stale write protection of UpsertUnique and UpsertLatest.
+func (st *Store[T]) SetModifiedFunc(modified ModifiedFunc[T]) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.modified = modified
+}
+
+// SetEqualFunc skips the writes which leave the item unchanged, e.g. the periodic reload of
+// identical profiles. They are neither published nor versioned.
+func (st *Store[T]) SetEqualFunc(equal EqualFunc[T]) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.equal = equal
+}
+
+// AddGuard validates the items written by UpsertUnique and UpsertLatest, the guards run in the
+// order they were added before the store is locked so they may read other stores.
+func (st *Store[T]) AddGuard(guard GuardFunc[T]) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.guards = append(st.guards, guard)
+}
+
+// SetWriteLock makes UpsertUnique and UpsertLatest hold lock from their guards to their write.
+// Stores sharing a lock write one at a time, so a guard reading another of them sees its last
+// write. Guards and checks must not write to these stores.
+func (st *Store[T]) SetWriteLock(lock *sync.Mutex) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.serial = lock
+}
+
+// AddWriteCheck validates the writes of UpsertUnique and UpsertLatest against the item they
+// replace. Unlike the guards the checks run under the store lock, after the stale write check, so
+// no other write can come in between. They must not access the store.
+func (st *Store[T]) AddWriteCheck(check CheckFunc[T]) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.checks = append(st.checks, check)
+}
+
+// AddWriteHook calls hook with every change before the write returns, once the store is
+// unlocked. Unlike the change feed no change is ever dropped, but a slow hook slows the writers
+// down. Concurrent writes may run their hooks in any order.
+func (st *Store[T]) AddWriteHook(hook WriteHook[T]) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.hooks = append(st.hooks, hook)
+}
+
+func (st *Store[T]) runHooks(change *Change[T]) {
+	if change == nil {
+		return
+	}
+	st.mu.RLock()
+	hooks := st.hooks
+	st.mu.RUnlock()
+	for _, hook := range hooks {
+		hook(*change)
+	}
+}
+
+// Changes returns the feed of the store writes, changes are published in write order after the
+// write is applied.
+func (st *Store[T]) Changes() *ChangeFeed[T] {
+	return st.changes
+}
+
+// UpsertUnique behaves like UpsertLatest but also refuses the write when the value of the given
+// index is already used by an item stored under another primary key.
+func (st *Store[T]) UpsertUnique(item T, index string) (T, bool, error) {
+	return st.upsert(item, index)
+}
+
+// UpsertLatest behaves like Upsert but refuses, and counts, the write of an item modified before
+// the stored one. Writes are applied when either modification time is unknown.
+func (st *Store[T]) UpsertLatest(item T) (T, bool, error) {
+	return st.upsert(item, "")
+}
+
+func (st *Store[T]) upsert(item T, unique string) (T, bool, error) {
+	var zero T
+	old, ok, change, err := st.guardedWrite(item, unique)
+	if err != nil {
+		return zero, false, err
+	}
+	st.runHooks(change)
+	return old, ok, nil
+}
+
+func (st *Store[T]) guardedWrite(item T, unique string) (T, bool, *Change[T], error) {
+	st.mu.RLock()
+	serial := st.serial
+	st.mu.RUnlock()
+	if serial != nil {
+		serial.Lock()
+		defer serial.Unlock()
+	}
+
+	var zero T
+	key := st.key(item)
+	if err := st.guard(item); err != nil {
+		fmt.Printf("Reject write of %q, error: %v\n", key, err)
+		return zero, false, nil, err
+	}
+	return st.write(key, item, unique)
 }
 
-func NewMemoryStore() *MemoryStore {
-	return &MemoryStore{
-		store: make(map[string]interface{}),
+func (st *Store[T]) write(key string, item T, unique string) (T, bool, *Change[T], error) {
+	var zero T
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if unique != "" {
+		if value := st.indexes[unique](item); value != "" {
+			for other := range st.lookup[unique][value] {
+				if other != key {
+					st.rejected[unique]++
+					return zero, false, nil, fmt.Errorf("%w (%s: %s)", ErrIndexConflict, unique, other)
+				}
+			}
+		}
+	}
+
+	if st.modified != nil {
+		current, ok := st.versions[key]
+		modified := st.modified(item)
+		if ok && !current.Modified.IsZero() && !modified.IsZero() && modified.Before(current.Modified) {
+			st.stale++
+			return zero, false, nil, fmt.Errorf("%w (%s: %s < %s)", ErrStaleWrite, key, modified.Format(time.RFC3339), current.Modified.Format(time.RFC3339))
+		}
+	}
+
+	current, exists := st.items[key]
+	for _, check := range st.checks {
+		if err := check(current, exists, item); err != nil {
+			st.countRejected(err)
+			return zero, false, nil, err
+		}
 	}
+
+	old, ok, change := st.put(key, item)
+	return old, ok, change, nil
 }
 
-func (ms *MemoryStore) Set(key string, value interface{}) {
-	ms.mu.Lock()
-	defer ms.mu.Unlock()
-	ms.store[key] = value
+func (st *Store[T]) guard(item T) error {
+	st.mu.RLock()
+	guards := st.guards
+	st.mu.RUnlock()
+
+	for _, guard := range guards {
+		err := guard(item)
+		if err == nil {
+			continue
+		}
+		st.mu.Lock()
+		st.countRejected(err)
+		st.mu.Unlock()
+		return err
+	}
+	return nil
 }
 
-func (ms *MemoryStore) Get(key string) (interface{}, error) {
-	ms.mu.RLock()
-	defer ms.mu.RUnlock()
-	value, ok := ms.store[key]
+func (st *Store[T]) countRejected(err error) {
+	fields := ErrorFields(err)
+	if len(fields) == 0 {
+		fields = []string{"unknown"}
+	}
+	for _, field := range fields {
+		st.rejected[field]++
+	}
+}
+
+func (st *Store[T]) Delete(key string) (T, bool) {
+	st.mu.Lock()
+	old, ok := st.items[key]
 	if !ok {
-		return nil, errors.New("key not found")
+		st.mu.Unlock()
+		return old, false
 	}
-	return value, nil
+	st.unindex(key)
+	delete(st.items, key)
+	delete(st.versions, key)
+	st.version++
+
+	var zero T
+	change := Change[T]{Type: CHANGE_DELETED, Key: key, Old: old, New: zero}
+	st.changes.Publish(change)
+	st.mu.Unlock()
+
+	st.runHooks(&change)
+	return old, true
 }
 
-func (ms *MemoryStore) Keys() []string {
-	ms.mu.RLock()
-	defer ms.mu.RUnlock()
+func (st *Store[T]) Get(key string) (T, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	item, ok := st.items[key]
+	return item, ok
+}
 
-	keys := make([]string, 0)
+// GetBy returns the item indexed under value, the one with the lowest primary key on duplicates.
+func (st *Store[T]) GetBy(index, value string) (T, bool) {
+	var zero T
+	items := st.FindBy(index, value)
+	if len(items) == 0 {
+		return zero, false
+	}
+	return items[0], true
+}
+
+// FindBy returns the items indexed under value ordered by primary key.
+func (st *Store[T]) FindBy(index, value string) []T {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
 
-	for k, _ := range ms.store {
+	keys := make([]string, 0)
+	for k := range st.lookup[index][value] {
 		keys = append(keys, k)
 	}
+	sort.Strings(keys)
+
+	items := make([]T, 0, len(keys))
+	for _, k := range keys {
+		items = append(items, st.items[k])
+	}
+	return items
+}
+
+func (st *Store[T]) Keys() []string {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
 
+	keys := make([]string, 0, len(st.items))
+	for k := range st.items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
 	return keys
 }
 
-func (ms *MemoryStore) GetAll() map[string]interface{} {
-	ms.mu.RLock()
-	defer ms.mu.RUnlock()
-	return ms.store
+// Snapshot returns a copy of the stored items ordered by primary key, it is safe to iterate
+// while the store is being written.
+func (st *Store[T]) Snapshot() []T {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	keys := make([]string, 0, len(st.items))
+	for k := range st.items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	items := make([]T, 0, len(keys))
+	for _, k := range keys {
+		items = append(items, st.items[k])
+	}
+	return items
+}
+
+func (st *Store[T]) Len() int {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return len(st.items)
+}
+
+// Version is incremented on every change of the store.
+func (st *Store[T]) Version() uint64 {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.version
+}
+
+// Stale returns the number of writes refused because they were older than the stored item.
+func (st *Store[T]) Stale() uint64 {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.stale
 }
 
-func (ms *MemoryStore) Delete(key string) {
-	ms.mu.Lock()
-	defer ms.mu.Unlock()
-	delete(ms.store, key)
+// Rejected returns the number of writes refused by the guards, per invalid field.
+func (st *Store[T]) Rejected() map[string]uint64 {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	rejected := make(map[string]uint64, len(st.rejected))
+	for field, n := range st.rejected {
+		rejected[field] = n
+	}
+	return rejected
+}
+
+func (st *Store[T]) EntityVersion(key string) (EntityVersion, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	v, ok := st.versions[key]
+	return v, ok
+}
+
+// EntityVersions returns the version of every stored item ordered by primary key.
+func (st *Store[T]) EntityVersions() []EntityVersion {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	versions := make([]EntityVersion, 0, len(st.versions))
+	for _, v := range st.versions {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Key < versions[j].Key })
+	return versions
+}
+
+// put returns the change published, nil when the write left the item unchanged. Unchanged items
+// keep their version, so the store version only moves on real changes.
+func (st *Store[T]) put(key string, item T) (T, bool, *Change[T]) {
+	old, ok := st.items[key]
+	if ok && st.equal != nil && st.equal(old, item) {
+		return old, ok, nil
+	}
+	st.unindex(key)
+	st.items[key] = item
+	st.index(key, item)
+	st.version++
+
+	v := EntityVersion{Key: key, Revision: st.version, Applied: time.Now()}
+	if st.modified != nil {
+		v.Modified = st.modified(item)
+	}
+	st.versions[key] = v
+
+	change := &Change[T]{Type: CHANGE_UPDATED, Key: key, Old: old, New: item}
+	if !ok {
+		change.Type = CHANGE_CREATED
+	}
+	st.changes.Publish(*change)
+	return old, ok, change
+}
+
+func (st *Store[T]) index(key string, item T) {
+	values := make(map[string]string)
+	for name, index := range st.indexes {
+		value := index(item)
+		if value == "" {
+			continue
+		}
+		values[name] = value
+		keys, ok := st.lookup[name][value]
+		if !ok {
+			keys = make(map[string]struct{})
+			st.lookup[name][value] = keys
+		}
+		keys[key] = struct{}{}
+	}
+	st.indexed[key] = values
+}
+
+// unindex uses the values recorded at index time, the stored item may have been modified since.
+func (st *Store[T]) unindex(key string) {
+	for name, value := range st.indexed[key] {
+		keys, ok := st.lookup[name][value]
+		if !ok {
+			continue
+		}
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(st.lookup[name], value)
+		}
+	}
+	delete(st.indexed, key)
 }
@@ -0,0 +1,143 @@
+package partners
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreIndexes(t *testing.T) {
+	store := NewAcquirerStore()
+
+	_, existed := store.Upsert(&AcquirerProfile{AcqID: "100090", ApiKey: "KEY-1", OrganizationID: 4})
+	assert.Equal(t, false, existed, "New acquirer")
+	store.Upsert(&AcquirerProfile{AcqID: "100091", ApiKey: "KEY-2", OrganizationID: 4})
+
+	acq, ok := store.GetBy(INDEX_API_KEY, "KEY-1")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "100090", acq.AcqID, "Lookup by api key")
+	assert.Equal(t, 2, len(store.FindBy(INDEX_ORG_ID, "4")), "Lookup by org id")
+
+	old, existed := store.Upsert(&AcquirerProfile{AcqID: "100090", ApiKey: "KEY-3", OrganizationID: 5})
+	assert.Equal(t, true, existed, "Existing acquirer")
+	assert.Equal(t, "KEY-1", old.ApiKey, "Replaced acquirer")
+
+	_, ok = store.GetBy(INDEX_API_KEY, "KEY-1")
+	assert.Equal(t, false, ok, "Old api key is no longer indexed")
+	acq, _ = store.GetBy(INDEX_API_KEY, "KEY-3")
+	assert.Equal(t, "100090", acq.AcqID, "New api key is indexed")
+	assert.Equal(t, 1, len(store.FindBy(INDEX_ORG_ID, "4")), "Org index updated")
+
+	store.Delete("100091")
+	_, ok = store.GetBy(INDEX_API_KEY, "KEY-2")
+	assert.Equal(t, false, ok, "Deleted acquirer is no longer indexed")
+	assert.Equal(t, 0, len(store.FindBy(INDEX_ORG_ID, "4")))
+	assert.Equal(t, []string{"100090"}, store.Keys())
+	assert.Equal(t, uint64(4), store.Version(), "Version bumped on every write")
+}
+
+func TestStoreIndexesModifiedItem(t *testing.T) {
+	store := NewIssuerStore()
+	iss := &IssuerProfile{IssuerID: "200099", ApiKey: "KEY-1"}
+	store.Upsert(iss)
+
+	// Modifying the stored pointer must not corrupt the index on the next write
+	iss.ApiKey = "KEY-2"
+	store.Upsert(&IssuerProfile{IssuerID: "200099", ApiKey: "KEY-3"})
+
+	_, ok := store.GetBy(INDEX_API_KEY, "KEY-1")
+	assert.Equal(t, false, ok)
+	_, ok = store.GetBy(INDEX_API_KEY, "KEY-3")
+	assert.Equal(t, true, ok)
+}
+
+func TestStoreStaleWrites(t *testing.T) {
+	store := NewAcquirerStore()
+
+	_, _, err := store.UpsertLatest(&AcquirerProfile{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8", Name: "v2", Modified: "2025-02-21T06:39:00Z"})
+	assert.Nil(t, err)
+
+	_, _, err = store.UpsertLatest(&AcquirerProfile{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8", Name: "v1", Modified: "2025-02-21T05:00:00Z"})
+	assert.ErrorIs(t, err, ErrStaleWrite, "Older profile")
+	acq, _ := store.Get("100090")
+	assert.Equal(t, "v2", acq.Name, "Newer profile kept")
+	assert.Equal(t, uint64(1), store.Stale())
+
+	_, _, err = store.UpsertLatest(&AcquirerProfile{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8", Name: "v2-replay", Modified: "2025-02-21T06:39:00Z"})
+	assert.Nil(t, err, "Same modification time is applied")
+
+	_, _, err = store.UpsertLatest(&AcquirerProfile{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8", Name: "unknown"})
+	assert.Nil(t, err, "Unknown modification time is applied")
+
+	_, _, err = store.UpsertLatest(&AcquirerProfile{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8", Name: "v3", Modified: "2025-02-22T00:00:00+07:00"})
+	assert.Nil(t, err)
+
+	v, ok := store.EntityVersion("100090")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, store.Version(), v.Revision, "Revision of the last write")
+	assert.Equal(t, ParseModified("2025-02-21T17:00:00Z").UTC(), v.Modified.UTC())
+
+	// Upsert is unconditional
+	store.Upsert(&AcquirerProfile{AcqID: "100090", Name: "forced", Modified: "2020-01-01T00:00:00Z"})
+	acq, _ = store.Get("100090")
+	assert.Equal(t, "forced", acq.Name)
+
+	store.Delete("100090")
+	_, ok = store.EntityVersion("100090")
+	assert.Equal(t, false, ok, "Version dropped with the item")
+	assert.Equal(t, 0, len(store.EntityVersions()))
+}
+
+func TestStoreWriteChecks(t *testing.T) {
+	store := NewStore(func(a *AcquirerProfile) string { return a.AcqID }, nil)
+	store.SetModifiedFunc(func(a *AcquirerProfile) time.Time { return ParseModified(a.Modified) })
+	checked := 0
+	store.AddWriteCheck(func(current *AcquirerProfile, exists bool, next *AcquirerProfile) error {
+		checked++
+		if exists {
+			return &ValidationError{Field: "acqId", Err: ErrInvalidField, Reason: "already created by " + current.Name}
+		}
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			store.UpsertLatest(&AcquirerProfile{AcqID: "100090", Name: fmt.Sprintf("writer-%d", i), Modified: "2025-02-21T06:39:00Z"})
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 1, store.Len())
+	assert.Equal(t, uint64(19), store.Rejected()["acqId"], "Checked against the item each write replaces")
+
+	_, _, err := store.UpsertLatest(&AcquirerProfile{AcqID: "100090", Modified: "2025-02-20T00:00:00Z"})
+	assert.ErrorIs(t, err, ErrStaleWrite, "Stale writes are not checked")
+	assert.Equal(t, 20, checked)
+}
+
+func TestStoreSnapshotConcurrency(t *testing.T) {
+	store := NewAcquirerStore()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				store.Upsert(&AcquirerProfile{AcqID: fmt.Sprintf("%d-%d", w, i%10), ApiKey: fmt.Sprintf("KEY-%d-%d", w, i)})
+				for _, acq := range store.Snapshot() {
+					_ = acq.ApiKey
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 40, store.Len())
+	assert.Equal(t, 40, len(store.Snapshot()))
+}
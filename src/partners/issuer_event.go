@@ -2,13 +2,13 @@ package partners
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/onecombine/onecombine-msg-validator/src/secrets"
 	"github.com/onecombine/onecombine-msg-validator/src/utils"
 	kafka "github.com/segmentio/kafka-go"
 	"github.com/segmentio/kafka-go/sasl/aws_msk_iam_v2"
@@ -24,6 +24,11 @@ type KafkaConfig struct {
 	QueueType       string
 	Timeout         string
 	ReadOffset      string
+	// Retries of a failed message before it is dead-lettered, default 3
+	MaxRetries          string
+	RetryBackoff        string
+	MaxRetryBackoff     string
+	DeadLetterTopicName string
 }
 
 /*
@@ -71,88 +76,92 @@ type IssuerProfileEvent struct {
 	CancellationNotificationWebHook string `json:"cancelled_notification_webhook"`
 	Created                         string `json:"created"`
 	Modified                        string `json:"modified"`
+	Status                          string `json:"status"`
 }
 
 type IssuerProfileConsumer interface {
-	Subscribe(wg *sync.WaitGroup) chan string
+	Subscribe(ctx context.Context, wg *sync.WaitGroup) <-chan error
 	Process(e *IssuerProfileEvent) error
+	Delete(id string) error
+	Stats() *ConsumerStats
 }
 
 type issuerConsumer struct {
-	store   *MemoryStore
+	store   *IssuerStore
 	kreader *kafka.Reader
 	cfg     *KafkaConfig
+	stats   *ConsumerStats
+	policy  ConsumePolicy
+	cipher  secrets.Cipher
 }
 
 // Process implements IssuerProfileConsumer.
 func (i *issuerConsumer) Process(e *IssuerProfileEvent) error {
-
-	var key string
-	for k, v := range i.store.GetAll() {
-		if v.(*IssuerProfile).IssuerID == e.IssuerID {
-			key = k
-			break
-		}
+	transition, err := upsertIssuer(i.store, eventToIssuerProfile(e))
+	if err != nil {
+		return err
 	}
+	fmt.Printf("Update issuer profile in memory storage (IssuerID: %s, %s)\n", e.IssuerID, transition)
+	return nil
+}
 
-	i.store.Set(key, eventToIssuerProfile(e))
-	fmt.Printf("Update issuer profile in memory storage (IssuerID: %s)\n", e.IssuerID)
+// Delete implements IssuerProfileConsumer, it handles tombstones and DELETED events.
+func (i *issuerConsumer) Delete(id string) error {
+	if id == "" {
+		return fmt.Errorf("%w: message key", ErrMissingField)
+	}
+	deleteIssuer(i.store, id)
 	return nil
 }
 
-// Subscribe implements IssuerProfileConsumer.
-func (i *issuerConsumer) Subscribe(wg *sync.WaitGroup) chan string {
-
-	cls := make(chan string)
-
-	topic := i.cfg.TopicName
-
-	go func() {
-		fmt.Printf("Subscribe to issuer profile event stream, topic: %s\n", topic)
-
-		for {
-			select {
-			case <-cls:
-				fmt.Printf("Stop subscribe topic: %s\n", i.cfg.TopicName)
-				i.kreader.Close()
-				wg.Done()
-				close(cls)
-				return
-			default:
-				fmt.Println("Fetching message")
-				msg, err := i.kreader.FetchMessage(context.TODO())
-				if err != nil {
-					fmt.Printf("Error fetch message from kafka, error: %v\n", err)
-					continue
-				}
-
-				var event IssuerProfileEvent
-				err = json.Unmarshal(msg.Value, &event)
-				if err != nil {
-					fmt.Printf("Unable to unmarshal event message, error: %v\n", err)
-					i.kreader.CommitMessages(context.TODO(), msg)
-					continue
-				}
-
-				err = i.Process(&event)
-				if err != nil {
-					fmt.Printf("Unable to process profile event, error: %v\n", err)
-				} else {
-					fmt.Printf("Process issuer profile (id: %s) successfully\n", event.IssuerID)
-				}
-
-				i.kreader.CommitMessages(context.TODO(), msg)
-				time.Sleep(100 * time.Millisecond)
-			}
+// Stats implements IssuerProfileConsumer.
+func (i *issuerConsumer) Stats() *ConsumerStats {
+	return i.stats
+}
+
+// Subscribe implements IssuerProfileConsumer, it consumes the profile topic until ctx is cancelled.
+func (i *issuerConsumer) Subscribe(ctx context.Context, wg *sync.WaitGroup) <-chan error {
+	return Consume(ctx, wg, i.kreader, i.stats, i.policy, i.handle)
+}
+
+func (i *issuerConsumer) handle(ctx context.Context, msg kafka.Message) error {
+	event, env, err := decodeIssuerEvent(msg)
+	if err != nil {
+		fmt.Printf("Unable to decode event message, error: %v\n", err)
+		return Permanent(err)
+	}
+
+	if event == nil {
+		err = i.Delete(string(msg.Key))
+		if err != nil {
+			fmt.Printf("Unable to process profile deletion, error: %v\n", err)
 		}
+		return err
+	}
 
-	}()
+	event.Secret, err = secrets.Decrypt(ctx, i.cipher, event.Secret, event.IssuerID)
+	if err != nil {
+		fmt.Printf("Unable to decrypt issuer secret (IssuerID: %s), error: %v\n", event.IssuerID, err)
+		return err
+	}
 
-	wg.Add(1)
-	return cls
+	err = i.Process(event)
+	if err != nil {
+		fmt.Printf("Unable to process profile event, error: %v\n", err)
+		return err
+	}
+	fmt.Printf("Process issuer profile (id: %s, eventId: %s, requestId: %s) successfully\n", event.IssuerID, eventId(env), utils.RequestIdFromHeaders(msg.Headers))
+	return nil
 }
 
-func NewKafkaIssuerProfileConsumer(store *MemoryStore, cfg *KafkaConfig) IssuerProfileConsumer {
+// NewKafkaIssuerProfileConsumer fails when the secret key provider or the kafka authentication
+// cannot be configured.
+func NewKafkaIssuerProfileConsumer(store *IssuerStore, cfg *KafkaConfig) (IssuerProfileConsumer, error) {
+	cipher, err := secrets.FromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("secret key provider: %w", err)
+	}
+
 	hosts := strings.Split(cfg.Bootstrap, ",")
 
 	var dialer *kafka.Dialer
@@ -160,7 +169,7 @@ func NewKafkaIssuerProfileConsumer(store *MemoryStore, cfg *KafkaConfig) IssuerP
 	case utils.MSK:
 		awsConfig, err := config.LoadDefaultConfig(context.Background())
 		if err != nil {
-			return nil
+			return nil, fmt.Errorf("msk authentication: %w", err)
 		}
 		dialer = &kafka.Dialer{
 			DualStack:     false,
@@ -202,7 +211,10 @@ func NewKafkaIssuerProfileConsumer(store *MemoryStore, cfg *KafkaConfig) IssuerP
 		kreader: reader,
 		store:   store,
 		cfg:     cfg,
-	}
+		stats:   NewConsumerStats(cfg.TopicName),
+		policy:  NewConsumePolicy(cfg, dialer, 100*time.Millisecond),
+		cipher:  cipher,
+	}, nil
 }
 
 func eventToIssuerProfile(e *IssuerProfileEvent) *IssuerProfile {
@@ -221,13 +233,14 @@ func eventToIssuerProfile(e *IssuerProfileEvent) *IssuerProfile {
 		SettlementWaived:             e.SettlementFeeWaived,
 		SwitchingFee:                 e.SwitchingFee,
 		SwitchingType:                e.SwitchingFeeType,
-		SwitchingWaived:              e.SettlementFeeWaived,
+		SwitchingWaived:              e.SwitchingFeeWaived,
 		SettlementCurrencyCode:       e.SettlementCurrencyCode,
 		SettlementReportBucket:       e.SettlementReportBucket,
 		RefundNotificationWebHook:    e.RefundNotificationWebHook,
 		CancelledNotificationWebHook: e.CancellationNotificationWebHook,
 		Created:                      e.Created,
 		Modified:                     e.Modified,
+		Status:                       e.Status,
 	}
 }
 
@@ -1,14 +1,16 @@
 package partners
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"strconv"
 	"sync"
 	"time"
+
+	"github.com/onecombine/onecombine-msg-validator/src/secrets"
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
 )
 
 type IssuerProfile struct {
@@ -33,6 +35,7 @@ type IssuerProfile struct {
 	CancelledNotificationWebHook string `json:"cancelled_notification_webhook"`
 	Created                      string `json:"created"`
 	Modified                     string `json:"modified"`
+	Status                       string `json:"status"`
 }
 
 type AcquirerProfile struct {
@@ -54,14 +57,23 @@ type AcquirerProfile struct {
 	SettlementReportBucket string `json:"settlement_report_bucket"`
 	Created                string `json:"created"`
 	Modified               string `json:"modified"`
+	Status                 string `json:"status"`
 }
 
 type PartnerService struct {
-	baseUrl     string
-	acqStore    *MemoryStore
-	issStore    *MemoryStore
+	source      ProfileSource
+	acqStore    *AcquirerStore
+	issStore    *IssuerStore
 	issConsumer IssuerProfileConsumer
 	acqConsumer AcquirerProfileConsumer
+	acqStatus   *RefreshStatus
+	issStatus   *RefreshStatus
+	killSwitch  *KillSwitch
+	snapshot    *Snapshotter
+	consumerErr error
+	ctx         context.Context
+	cancel      context.CancelFunc
+	errs        chan error
 	wg          *sync.WaitGroup
 }
 
@@ -73,185 +85,510 @@ const (
 const REFRESH_ACQUIRERS_SECS string = "REFRESH_ACQUIRERS_SECS"
 const REFRESH_ISSUERS_SECS string = "REFRESH_ISSUERS_SECS"
 
+// NewPartnerService starts without profile events when the consumers cannot be configured, the
+// error is then reported by ConsumerError.
 func NewPartnerService(baseUrl string, issKConfig, acqKConfig *KafkaConfig) *PartnerService {
-	issStore := NewMemoryStore()
-	acqStore := NewMemoryStore()
-
-	issuerConsumer := NewKafkaIssuerProfileConsumer(issStore, issKConfig)
-	acquirerConsumer := NewKafkaAcquirerProfileConsumer(acqStore, acqKConfig)
-
-	var wg sync.WaitGroup
+	service, err := NewPartnerServiceWithContext(context.Background(), baseUrl, issKConfig, acqKConfig)
+	if err != nil {
+		fmt.Printf("Start partner service without profile events, error: %v\n", err)
+	}
+	return service
+}
 
-	service := &PartnerService{
-		baseUrl:     baseUrl,
-		acqStore:    acqStore,
-		issStore:    issStore,
-		issConsumer: issuerConsumer,
-		acqConsumer: acquirerConsumer,
-		wg:          &wg,
+// NewPartnerServiceWithContext starts the profile consumers, they run until ctx is cancelled or
+// Shutdown is called. When a consumer cannot be configured the profiles are still loaded from the
+// profile API, the service is returned without consumers along with the error.
+func NewPartnerServiceWithContext(ctx context.Context, baseUrl string, issKConfig, acqKConfig *KafkaConfig) (*PartnerService, error) {
+	service := newPartnerService(ctx, NewSourceFromEnv(baseUrl))
+	issConsumer, issErr := NewKafkaIssuerProfileConsumer(service.issStore, issKConfig)
+	acqConsumer, acqErr := NewKafkaAcquirerProfileConsumer(service.acqStore, acqKConfig)
+	if issErr != nil || acqErr != nil {
+		service.consumerErr = errors.Join(issErr, acqErr)
+		ReportError(service.errs, service.consumerErr)
+	} else {
+		service.issConsumer, service.acqConsumer = issConsumer, acqConsumer
 	}
 
-	service.refreshAcquirers()
-	service.refreshIssuers()
+	service.initialLoad()
 
-	issuerConsumer.Subscribe(service.wg)
-	acquirerConsumer.Subscribe(service.wg)
+	if service.consumerErr != nil {
+		return service, fmt.Errorf("profile consumers: %w", service.consumerErr)
+	}
+	service.forwardErrors(service.issConsumer.Subscribe(service.ctx, service.wg))
+	service.forwardErrors(service.acqConsumer.Subscribe(service.ctx, service.wg))
 
-	return service
+	return service, nil
 }
 
 func NewPartnewServiceWithoutEvent(baseUrl string) *PartnerService {
+	return NewPartnerServiceFromSource(context.Background(), NewSourceFromEnv(baseUrl))
+}
 
-	issStore := NewMemoryStore()
-	acqStore := NewMemoryStore()
-
-	service := &PartnerService{
-		baseUrl:     baseUrl,
-		acqStore:    acqStore,
-		issStore:    issStore,
-		issConsumer: nil,
-		acqConsumer: nil,
-		wg:          nil,
-	}
+// NewPartnerServiceFromSource loads the profiles of source without kafka consumers, e.g. a file
+// or static source for local development and tests. Watched sources are reloaded on change.
+func NewPartnerServiceFromSource(ctx context.Context, source ProfileSource) *PartnerService {
+	service := newPartnerService(ctx, source)
 
-	service.refreshAcquirers()
-	service.refreshIssuers()
+	service.initialLoad()
 
 	return service
 }
 
-func (s PartnerService) ListAcquirers() ([]*AcquirerProfile, error) {
-	response, err := http.Get(fmt.Sprintf("%s/v1/profile/acquirers", s.baseUrl))
+func newPartnerService(ctx context.Context, source ProfileSource) *PartnerService {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
 
-	if err != nil {
-		return []*AcquirerProfile{}, err
+	var snapshot *Snapshotter
+	if path := utils.GetEnv(PARTNER_SNAPSHOT_PATH, ""); path != "" {
+		cipher, err := secrets.FromEnv()
+		if err != nil || cipher == nil {
+			// The snapshot holds the partner secrets, it is never written in plaintext
+			fmt.Printf("Disable partner snapshot, no key provider to encrypt it (error: %v)\n", err)
+		} else {
+			snapshot = NewSnapshotter(path, cipher)
+		}
+	}
+
+	acqStore, issStore := NewAcquirerStore(), NewIssuerStore()
+	linkApiKeys(acqStore, issStore)
+
+	return &PartnerService{
+		source:     source,
+		acqStore:   acqStore,
+		issStore:   issStore,
+		acqStatus:  NewRefreshStatus(),
+		issStatus:  NewRefreshStatus(),
+		killSwitch: NewKillSwitchFromEnv(),
+		snapshot:   snapshot,
+		ctx:        ctx,
+		cancel:     cancel,
+		errs:       make(chan error, CONSUMER_ERROR_BUFFER),
+		wg:         &wg,
 	}
+}
 
-	responseData, err := io.ReadAll(response.Body)
+func (s PartnerService) ListAcquirers() ([]*AcquirerProfile, error) {
+	acquirers, err := s.source.Acquirers(s.ctx)
 	if err != nil {
 		return []*AcquirerProfile{}, err
 	}
-
-	var acquirers []*AcquirerProfile
-	json.Unmarshal(responseData, &acquirers)
 	return acquirers, nil
 }
 
 func (s PartnerService) ListIssuers() ([]*IssuerProfile, error) {
-	response, err := http.Get(fmt.Sprintf("%s/v1/profile/issuers", s.baseUrl))
-
+	issuers, err := s.source.Issuers(s.ctx)
 	if err != nil {
 		return []*IssuerProfile{}, err
 	}
-
-	responseData, err := io.ReadAll(response.Body)
-	if err != nil {
-		return []*IssuerProfile{}, err
-	}
-
-	var issuers []*IssuerProfile
-	json.Unmarshal(responseData, &issuers)
 	return issuers, nil
 }
 
 func (s PartnerService) refreshAcquirers() error {
-	response, err := http.Get(fmt.Sprintf("%s%s", s.baseUrl, API_LIST_ACQUIRER_PATH))
-
-	if err != nil {
-		return err
+	err := s.loadAcquirers()
+	s.acqStatus.Record(err)
+	if err == nil {
+		s.snapshot.Notify()
 	}
+	return err
+}
 
-	responseData, err := io.ReadAll(response.Body)
+func (s PartnerService) loadAcquirers() error {
+	since := time.Now()
+	acquirers, err := s.source.Acquirers(s.ctx)
 	if err != nil {
 		return err
 	}
+	if len(acquirers) == 0 && s.acqStore.Len() > 0 {
+		fmt.Printf("Keep %d acquirer profiles, profile API listed none\n", s.acqStore.Len())
+	}
 
-	var acquirers []*AcquirerProfile
-	json.Unmarshal(responseData, &acquirers)
-
+	listed := make(map[string]bool)
 	for _, acq := range acquirers {
-		s.acqStore.Set(acq.ApiKey, acq)
+		listed[acq.AcqID] = true
+		if _, err := upsertAcquirer(s.acqStore, acq); err != nil {
+			fmt.Printf("Skip acquirer profile (AcqID: %s), error: %v\n", acq.AcqID, err)
+		}
+	}
+
+	// Partners no longer listed by the profile API have been removed upstream
+	for _, id := range pruneStore(s.acqStore, listed, since) {
+		fmt.Printf("Remove acquirer profile not listed by profile API (AcqID: %s)\n", id)
 	}
 	return nil
 }
 
 func (s PartnerService) refreshIssuers() error {
-	response, err := http.Get(fmt.Sprintf("%s%s", s.baseUrl, API_LIST_ISSUER_PATH))
+	err := s.loadIssuers()
+	s.issStatus.Record(err)
+	if err == nil {
+		s.snapshot.Notify()
+	}
+	return err
+}
 
+func (s PartnerService) loadIssuers() error {
+	since := time.Now()
+	issuers, err := s.source.Issuers(s.ctx)
 	if err != nil {
 		return err
 	}
+	if len(issuers) == 0 && s.issStore.Len() > 0 {
+		fmt.Printf("Keep %d issuer profiles, profile API listed none\n", s.issStore.Len())
+	}
+
+	listed := make(map[string]bool)
+	for _, iss := range issuers {
+		listed[iss.IssuerID] = true
+		if _, err := upsertIssuer(s.issStore, iss); err != nil {
+			fmt.Printf("Skip issuer profile (IssuerID: %s), error: %v\n", iss.IssuerID, err)
+		}
+	}
+
+	// Partners no longer listed by the profile API have been removed upstream
+	for _, id := range pruneStore(s.issStore, listed, since) {
+		fmt.Printf("Remove issuer profile not listed by profile API (IssuerID: %s)\n", id)
+	}
+	return nil
+}
+
+// initialLoad loads the profiles from the profile source, falling back on the snapshot for the
+// partner types it fails to list, then keeps the snapshot up to date.
+func (s PartnerService) initialLoad() {
+	s.syncSuspensions()
+	acqErr := s.refreshAcquirers()
+	issErr := s.refreshIssuers()
+	s.watchSource()
+	if s.snapshot == nil {
+		return
+	}
+
+	if acqErr != nil || issErr != nil {
+		s.restoreSnapshot(acqErr != nil, issErr != nil)
+	}
+	s.runSnapshots()
+}
+
+// syncSuspensions loads the shared suspensions, then reloads them every
+// PARTNER_SUSPENSION_SYNC_SECS until the service is shut down.
+func (s PartnerService) syncSuspensions() {
+	if !s.killSwitch.Shared() {
+		return
+	}
+	// Suspended partners must be refused from the first request
+	if err := s.killSwitch.Sync(s.ctx); err != nil {
+		fmt.Printf("Unable to load partner suspensions, error: %v\n", err)
+	}
 
-	responseData, err := io.ReadAll(response.Body)
+	period, err := strconv.Atoi(os.Getenv(PARTNER_SUSPENSION_SYNC_SECS))
 	if err != nil {
-		return err
+		period = 5 // Default
 	}
+	s.schedule(time.Duration(period)*time.Second, func() error {
+		if err := s.killSwitch.Sync(s.ctx); err != nil {
+			fmt.Printf("Unable to sync partner suspensions, error: %v\n", err)
+			return err
+		}
+		return nil
+	})
+}
 
-	var issuers []*IssuerProfile
-	json.Unmarshal(responseData, &issuers)
+// watchSource reloads the profiles when a watched source changes.
+func (s PartnerService) watchSource() {
+	watched, ok := s.source.(WatchedSource)
+	if !ok {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		watched.Watch(s.ctx, func() {
+			if err := s.Reload(); err != nil {
+				ReportError(s.errs, err)
+			}
+		})
+	}()
+}
 
-	for _, iss := range issuers {
-		s.issStore.Set(iss.ApiKey, iss)
+func (s PartnerService) restoreSnapshot(acquirers, issuers bool) {
+	snapshot, created, err := s.snapshot.Load(s.ctx)
+	if err != nil {
+		fmt.Printf("Unable to load partner snapshot, error: %v\n", err)
+		return
+	}
+
+	if acquirers {
+		for _, acq := range snapshot.Acquirers {
+			if _, err := upsertAcquirer(s.acqStore, acq); err != nil {
+				fmt.Printf("Skip acquirer profile of snapshot (AcqID: %s), error: %v\n", acq.AcqID, err)
+			}
+		}
+		s.acqStatus.Restore(created)
+		fmt.Printf("Profile API unreachable, serve %d acquirers of snapshot taken at %s\n", len(snapshot.Acquirers), created.Format(time.RFC3339))
+	}
+	if issuers {
+		for _, iss := range snapshot.Issuers {
+			if _, err := upsertIssuer(s.issStore, iss); err != nil {
+				fmt.Printf("Skip issuer profile of snapshot (IssuerID: %s), error: %v\n", iss.IssuerID, err)
+			}
+		}
+		s.issStatus.Restore(created)
+		fmt.Printf("Profile API unreachable, serve %d issuers of snapshot taken at %s\n", len(snapshot.Issuers), created.Format(time.RFC3339))
 	}
-	return nil
 }
 
-func (s PartnerService) StartAcquirerScheduler() {
-	period, err := strconv.Atoi(os.Getenv(REFRESH_ACQUIRERS_SECS))
+// runSnapshots writes the snapshot after each refresh and, for the updates received from
+// kafka, when the stores changed since the last write. A last snapshot is written on shutdown.
+func (s PartnerService) runSnapshots() {
+	period, err := strconv.Atoi(os.Getenv(PARTNER_SNAPSHOT_SECS))
 	if err != nil {
-		period = 15 // Default
+		period = 30 // Default
 	}
 
+	s.wg.Add(1)
 	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(time.Duration(period) * time.Second)
+		defer ticker.Stop()
+		var written [2]uint64
 		for {
-			s.refreshAcquirers()
-			time.Sleep(time.Duration(period) * time.Second)
+			select {
+			case <-s.ctx.Done():
+				ctx, cancel := context.WithTimeout(context.WithoutCancel(s.ctx), CONSUMER_COMMIT_TIMEOUT)
+				s.saveSnapshot(ctx, &written)
+				cancel()
+				return
+			case <-s.snapshot.notify:
+			case <-ticker.C:
+			}
+			s.saveSnapshot(s.ctx, &written)
 		}
 	}()
 }
 
+func (s PartnerService) saveSnapshot(ctx context.Context, written *[2]uint64) {
+	// Never replace a snapshot with stores which have not been loaded yet, nor with the restored
+	// profiles, the next instance would take them for fresh ones
+	if !s.acqStatus.Loaded() || !s.issStatus.Loaded() || s.IsStale() {
+		return
+	}
+	versions := [2]uint64{s.acqStore.Version(), s.issStore.Version()}
+	if versions == *written {
+		return
+	}
+
+	err := s.snapshot.Save(ctx, &PartnerSnapshot{
+		Acquirers: s.acqStore.Snapshot(),
+		Issuers:   s.issStore.Snapshot(),
+	})
+	if err != nil {
+		fmt.Printf("Unable to write partner snapshot, error: %v\n", err)
+		ReportError(s.errs, err)
+		return
+	}
+	*written = versions
+}
+
+// IsStale reports whether partner profiles are served from the snapshot because the profile API
+// has been unreachable since startup.
+func (s PartnerService) IsStale() bool {
+	return s.acqStatus.Stale() || s.issStatus.Stale()
+}
+
+// Reload forces a refresh of both acquirer and issuer profiles from the profile source.
+func (s PartnerService) Reload() error {
+	return errors.Join(s.refreshAcquirers(), s.refreshIssuers())
+}
+
+func (s PartnerService) StartAcquirerScheduler() {
+	period, err := strconv.Atoi(os.Getenv(REFRESH_ACQUIRERS_SECS))
+	if err != nil {
+		period = 15 // Default
+	}
+	s.schedule(time.Duration(period)*time.Second, s.refreshAcquirers)
+}
+
 func (s PartnerService) StartIssuerScheduler() {
 	period, err := strconv.Atoi(os.Getenv(REFRESH_ISSUERS_SECS))
 	if err != nil {
 		period = 15 // Default
 	}
+	s.schedule(time.Duration(period)*time.Second, s.refreshIssuers)
+}
 
+// schedule runs refresh now and then every period until the service is shut down.
+func (s PartnerService) schedule(period time.Duration, refresh func() error) {
+	s.wg.Add(1)
 	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
 		for {
-			s.refreshIssuers()
-			time.Sleep(time.Duration(period) * time.Second)
+			if err := refresh(); err != nil && s.ctx.Err() == nil {
+				ReportError(s.errs, err)
+			}
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+			}
 		}
 	}()
 }
 
-func (s PartnerService) GetAcquirerStore() *MemoryStore {
+// forwardErrors relays the errors of a consumer to the service error channel.
+func (s PartnerService) forwardErrors(errs <-chan error) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for err := range errs {
+			ReportError(s.errs, err)
+		}
+	}()
+}
+
+// Errors returns the consumer and refresh errors, errors are dropped when they are not drained.
+func (s PartnerService) Errors() <-chan error {
+	return s.errs
+}
+
+// Shutdown stops the consumers and the schedulers and waits for them, the offsets of the
+// messages in flight are committed. It returns ctx.Err() when ctx expires first.
+func (s PartnerService) Shutdown(ctx context.Context) error {
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s PartnerService) GetAcquirerStore() *AcquirerStore {
 	return s.acqStore
 }
 
-func (s PartnerService) GetIssuerStore() *MemoryStore {
+func (s PartnerService) GetIssuerStore() *IssuerStore {
 	return s.issStore
 }
 
 func (s PartnerService) GetIssuerByID(id string) *IssuerProfile {
-	for _, v := range s.issStore.GetAll() {
-		// Check id
-		if id == v.(*IssuerProfile).IssuerID {
-			return v.(*IssuerProfile)
-		}
+	iss, _ := s.issStore.Get(id)
+	return iss
+}
+
+func (s PartnerService) GetAcquireByID(id string) *AcquirerProfile {
+	acq, _ := s.acqStore.Get(id)
+	return acq
+}
+
+func (s PartnerService) GetIssuerByApiKey(apiKey string) *IssuerProfile {
+	iss, _ := s.issStore.GetBy(INDEX_API_KEY, apiKey)
+	return iss
+}
+
+func (s PartnerService) GetAcquirerByApiKey(apiKey string) *AcquirerProfile {
+	acq, _ := s.acqStore.GetBy(INDEX_API_KEY, apiKey)
+	return acq
+}
+
+func (s PartnerService) GetAcquirerRefreshStatus() *RefreshStatus {
+	return s.acqStatus
+}
+
+func (s PartnerService) GetIssuerRefreshStatus() *RefreshStatus {
+	return s.issStatus
+}
+
+func (s PartnerService) GetConsumerStats() []*ConsumerStats {
+	stats := []*ConsumerStats{}
+	if s.acqConsumer != nil {
+		stats = append(stats, s.acqConsumer.Stats())
+	}
+	if s.issConsumer != nil {
+		stats = append(stats, s.issConsumer.Stats())
 	}
+	return stats
+}
 
-	return nil
+// ConsumerError returns the error which kept the profile consumers from starting.
+func (s PartnerService) ConsumerError() error {
+	return s.consumerErr
 }
 
-func (s PartnerService) GetAcquireByID(id string) *AcquirerProfile {
-	for _, v := range s.acqStore.GetAll() {
-		// Check id
-		if id == v.(*AcquirerProfile).AcqID {
-			return v.(*AcquirerProfile)
-		}
+func (s PartnerService) GetConsumerOffsets() []TopicOffset {
+	offsets := []TopicOffset{}
+	for _, stats := range s.GetConsumerStats() {
+		offsets = append(offsets, stats.Offsets()...)
 	}
+	return offsets
+}
 
-	return nil
+// SuspendPartner is the emergency switch cutting off a partner. It takes effect on the next
+// request, on the other instances once they sync the shared suspensions, and is kept across
+// profile refreshes until LiftSuspension is called. partnerType is PARTNER_TYPE_ACQUIRER or
+// PARTNER_TYPE_ISSUER, an acquirer and an issuer sharing the same id are suspended separately.
+func (s PartnerService) SuspendPartner(partnerType, id, reason string) (*Suspension, error) {
+	switch {
+	case partnerType == PARTNER_TYPE_ACQUIRER && s.GetAcquireByID(id) != nil:
+	case partnerType == PARTNER_TYPE_ISSUER && s.GetIssuerByID(id) != nil:
+	default:
+		return nil, ErrPartnerNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), CONSUMER_COMMIT_TIMEOUT)
+	defer cancel()
+	suspension, err := s.killSwitch.Suspend(ctx, partnerType, id, reason)
+	if err != nil {
+		fmt.Printf("Unable to suspend partner (type: %s, id: %s), error: %v\n", partnerType, id, err)
+		return nil, err
+	}
+	fmt.Printf("Suspend partner (type: %s, id: %s), reason: %s\n", partnerType, id, reason)
+	return suspension, nil
+}
+
+func (s PartnerService) LiftSuspension(partnerType, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), CONSUMER_COMMIT_TIMEOUT)
+	defer cancel()
+	ok, err := s.killSwitch.Lift(ctx, partnerType, id)
+	if err != nil {
+		fmt.Printf("Unable to lift suspension of partner (type: %s, id: %s), error: %v\n", partnerType, id, err)
+		return false, err
+	}
+	if ok {
+		fmt.Printf("Lift suspension of partner (type: %s, id: %s)\n", partnerType, id)
+	}
+	return ok, nil
+}
+
+func (s PartnerService) GetSuspensions() []Suspension {
+	return s.killSwitch.List()
+}
+
+// IsAcquirerActive reports whether the acquirer may authenticate, by profile status and kill switch.
+func (s PartnerService) IsAcquirerActive(acq *AcquirerProfile) bool {
+	return acq.IsActive() && !s.killSwitch.IsSuspended(PARTNER_TYPE_ACQUIRER, acq.AcqID)
+}
+
+func (s PartnerService) IsIssuerActive(iss *IssuerProfile) bool {
+	return iss.IsActive() && !s.killSwitch.IsSuspended(PARTNER_TYPE_ISSUER, iss.IssuerID)
+}
+
+// OnAcquirerChanged calls fn after every acquirer creation, update and deletion, whether it
+// comes from kafka or from a refresh. It returns the function cancelling the subscription.
+func (s PartnerService) OnAcquirerChanged(fn func(AcquirerChange)) func() {
+	return s.acqStore.Changes().Subscribe(fn)
+}
+
+func (s PartnerService) OnIssuerChanged(fn func(IssuerChange)) func() {
+	return s.issStore.Changes().Subscribe(fn)
 }
 
 func (s PartnerService) WaitForCompletion() {
@@ -2,9 +2,11 @@ package partners
 
 import (
 	"context"
+	"fmt"
 	"strings"
 	"time"
 
+	"github.com/onecombine/onecombine-msg-validator/src/secrets"
 	"github.com/onecombine/onecombine-msg-validator/src/utils"
 	"github.com/segmentio/kafka-go"
 	"github.com/segmentio/kafka-go/sasl/aws_msk_iam_v2"
@@ -17,14 +19,34 @@ import (
 )
 
 type pbEventPublisher struct {
-	a *kafka.Writer
-	i *kafka.Writer
+	a      *kafka.Writer
+	i      *kafka.Writer
+	cipher secrets.Cipher
 }
 
 // PublishAcquirerProfileChangedEvent implements EventPublisher.
 func (p *pbEventPublisher) PublishAcquirerProfileChangedEvent(ctx context.Context, acq *AcquirerProfile) error {
+	acq, err := sealAcquirer(ctx, p.cipher, acq)
+	if err != nil {
+		return err
+	}
+
+	msg, err := acquirerProfileMessage(ctx, acq)
+	if err != nil {
+		return err
+	}
+
+	err = p.a.WriteMessages(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func acquirerProfileMessage(ctx context.Context, acq *AcquirerProfile) (kafka.Message, error) {
 	profile := &pb.AcquirerProfile{
-		Version:                "v1.0",
+		Version:                PROFILE_PROTO_VERSION,
 		Id:                     int32(acq.ID),
 		AcqId:                  acq.AcqID,
 		Name:                   acq.Name,
@@ -32,6 +54,7 @@ func (p *pbEventPublisher) PublishAcquirerProfileChangedEvent(ctx context.Contex
 		ApiKey:                 acq.ApiKey,
 		Secret:                 acq.Secret,
 		OrgId:                  int32(acq.OrganizationID),
+		NotificationWebhook:    acq.NotificationHook,
 		SettlementFee:          acq.SettlementFee,
 		SettlementFeeType:      acq.SettlementType,
 		SettlementFeeWaived:    acq.SettlementWaived,
@@ -42,16 +65,28 @@ func (p *pbEventPublisher) PublishAcquirerProfileChangedEvent(ctx context.Contex
 		SettlementReportBucket: acq.SettlementReportBucket,
 		Created:                acq.Created,
 		Modified:               acq.Modified,
+		Status:                 acq.Status,
 	}
 
 	val, err := proto.Marshal(profile)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+
+	env := utils.NewEventEnvelope(ctx, profileEventType(acq.Created, acq.Modified), PAYLOAD_TYPE_ACQUIRER_PROFILE, PROFILE_PROTO_VERSION)
+	return env.Message([]byte(acq.AcqID), utils.CONTENT_TYPE_PROTOBUF, val)
+}
+
+// PublishIssuerProfileChangedEvent implements EventPublisher.
+func (p *pbEventPublisher) PublishIssuerProfileChangedEvent(ctx context.Context, iss *IssuerProfile) error {
+	iss, err := sealIssuer(ctx, p.cipher, iss)
 	if err != nil {
 		return err
 	}
 
-	msg := kafka.Message{
-		Key:   []byte(acq.AcqID),
-		Value: val,
+	msg, err := issuerProfileMessage(ctx, iss)
+	if err != nil {
+		return err
 	}
 
 	err = p.i.WriteMessages(ctx, msg)
@@ -62,11 +97,9 @@ func (p *pbEventPublisher) PublishAcquirerProfileChangedEvent(ctx context.Contex
 	return nil
 }
 
-// PublishIssuerProfileChangedEvent implements EventPublisher.
-func (p *pbEventPublisher) PublishIssuerProfileChangedEvent(ctx context.Context, iss *IssuerProfile) error {
-
+func issuerProfileMessage(ctx context.Context, iss *IssuerProfile) (kafka.Message, error) {
 	profile := &pb.IssuerProfile{
-		Version:                         "v1.0",
+		Version:                         PROFILE_PROTO_VERSION,
 		Id:                              int32(iss.ID),
 		IssuerId:                        iss.IssuerID,
 		Name:                            iss.Name,
@@ -88,24 +121,34 @@ func (p *pbEventPublisher) PublishIssuerProfileChangedEvent(ctx context.Context,
 		CalcellationNotificationWebhook: iss.CancelledNotificationWebHook,
 		Created:                         iss.Created,
 		Modified:                        iss.Modified,
+		Status:                          iss.Status,
 	}
 
 	val, err := proto.Marshal(profile)
 	if err != nil {
-		return err
+		return kafka.Message{}, err
 	}
 
-	msg := kafka.Message{
-		Key:   []byte(iss.IssuerID),
-		Value: val,
-	}
+	env := utils.NewEventEnvelope(ctx, profileEventType(iss.Created, iss.Modified), PAYLOAD_TYPE_ISSUER_PROFILE, PROFILE_PROTO_VERSION)
+	return env.Message([]byte(iss.IssuerID), utils.CONTENT_TYPE_PROTOBUF, val)
+}
 
-	err = p.i.WriteMessages(ctx, msg)
+// PublishAcquirerProfileDeletedEvent implements EventPublisher.
+func (p *pbEventPublisher) PublishAcquirerProfileDeletedEvent(ctx context.Context, acqID string) error {
+	msg, err := deletedMessage(ctx, PAYLOAD_TYPE_ACQUIRER_PROFILE, acqID, utils.CONTENT_TYPE_PROTOBUF)
 	if err != nil {
 		return err
 	}
+	return p.a.WriteMessages(ctx, msg)
+}
 
-	return nil
+// PublishIssuerProfileDeletedEvent implements EventPublisher.
+func (p *pbEventPublisher) PublishIssuerProfileDeletedEvent(ctx context.Context, issuerID string) error {
+	msg, err := deletedMessage(ctx, PAYLOAD_TYPE_ISSUER_PROFILE, issuerID, utils.CONTENT_TYPE_PROTOBUF)
+	if err != nil {
+		return err
+	}
+	return p.i.WriteMessages(ctx, msg)
 }
 
 func NewPBEventPublisher(cfg *EventPublisherConfig) EventPublisher {
@@ -131,6 +174,12 @@ func NewPBEventPublisher(cfg *EventPublisherConfig) EventPublisher {
 		}
 	}
 
+	cipher, err := secrets.FromEnv()
+	if err != nil {
+		fmt.Printf("Unable to load the secret key provider, error: %v\n", err)
+		return nil
+	}
+
 	hosts := strings.Split(cfg.Bootstrap, ",")
 	writeTimeout, _ := time.ParseDuration(cfg.Timeout)
 
@@ -155,7 +204,8 @@ func NewPBEventPublisher(cfg *EventPublisherConfig) EventPublisher {
 	}
 
 	return &pbEventPublisher{
-		i: kafka.NewWriter(issKafkaConfig),
-		a: kafka.NewWriter(acqKafkaConfig),
+		i:      kafka.NewWriter(issKafkaConfig),
+		a:      kafka.NewWriter(acqKafkaConfig),
+		cipher: cipher,
 	}
 }
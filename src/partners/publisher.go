@@ -8,6 +8,7 @@ import (
 	"time"
 
 	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/onecombine/onecombine-msg-validator/src/secrets"
 	"github.com/onecombine/onecombine-msg-validator/src/utils"
 	"github.com/segmentio/kafka-go"
 	"github.com/segmentio/kafka-go/sasl/aws_msk_iam_v2"
@@ -25,25 +26,50 @@ type EventPublisherConfig struct {
 }
 
 type eventPublisher struct {
-	a *kafka.Writer
-	i *kafka.Writer
+	a      *kafka.Writer
+	i      *kafka.Writer
+	cipher secrets.Cipher
 }
 
 type EventPublisher interface {
 	PublishAcquirerProfileChangedEvent(ctx context.Context, acq *AcquirerProfile) error
 	PublishIssuerProfileChangedEvent(ctx context.Context, iss *IssuerProfile) error
+	PublishAcquirerProfileDeletedEvent(ctx context.Context, acqID string) error
+	PublishIssuerProfileDeletedEvent(ctx context.Context, issuerID string) error
+}
+
+// profileEventType tells a profile creation from an update, a new profile is published with
+// the same created and modified timestamps.
+func profileEventType(created, modified string) string {
+	if created != "" && created == modified {
+		return utils.EVENT_TYPE_CREATED
+	}
+	return utils.EVENT_TYPE_UPDATED
+}
+
+// deletedMessage is the DELETED event of a partner, keyed by partner id. It is the last message
+// of the partner on the compacted profile topics.
+func deletedMessage(ctx context.Context, payloadType, id, contentType string) (kafka.Message, error) {
+	env := utils.NewEventEnvelope(ctx, utils.EVENT_TYPE_DELETED, payloadType, PROFILE_PROTO_VERSION)
+	return env.Message([]byte(id), contentType, nil)
 }
 
 func (p *eventPublisher) PublishAcquirerProfileChangedEvent(ctx context.Context, acq *AcquirerProfile) error {
+	acq, err := sealAcquirer(ctx, p.cipher, acq)
+	if err != nil {
+		fmt.Printf("Unable to encrypt acquirer secret, error: %v\n", err)
+		return err
+	}
 
 	val, err := json.Marshal(acq)
 	if err != nil {
 		fmt.Printf("Unable to marshal acquirer profile for publish to kafka, error: %v\n", err)
 		return err
 	}
-	msg := kafka.Message{
-		Key:   []byte(acq.AcqID),
-		Value: val,
+	env := utils.NewEventEnvelope(ctx, profileEventType(acq.Created, acq.Modified), PAYLOAD_TYPE_ACQUIRER_PROFILE, PROFILE_PROTO_VERSION)
+	msg, err := env.Message([]byte(acq.AcqID), utils.CONTENT_TYPE_JSON, val)
+	if err != nil {
+		return err
 	}
 	err = p.a.WriteMessages(ctx, msg)
 	if err != nil {
@@ -55,15 +81,21 @@ func (p *eventPublisher) PublishAcquirerProfileChangedEvent(ctx context.Context,
 }
 
 func (p *eventPublisher) PublishIssuerProfileChangedEvent(ctx context.Context, iss *IssuerProfile) error {
+	iss, err := sealIssuer(ctx, p.cipher, iss)
+	if err != nil {
+		fmt.Printf("Unable to encrypt issuer secret, error: %v\n", err)
+		return err
+	}
 
 	val, err := json.Marshal(iss)
 	if err != nil {
 		fmt.Printf("Unable to marshal issuer profile for publish to kafka, error: %v\n", err)
 		return err
 	}
-	msg := kafka.Message{
-		Key:   []byte(iss.IssuerID),
-		Value: val,
+	env := utils.NewEventEnvelope(ctx, profileEventType(iss.Created, iss.Modified), PAYLOAD_TYPE_ISSUER_PROFILE, PROFILE_PROTO_VERSION)
+	msg, err := env.Message([]byte(iss.IssuerID), utils.CONTENT_TYPE_JSON, val)
+	if err != nil {
+		return err
 	}
 	err = p.i.WriteMessages(ctx, msg)
 	if err != nil {
@@ -74,6 +106,32 @@ func (p *eventPublisher) PublishIssuerProfileChangedEvent(ctx context.Context, i
 	return nil
 }
 
+func (p *eventPublisher) PublishAcquirerProfileDeletedEvent(ctx context.Context, acqID string) error {
+	msg, err := deletedMessage(ctx, PAYLOAD_TYPE_ACQUIRER_PROFILE, acqID, utils.CONTENT_TYPE_JSON)
+	if err != nil {
+		return err
+	}
+	err = p.a.WriteMessages(ctx, msg)
+	if err != nil {
+		fmt.Printf("Unable to publish acquirer profile deletion to kafka, error: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func (p *eventPublisher) PublishIssuerProfileDeletedEvent(ctx context.Context, issuerID string) error {
+	msg, err := deletedMessage(ctx, PAYLOAD_TYPE_ISSUER_PROFILE, issuerID, utils.CONTENT_TYPE_JSON)
+	if err != nil {
+		return err
+	}
+	err = p.i.WriteMessages(ctx, msg)
+	if err != nil {
+		fmt.Printf("Unable to publish issuer profile deletion to kafka, error: %v\n", err)
+		return err
+	}
+	return nil
+}
+
 func NewEventPublisher(cfg *EventPublisherConfig) EventPublisher {
 	var dialer *kafka.Dialer
 
@@ -97,6 +155,12 @@ func NewEventPublisher(cfg *EventPublisherConfig) EventPublisher {
 		}
 	}
 
+	cipher, err := secrets.FromEnv()
+	if err != nil {
+		fmt.Printf("Unable to load the secret key provider, error: %v\n", err)
+		return nil
+	}
+
 	hosts := strings.Split(cfg.Bootstrap, ",")
 	writeTimeout, _ := time.ParseDuration(cfg.Timeout)
 
@@ -121,7 +185,8 @@ func NewEventPublisher(cfg *EventPublisherConfig) EventPublisher {
 	}
 
 	return &eventPublisher{
-		i: kafka.NewWriter(issKafkaConfig),
-		a: kafka.NewWriter(acqKafkaConfig),
+		i:      kafka.NewWriter(issKafkaConfig),
+		a:      kafka.NewWriter(acqKafkaConfig),
+		cipher: cipher,
 	}
 }
@@ -0,0 +1,77 @@
+package partners
+
+import (
+	"sync"
+	"time"
+)
+
+// RefreshStatus records the outcome of the periodic loads from the profile API.
+type RefreshStatus struct {
+	mu          sync.RWMutex
+	loaded      bool
+	lastSuccess time.Time
+	lastAttempt time.Time
+	lastError   error
+	stale       bool
+}
+
+func NewRefreshStatus() *RefreshStatus {
+	return &RefreshStatus{}
+}
+
+func (rs *RefreshStatus) Record(err error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	now := time.Now()
+	rs.lastAttempt = now
+	rs.lastError = err
+	if err == nil {
+		rs.loaded = true
+		rs.stale = false
+		rs.lastSuccess = now
+	}
+}
+
+// Restore records profiles loaded from a snapshot taken at created, they are stale until the
+// next successful load.
+func (rs *RefreshStatus) Restore(created time.Time) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.loaded = true
+	rs.stale = true
+	rs.lastSuccess = created
+}
+
+// Stale reports whether the profiles come from a snapshot rather than the profile API.
+func (rs *RefreshStatus) Stale() bool {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.stale
+}
+
+// Loaded reports whether at least one load has succeeded.
+func (rs *RefreshStatus) Loaded() bool {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.loaded
+}
+
+func (rs *RefreshStatus) LastSuccess() time.Time {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.lastSuccess
+}
+
+func (rs *RefreshStatus) LastAttempt() time.Time {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.lastAttempt
+}
+
+func (rs *RefreshStatus) LastError() error {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.lastError
+}
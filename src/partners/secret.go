@@ -0,0 +1,28 @@
+package partners
+
+import (
+	"context"
+
+	"github.com/onecombine/onecombine-msg-validator/src/secrets"
+)
+
+// sealAcquirer returns a copy of acq with its secret encrypted for publishing.
+func sealAcquirer(ctx context.Context, c secrets.Cipher, acq *AcquirerProfile) (*AcquirerProfile, error) {
+	secret, err := secrets.Encrypt(ctx, c, acq.Secret, acq.AcqID)
+	if err != nil {
+		return nil, err
+	}
+	sealed := *acq
+	sealed.Secret = secret
+	return &sealed, nil
+}
+
+func sealIssuer(ctx context.Context, c secrets.Cipher, iss *IssuerProfile) (*IssuerProfile, error) {
+	secret, err := secrets.Encrypt(ctx, c, iss.Secret, iss.IssuerID)
+	if err != nil {
+		return nil, err
+	}
+	sealed := *iss
+	sealed.Secret = secret
+	return &sealed, nil
+}
@@ -0,0 +1,135 @@
+package partners
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/onecombine/onecombine-msg-validator/src/secrets"
+)
+
+const PARTNER_SNAPSHOT_PATH string = "PARTNER_SNAPSHOT_PATH"
+const PARTNER_SNAPSHOT_SECS string = "PARTNER_SNAPSHOT_SECS"
+
+const SNAPSHOT_VERSION = 1
+
+// Additional data of the snapshot encryption, a profile secret cannot be swapped for it
+const snapshotAad = "partner-snapshot"
+
+var ErrSnapshotChecksum = errors.New("snapshot checksum mismatch")
+var ErrSnapshotVersion = errors.New("unsupported snapshot version")
+
+type PartnerSnapshot struct {
+	Acquirers []*AcquirerProfile `json:"acquirers"`
+	Issuers   []*IssuerProfile   `json:"issuers"`
+}
+
+type snapshotFile struct {
+	Version  int    `json:"version"`
+	Created  string `json:"created"`
+	Checksum string `json:"checksum"`
+	Payload  string `json:"payload"`
+}
+
+// Snapshotter persists the partner profiles to a local file, encrypted since it holds the partner
+// secrets, to start when the profile API is unreachable.
+type Snapshotter struct {
+	path   string
+	cipher secrets.Cipher
+	notify chan struct{}
+}
+
+func NewSnapshotter(path string, cipher secrets.Cipher) *Snapshotter {
+	return &Snapshotter{
+		path:   path,
+		cipher: cipher,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// Notify asks for a snapshot after a profile update, it does not wait for the write.
+func (sn *Snapshotter) Notify() {
+	if sn == nil {
+		return
+	}
+	select {
+	case sn.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Save writes the snapshot atomically, a crash during the write leaves the previous one.
+func (sn *Snapshotter) Save(ctx context.Context, snapshot *PartnerSnapshot) error {
+	raw, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	payload, err := sn.cipher.Encrypt(ctx, string(raw), snapshotAad)
+	if err != nil {
+		return err
+	}
+
+	checksum := sha256.Sum256([]byte(payload))
+	content, err := json.Marshal(snapshotFile{
+		Version:  SNAPSHOT_VERSION,
+		Created:  time.Now().UTC().Format(time.RFC3339Nano),
+		Checksum: hex.EncodeToString(checksum[:]),
+		Payload:  payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(sn.path), filepath.Base(sn.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(content); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), sn.path)
+}
+
+// Load reads the snapshot and returns it with the time it was taken.
+func (sn *Snapshotter) Load(ctx context.Context) (*PartnerSnapshot, time.Time, error) {
+	content, err := os.ReadFile(sn.path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var file snapshotFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, time.Time{}, err
+	}
+	if file.Version != SNAPSHOT_VERSION {
+		return nil, time.Time{}, fmt.Errorf("%w: %d", ErrSnapshotVersion, file.Version)
+	}
+	checksum := sha256.Sum256([]byte(file.Payload))
+	if hex.EncodeToString(checksum[:]) != file.Checksum {
+		return nil, time.Time{}, ErrSnapshotChecksum
+	}
+
+	raw, err := secrets.Decrypt(ctx, sn.cipher, file.Payload, snapshotAad)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var snapshot PartnerSnapshot
+	if err := json.Unmarshal([]byte(raw), &snapshot); err != nil {
+		return nil, time.Time{}, err
+	}
+	return &snapshot, ParseModified(file.Created), nil
+}
@@ -0,0 +1,88 @@
+package partners
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/onecombine/onecombine-msg-validator/src/secrets"
+)
+
+func newTestSnapshotter(t *testing.T) *Snapshotter {
+	provider, err := secrets.NewStaticKeyProvider(bytes.Repeat([]byte{9}, secrets.DATA_KEY_SIZE))
+	assert.Nil(t, err)
+	return NewSnapshotter(filepath.Join(t.TempDir(), "partners.snapshot"), secrets.NewEnvelopeCipher(provider, time.Hour, false))
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	sn := newTestSnapshotter(t)
+	snapshot := &PartnerSnapshot{
+		Acquirers: []*AcquirerProfile{{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"}},
+		Issuers:   []*IssuerProfile{{IssuerID: "200099", ApiKey: "KEY-2", Secret: "b4Rt8-Mn2Qs-6Yh1"}},
+	}
+
+	assert.Nil(t, sn.Save(ctx, snapshot))
+	content, _ := os.ReadFile(sn.path)
+	assert.NotContains(t, string(content), "a7Fq2-Lx9Pw-3Zk8", "Secrets encrypted at rest")
+
+	loaded, created, err := sn.Load(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, snapshot, loaded)
+	assert.WithinDuration(t, time.Now(), created, time.Minute)
+
+	// Corrupted payload
+	os.WriteFile(sn.path, bytes.Replace(content, []byte(`"payload":"enc:v1:`), []byte(`"payload":"enc:v1:A`), 1), 0600)
+	_, _, err = sn.Load(ctx)
+	assert.ErrorIs(t, err, ErrSnapshotChecksum)
+}
+
+func TestColdStartFromSnapshot(t *testing.T) {
+	ctx := context.Background()
+	sn := newTestSnapshotter(t)
+	sn.Save(ctx, &PartnerSnapshot{
+		Acquirers: []*AcquirerProfile{{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"}},
+		Issuers:   []*IssuerProfile{{IssuerID: "200099", ApiKey: "KEY-2", Secret: "b4Rt8-Mn2Qs-6Yh1"}},
+	})
+
+	// The profile API is unreachable
+	s := newPartnerService(ctx, NewHTTPSource("http://127.0.0.1:0"))
+	s.snapshot = sn
+	s.initialLoad()
+
+	assert.Equal(t, "a7Fq2-Lx9Pw-3Zk8", s.GetAcquirerByApiKey("KEY-1").Secret)
+	assert.Equal(t, "b4Rt8-Mn2Qs-6Yh1", s.GetIssuerByApiKey("KEY-2").Secret)
+	assert.Equal(t, true, s.IsStale())
+	assert.Equal(t, true, s.GetAcquirerRefreshStatus().Loaded())
+	_, created, err := sn.Load(ctx)
+	assert.Nil(t, err)
+
+	// Kafka updates of the restored profiles are not snapshotted either
+	upsertAcquirer(s.GetAcquirerStore(), &AcquirerProfile{AcqID: "100091", ApiKey: "KEY-3", Secret: "c8Wd3-Pj5Tn-2Xv7"})
+	sn.Notify()
+	shutdown, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	assert.Nil(t, s.Shutdown(shutdown))
+
+	// The next cold start restores the original snapshot
+	s = newPartnerService(ctx, NewHTTPSource("http://127.0.0.1:0"))
+	s.snapshot = sn
+	s.initialLoad()
+	assert.Equal(t, 1, s.GetAcquirerStore().Len())
+	_, restored, _ := sn.Load(ctx)
+	assert.Equal(t, created, restored, "Restored profiles not written back as a fresh snapshot")
+	assert.Nil(t, s.Shutdown(shutdown))
+
+	// Without snapshot the stores stay empty
+	s = newPartnerService(ctx, NewHTTPSource("http://127.0.0.1:0"))
+	s.snapshot = NewSnapshotter(filepath.Join(t.TempDir(), "missing"), sn.cipher)
+	s.initialLoad()
+	assert.Equal(t, 0, s.GetAcquirerStore().Len())
+	assert.Equal(t, false, s.IsStale())
+	assert.Nil(t, s.Shutdown(shutdown))
+}
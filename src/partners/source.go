@@ -0,0 +1,321 @@
+package partners
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/onecombine/onecombine-msg-validator/src/apiclient"
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+const PARTNER_PROFILE_FILE string = "PARTNER_PROFILE_FILE"
+const PARTNER_PROFILE_FILE_POLL string = "PARTNER_PROFILE_FILE_POLL"
+
+// ProfileSource lists the partner profiles. Sources return the complete lists, the partners they
+// do not list are removed from the stores.
+type ProfileSource interface {
+	Name() string
+	Acquirers(ctx context.Context) ([]*AcquirerProfile, error)
+	Issuers(ctx context.Context) ([]*IssuerProfile, error)
+}
+
+// WatchedSource is a source notifying its changes, Watch calls changed until ctx is cancelled.
+type WatchedSource interface {
+	Watch(ctx context.Context, changed func())
+}
+
+type httpSource struct {
+	api *apiclient.Client
+
+	mu        sync.Mutex
+	acquirers []*AcquirerProfile
+	issuers   []*IssuerProfile
+}
+
+// NewHTTPSource lists the profiles of the profile API, unchanged lists are not fetched again.
+func NewHTTPSource(baseUrl string) ProfileSource {
+	return &httpSource{api: apiclient.NewClient(apiclient.NewConfigFromEnv(baseUrl))}
+}
+
+func (h *httpSource) Name() string {
+	return "http"
+}
+
+func (h *httpSource) Acquirers(ctx context.Context) ([]*AcquirerProfile, error) {
+	var acquirers []*AcquirerProfile
+	err := h.api.GetJSONIfModified(ctx, API_LIST_ACQUIRER_PATH, &acquirers)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case errors.Is(err, apiclient.ErrNotModified):
+		return copyProfiles(h.acquirers), nil
+	case err != nil:
+		return nil, err
+	}
+	h.acquirers = acquirers
+	return copyProfiles(acquirers), nil
+}
+
+func (h *httpSource) Issuers(ctx context.Context) ([]*IssuerProfile, error) {
+	var issuers []*IssuerProfile
+	err := h.api.GetJSONIfModified(ctx, API_LIST_ISSUER_PATH, &issuers)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case errors.Is(err, apiclient.ErrNotModified):
+		return copyProfiles(h.issuers), nil
+	case err != nil:
+		return nil, err
+	}
+	h.issuers = issuers
+	return copyProfiles(issuers), nil
+}
+
+// copyProfiles keeps the cached list apart from the profiles written to the stores.
+func copyProfiles[T any](profiles []*T) []*T {
+	if profiles == nil {
+		return nil
+	}
+	copies := make([]*T, len(profiles))
+	for i, p := range profiles {
+		c := *p
+		copies[i] = &c
+	}
+	return copies
+}
+
+// ProfileList is the content of the profile files and of the static sources.
+type ProfileList struct {
+	Acquirers []*AcquirerProfile `json:"acquirers"`
+	Issuers   []*IssuerProfile   `json:"issuers"`
+}
+
+type staticSource struct {
+	list ProfileList
+}
+
+// NewStaticSource serves a fixed list, e.g. in tests.
+func NewStaticSource(acquirers []*AcquirerProfile, issuers []*IssuerProfile) ProfileSource {
+	return &staticSource{list: ProfileList{Acquirers: acquirers, Issuers: issuers}}
+}
+
+func (s *staticSource) Name() string {
+	return "static"
+}
+
+func (s *staticSource) Acquirers(ctx context.Context) ([]*AcquirerProfile, error) {
+	return s.list.Acquirers, nil
+}
+
+func (s *staticSource) Issuers(ctx context.Context) ([]*IssuerProfile, error) {
+	return s.list.Issuers, nil
+}
+
+type fileSource struct {
+	path string
+	poll time.Duration
+
+	mu       sync.Mutex
+	modified time.Time
+	size     int64
+	list     ProfileList
+}
+
+// NewFileSource reads the profiles of a JSON or YAML file, by extension. The file is parsed
+// again when it changes and Watch polls it every poll for hot reload.
+func NewFileSource(path string, poll time.Duration) ProfileSource {
+	return &fileSource{path: path, poll: poll}
+}
+
+func (f *fileSource) Name() string {
+	return "file:" + f.path
+}
+
+func (f *fileSource) Acquirers(ctx context.Context) ([]*AcquirerProfile, error) {
+	list, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	return list.Acquirers, nil
+}
+
+func (f *fileSource) Issuers(ctx context.Context) ([]*IssuerProfile, error) {
+	list, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	return list.Issuers, nil
+}
+
+func (f *fileSource) load() (ProfileList, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return ProfileList{}, err
+	}
+	if info.ModTime().Equal(f.modified) && info.Size() == f.size {
+		return f.list, nil
+	}
+
+	content, err := os.ReadFile(f.path)
+	if err != nil {
+		return ProfileList{}, err
+	}
+	var list ProfileList
+	if err := parseProfileFile(f.path, content, &list); err != nil {
+		return ProfileList{}, fmt.Errorf("parse %s: %w", f.path, err)
+	}
+
+	f.list, f.modified, f.size = list, info.ModTime(), info.Size()
+	return list, nil
+}
+
+func (f *fileSource) changed() bool {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return !info.ModTime().Equal(f.modified) || info.Size() != f.size
+}
+
+// Watch implements WatchedSource.
+func (f *fileSource) Watch(ctx context.Context, changed func()) {
+	if f.poll <= 0 {
+		return
+	}
+	ticker := time.NewTicker(f.poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if f.changed() {
+				fmt.Printf("Profile file changed, reload %s\n", f.path)
+				changed()
+			}
+		}
+	}
+}
+
+// parseProfileFile decodes JSON, or YAML converted to JSON so both formats use the json field
+// names of the profiles.
+func parseProfileFile(path string, content []byte, list *ProfileList) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var doc any
+		if err := yaml.Unmarshal(content, &doc); err != nil {
+			return err
+		}
+		raw, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		content = raw
+	}
+	return json.Unmarshal(content, list)
+}
+
+type compositeSource struct {
+	sources []ProfileSource
+}
+
+// NewCompositeSource merges sources in priority order, a partner listed by several sources is
+// taken from the first one. It fails when any source fails so no partner is removed because of
+// an unavailable source.
+func NewCompositeSource(sources ...ProfileSource) ProfileSource {
+	return &compositeSource{sources: sources}
+}
+
+func (c *compositeSource) Name() string {
+	names := make([]string, 0, len(c.sources))
+	for _, source := range c.sources {
+		names = append(names, source.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (c *compositeSource) Acquirers(ctx context.Context) ([]*AcquirerProfile, error) {
+	merged := []*AcquirerProfile{}
+	seen := make(map[string]bool)
+	for _, source := range c.sources {
+		acquirers, err := source.Acquirers(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", source.Name(), err)
+		}
+		for _, acq := range acquirers {
+			if !seen[acq.AcqID] {
+				seen[acq.AcqID] = true
+				merged = append(merged, acq)
+			}
+		}
+	}
+	return merged, nil
+}
+
+func (c *compositeSource) Issuers(ctx context.Context) ([]*IssuerProfile, error) {
+	merged := []*IssuerProfile{}
+	seen := make(map[string]bool)
+	for _, source := range c.sources {
+		issuers, err := source.Issuers(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", source.Name(), err)
+		}
+		for _, iss := range issuers {
+			if !seen[iss.IssuerID] {
+				seen[iss.IssuerID] = true
+				merged = append(merged, iss)
+			}
+		}
+	}
+	return merged, nil
+}
+
+// Watch implements WatchedSource for the watched sources of the composition.
+func (c *compositeSource) Watch(ctx context.Context, changed func()) {
+	var wg sync.WaitGroup
+	for _, source := range c.sources {
+		if watched, ok := source.(WatchedSource); ok {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				watched.Watch(ctx, changed)
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// NewSourceFromEnv returns the profile API source, behind the PARTNER_PROFILE_FILE profiles when
+// set. Without baseUrl the file is the only source.
+func NewSourceFromEnv(baseUrl string) ProfileSource {
+	path := utils.GetEnv(PARTNER_PROFILE_FILE, "")
+	if path == "" {
+		return NewHTTPSource(baseUrl)
+	}
+
+	poll, err := time.ParseDuration(utils.GetEnv(PARTNER_PROFILE_FILE_POLL, "2s"))
+	if err != nil {
+		poll = 2 * time.Second
+	}
+	file := NewFileSource(path, poll)
+	if baseUrl == "" {
+		return file
+	}
+	return NewCompositeSource(file, NewHTTPSource(baseUrl))
+}
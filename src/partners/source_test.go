@@ -0,0 +1,117 @@
+package partners
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const profileYaml = `
+acquirers:
+  - acqId: "100090"
+    name: Local Acquirer
+    apiKey: KEY-1
+    secret: a7Fq2-Lx9Pw-3Zk8
+issuers:
+  - issuer_id: "200099"
+    apiKey: KEY-2
+    secret: b4Rt8-Mn2Qs-6Yh1
+    fx_name: RUBTHB
+`
+
+type failingSource struct{}
+
+func (failingSource) Name() string { return "failing" }
+func (failingSource) Acquirers(ctx context.Context) ([]*AcquirerProfile, error) {
+	return nil, errors.New("unavailable")
+}
+func (failingSource) Issuers(ctx context.Context) ([]*IssuerProfile, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestFileSource(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "profiles.yaml")
+	os.WriteFile(path, []byte(profileYaml), 0600)
+
+	source := NewFileSource(path, 0)
+	acquirers, err := source.Acquirers(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []*AcquirerProfile{{AcqID: "100090", Name: "Local Acquirer", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"}}, acquirers)
+	issuers, _ := source.Issuers(ctx)
+	assert.Equal(t, "RUBTHB", issuers[0].FXName, "YAML uses the json field names")
+
+	json := filepath.Join(t.TempDir(), "profiles.json")
+	os.WriteFile(json, []byte(`{"acquirers":[{"acqId":"100091","apiKey":"KEY-3","secret":"c9Wd3-Kp7Ve-5Xj2"}]}`), 0600)
+	acquirers, err = NewFileSource(json, 0).Acquirers(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "100091", acquirers[0].AcqID)
+
+	os.WriteFile(json, []byte(`{"acquirers":`), 0600)
+	_, err = NewFileSource(json, 0).Acquirers(ctx)
+	assert.NotNil(t, err)
+}
+
+func TestHTTPSourceReturnsCopies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`[{"acqId": "100090", "apiKey": "KEY-1", "secret": "a7Fq2-Lx9Pw-3Zk8"}]`))
+	}))
+	defer server.Close()
+
+	source := NewHTTPSource(server.URL)
+	acquirers, err := source.Acquirers(context.Background())
+	assert.Nil(t, err)
+	acquirers[0].ApiKey = "KEY-MODIFIED"
+
+	acquirers, err = source.Acquirers(context.Background())
+	assert.Nil(t, err, "Not modified")
+	assert.Equal(t, "KEY-1", acquirers[0].ApiKey, "Cached list unchanged")
+}
+
+func TestCompositeSource(t *testing.T) {
+	ctx := context.Background()
+	override := NewStaticSource([]*AcquirerProfile{{AcqID: "100090", ApiKey: "LOCAL", Secret: "a7Fq2-Lx9Pw-3Zk8"}}, nil)
+	base := NewStaticSource(
+		[]*AcquirerProfile{{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"}, {AcqID: "100091", ApiKey: "KEY-2", Secret: "b4Rt8-Mn2Qs-6Yh1"}},
+		[]*IssuerProfile{{IssuerID: "200099", ApiKey: "KEY-3", Secret: "c9Wd3-Kp7Ve-5Xj2"}},
+	)
+
+	acquirers, err := NewCompositeSource(override, base).Acquirers(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(acquirers))
+	assert.Equal(t, "LOCAL", acquirers[0].ApiKey, "First source wins")
+	issuers, _ := NewCompositeSource(override, base).Issuers(ctx)
+	assert.Equal(t, 1, len(issuers))
+
+	_, err = NewCompositeSource(base, failingSource{}).Acquirers(ctx)
+	assert.ErrorContains(t, err, "failing: unavailable", "A failing source fails the load")
+}
+
+func TestPartnerServiceHotReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	os.WriteFile(path, []byte(`{"acquirers":[{"acqId":"100090","apiKey":"KEY-1","secret":"a7Fq2-Lx9Pw-3Zk8"}]}`), 0600)
+
+	s := NewPartnerServiceFromSource(context.Background(), NewFileSource(path, 5*time.Millisecond))
+	assert.Equal(t, "100090", s.GetAcquirerByApiKey("KEY-1").AcqID)
+
+	os.WriteFile(path, []byte(`{"acquirers":[{"acqId":"100091","apiKey":"KEY-2","secret":"b4Rt8-Mn2Qs-6Yh1"}]}`), 0600)
+	assert.Eventually(t, func() bool {
+		return s.GetAcquirerByApiKey("KEY-2") != nil && s.GetAcquirerByApiKey("KEY-1") == nil
+	}, time.Second, 5*time.Millisecond, "File changes reloaded")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(t, s.Shutdown(ctx))
+}
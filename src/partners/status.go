@@ -0,0 +1,271 @@
+package partners
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+const (
+	PARTNER_STATUS_ACTIVE     = "ACTIVE"
+	PARTNER_STATUS_SUSPENDED  = "SUSPENDED"
+	PARTNER_STATUS_TERMINATED = "TERMINATED"
+)
+
+const (
+	PARTNER_TYPE_ACQUIRER = "acquirer"
+	PARTNER_TYPE_ISSUER   = "issuer"
+)
+
+const PARTNER_SUSPENSION_BACKEND string = "PARTNER_SUSPENSION_BACKEND"
+const PARTNER_SUSPENSION_SYNC_SECS string = "PARTNER_SUSPENSION_SYNC_SECS"
+
+const (
+	SUSPENSIONS_MEMORY = "memory"
+	SUSPENSIONS_REDIS  = "redis"
+)
+
+// Redis hash of the suspensions by partner type and id, see suspensionKey
+const SUSPENSIONS_KEY = "PARTNER-SUSPENSIONS"
+
+var ErrPartnerNotFound = errors.New("partner not found")
+
+// normalizeStatus maps a profile status to one of the known statuses. Profiles published
+// before the status existed carry none and are treated as active.
+func normalizeStatus(status string) string {
+	switch strings.ToUpper(strings.TrimSpace(status)) {
+	case "", PARTNER_STATUS_ACTIVE:
+		return PARTNER_STATUS_ACTIVE
+	case PARTNER_STATUS_SUSPENDED:
+		return PARTNER_STATUS_SUSPENDED
+	case PARTNER_STATUS_TERMINATED:
+		return PARTNER_STATUS_TERMINATED
+	}
+	// Unknown statuses must not grant access
+	return PARTNER_STATUS_SUSPENDED
+}
+
+func (acq *AcquirerProfile) IsActive() bool {
+	return normalizeStatus(acq.Status) == PARTNER_STATUS_ACTIVE
+}
+
+func (iss *IssuerProfile) IsActive() bool {
+	return normalizeStatus(iss.Status) == PARTNER_STATUS_ACTIVE
+}
+
+type Suspension struct {
+	PartnerType string    `json:"partner_type"`
+	PartnerID   string    `json:"partner_id"`
+	Reason      string    `json:"reason"`
+	Suspended   time.Time `json:"suspended"`
+}
+
+// suspensionKey tells an acquirer and an issuer sharing the same id apart.
+func suspensionKey(partnerType, partnerID string) string {
+	return partnerType + ":" + partnerID
+}
+
+// SuspensionStore shares the suspensions between the instances of the validator.
+type SuspensionStore interface {
+	Save(ctx context.Context, s Suspension) error
+	// Remove reports whether the partner was suspended
+	Remove(ctx context.Context, partnerType, partnerID string) (bool, error)
+	Load(ctx context.Context) ([]Suspension, error)
+}
+
+type memorySuspensions struct {
+	mu        sync.Mutex
+	suspended map[string]Suspension
+}
+
+// NewMemorySuspensions shares the suspensions between the kill switches of a process only.
+func NewMemorySuspensions() SuspensionStore {
+	return &memorySuspensions{suspended: make(map[string]Suspension)}
+}
+
+func (m *memorySuspensions) Save(ctx context.Context, s Suspension) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.suspended[suspensionKey(s.PartnerType, s.PartnerID)] = s
+	return nil
+}
+
+func (m *memorySuspensions) Remove(ctx context.Context, partnerType, partnerID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := suspensionKey(partnerType, partnerID)
+	_, ok := m.suspended[key]
+	delete(m.suspended, key)
+	return ok, nil
+}
+
+func (m *memorySuspensions) Load(ctx context.Context) ([]Suspension, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]Suspension, 0, len(m.suspended))
+	for _, s := range m.suspended {
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+type redisSuspensions struct {
+	client *redis.Client
+}
+
+// NewRedisSuspensions keeps the suspensions in a redis hash by partner type and id, so they apply to every
+// instance and survive restarts.
+func NewRedisSuspensions(cache *utils.Cache) SuspensionStore {
+	return &redisSuspensions{client: cache.Client}
+}
+
+func (r *redisSuspensions) Save(ctx context.Context, s Suspension) error {
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return r.client.HSet(ctx, SUSPENSIONS_KEY, suspensionKey(s.PartnerType, s.PartnerID), string(raw)).Err()
+}
+
+func (r *redisSuspensions) Remove(ctx context.Context, partnerType, partnerID string) (bool, error) {
+	n, err := r.client.HDel(ctx, SUSPENSIONS_KEY, suspensionKey(partnerType, partnerID)).Result()
+	return n > 0, err
+}
+
+func (r *redisSuspensions) Load(ctx context.Context) ([]Suspension, error) {
+	values, err := r.client.HGetAll(ctx, SUSPENSIONS_KEY).Result()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Suspension, 0, len(values))
+	for key, raw := range values {
+		var s Suspension
+		if err := json.Unmarshal([]byte(raw), &s); err != nil {
+			// Unreadable entries still suspend the partner
+			partnerType, id, _ := strings.Cut(key, ":")
+			s = Suspension{PartnerType: partnerType, PartnerID: id, Reason: "unreadable suspension"}
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+// KillSwitch holds the partners suspended by an operator. It overrides the profile status and
+// survives profile refreshes until it is lifted. Without a shared store the suspensions only
+// apply to this instance until it restarts, with one they are written there first and Sync picks
+// up the changes made by the other instances.
+type KillSwitch struct {
+	mu        sync.RWMutex
+	suspended map[string]*Suspension
+	shared    SuspensionStore
+}
+
+func NewKillSwitch() *KillSwitch {
+	return &KillSwitch{suspended: make(map[string]*Suspension)}
+}
+
+func NewSharedKillSwitch(shared SuspensionStore) *KillSwitch {
+	return &KillSwitch{suspended: make(map[string]*Suspension), shared: shared}
+}
+
+// NewKillSwitchFromEnv shares the suspensions in redis when PARTNER_SUSPENSION_BACKEND is redis,
+// the default when URL_REDIS_HOST is set.
+func NewKillSwitchFromEnv() *KillSwitch {
+	backend := SUSPENSIONS_MEMORY
+	if os.Getenv(utils.REDIS_HOST) != "" {
+		backend = SUSPENSIONS_REDIS
+	}
+	if strings.ToLower(utils.GetEnv(PARTNER_SUSPENSION_BACKEND, backend)) == SUSPENSIONS_REDIS {
+		return NewSharedKillSwitch(NewRedisSuspensions(utils.NewCache()))
+	}
+	fmt.Printf("Partner suspensions apply to this instance only, no shared backend\n")
+	return NewKillSwitch()
+}
+
+func (ks *KillSwitch) Suspend(ctx context.Context, partnerType, partnerID, reason string) (*Suspension, error) {
+	s := &Suspension{PartnerType: partnerType, PartnerID: partnerID, Reason: reason, Suspended: time.Now()}
+	if ks.shared != nil {
+		if err := ks.shared.Save(ctx, *s); err != nil {
+			return nil, err
+		}
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.suspended[suspensionKey(partnerType, partnerID)] = s
+	return s, nil
+}
+
+func (ks *KillSwitch) Lift(ctx context.Context, partnerType, partnerID string) (bool, error) {
+	removed := false
+	if ks.shared != nil {
+		var err error
+		if removed, err = ks.shared.Remove(ctx, partnerType, partnerID); err != nil {
+			return false, err
+		}
+	}
+
+	key := suspensionKey(partnerType, partnerID)
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	_, ok := ks.suspended[key]
+	delete(ks.suspended, key)
+	return ok || removed, nil
+}
+
+// Sync replaces the suspensions of this instance with the shared ones. They are kept as they are
+// when the shared store is unreachable.
+func (ks *KillSwitch) Sync(ctx context.Context) error {
+	if ks.shared == nil {
+		return nil
+	}
+	list, err := ks.shared.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	suspended := make(map[string]*Suspension, len(list))
+	for i := range list {
+		suspended[suspensionKey(list[i].PartnerType, list[i].PartnerID)] = &list[i]
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.suspended = suspended
+	return nil
+}
+
+func (ks *KillSwitch) Shared() bool {
+	return ks.shared != nil
+}
+
+func (ks *KillSwitch) IsSuspended(partnerType, partnerID string) bool {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	_, ok := ks.suspended[suspensionKey(partnerType, partnerID)]
+	return ok
+}
+
+func (ks *KillSwitch) List() []Suspension {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	list := make([]Suspension, 0, len(ks.suspended))
+	for _, s := range ks.suspended {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return suspensionKey(list[i].PartnerType, list[i].PartnerID) < suspensionKey(list[j].PartnerType, list[j].PartnerID)
+	})
+	return list
+}
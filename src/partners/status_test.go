@@ -0,0 +1,155 @@
+package partners
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeStatus(t *testing.T) {
+	assert.Equal(t, PARTNER_STATUS_ACTIVE, normalizeStatus(""))
+	assert.Equal(t, PARTNER_STATUS_ACTIVE, normalizeStatus("active"))
+	assert.Equal(t, PARTNER_STATUS_SUSPENDED, normalizeStatus("SUSPENDED"))
+	assert.Equal(t, PARTNER_STATUS_TERMINATED, normalizeStatus(" terminated "))
+	assert.Equal(t, PARTNER_STATUS_SUSPENDED, normalizeStatus("UNKNOWN"), "Unknown status must not grant access")
+}
+
+func TestProfileDeletion(t *testing.T) {
+	acqConsumer := &acquirerConsumer{store: NewAcquirerStore(), stats: NewConsumerStats("acquirer")}
+	issConsumer := &issuerConsumer{store: NewIssuerStore(), stats: NewConsumerStats("issuer")}
+
+	assert.Nil(t, acqConsumer.Process(&AcquirerProfileEvent{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"}))
+	assert.Nil(t, acqConsumer.Process(&AcquirerProfileEvent{AcqID: "100091", ApiKey: "KEY-2", Secret: "a7Fq2-Lx9Pw-3Zk8"}))
+	assert.Nil(t, issConsumer.Process(&IssuerProfileEvent{IssuerID: "200099", ApiKey: "KEY-3", Secret: "a7Fq2-Lx9Pw-3Zk8"}))
+
+	// Tombstone
+	assert.Nil(t, acqConsumer.Delete("100090"))
+	_, ok := acqConsumer.store.GetBy(INDEX_API_KEY, "KEY-1")
+	assert.Equal(t, false, ok, "Deleted acquirer must not authenticate")
+	assert.ErrorIs(t, acqConsumer.Delete(""), ErrMissingField)
+
+	// Terminated status
+	assert.Nil(t, acqConsumer.Process(&AcquirerProfileEvent{AcqID: "100091", Status: PARTNER_STATUS_TERMINATED}))
+	assert.Equal(t, 0, acqConsumer.store.Len())
+
+	assert.Nil(t, issConsumer.Delete("200099"))
+	assert.Equal(t, 0, issConsumer.store.Len())
+
+	// Suspended partners stay in the store
+	assert.Nil(t, acqConsumer.Process(&AcquirerProfileEvent{AcqID: "100092", ApiKey: "KEY-4", Secret: "a7Fq2-Lx9Pw-3Zk8", Status: PARTNER_STATUS_SUSPENDED}))
+	acq, ok := acqConsumer.store.GetBy(INDEX_API_KEY, "KEY-4")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, false, acq.IsActive())
+}
+
+func TestRefreshRemovesUnlistedPartners(t *testing.T) {
+	acquirers := []*AcquirerProfile{
+		{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"},
+		{AcqID: "100091", ApiKey: "KEY-2", Secret: "a7Fq2-Lx9Pw-3Zk8"},
+	}
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		switch r.URL.Path {
+		case API_LIST_ACQUIRER_PATH:
+			json.NewEncoder(w).Encode(acquirers)
+		case API_LIST_ISSUER_PATH:
+			w.Write([]byte("[]"))
+		}
+	}))
+	defer server.Close()
+
+	s := NewPartnewServiceWithoutEvent(server.URL)
+	assert.Equal(t, []string{"100090", "100091"}, s.GetAcquirerStore().Keys())
+
+	acquirers = acquirers[1:]
+	assert.Nil(t, s.Reload())
+	assert.Equal(t, []string{"100091"}, s.GetAcquirerStore().Keys())
+	assert.Nil(t, s.GetAcquirerByApiKey("KEY-1"))
+
+	// A failed load must not remove anything
+	fail = true
+	assert.NotNil(t, s.Reload())
+	assert.Equal(t, []string{"100091"}, s.GetAcquirerStore().Keys())
+
+	// Nor an empty list
+	fail = false
+	acquirers = nil
+	assert.Nil(t, s.Reload())
+	assert.Equal(t, []string{"100091"}, s.GetAcquirerStore().Keys())
+}
+
+func TestPruneKeepsRecentWrites(t *testing.T) {
+	store := NewAcquirerStore()
+	upsertAcquirer(store, &AcquirerProfile{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"})
+	since := time.Now()
+	// Received from kafka while the list was fetched
+	upsertAcquirer(store, &AcquirerProfile{AcqID: "100091", ApiKey: "KEY-2", Secret: "a7Fq2-Lx9Pw-3Zk8"})
+	upsertAcquirer(store, &AcquirerProfile{AcqID: "100092", ApiKey: "KEY-3", Secret: "a7Fq2-Lx9Pw-3Zk8"})
+
+	assert.Equal(t, []string{"100090"}, pruneStore(store, map[string]bool{"100092": true}, since))
+	assert.Equal(t, []string{"100091", "100092"}, store.Keys())
+	assert.Equal(t, []string{}, pruneStore(store, map[string]bool{}, time.Now()), "Empty list")
+}
+
+func TestKillSwitch(t *testing.T) {
+	s := PartnerService{acqStore: NewAcquirerStore(), issStore: NewIssuerStore(), killSwitch: NewKillSwitch()}
+	acq := &AcquirerProfile{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"}
+	upsertAcquirer(s.acqStore, acq)
+	iss := &IssuerProfile{IssuerID: "100090", ApiKey: "KEY-2", Secret: "b4Rt8-Mn2Qs-6Yh1"}
+	upsertIssuer(s.issStore, iss)
+
+	_, err := s.SuspendPartner(PARTNER_TYPE_ACQUIRER, "999999", "typo")
+	assert.ErrorIs(t, err, ErrPartnerNotFound)
+	_, err = s.SuspendPartner("merchant", "100090", "typo")
+	assert.ErrorIs(t, err, ErrPartnerNotFound, "Unknown partner type")
+
+	assert.Equal(t, true, s.IsAcquirerActive(acq))
+	suspension, err := s.SuspendPartner(PARTNER_TYPE_ACQUIRER, "100090", "compromised")
+	assert.Nil(t, err)
+	assert.Equal(t, "compromised", suspension.Reason)
+	assert.Equal(t, false, s.IsAcquirerActive(acq))
+	assert.Equal(t, true, s.IsIssuerActive(iss), "Issuer with the same id not suspended")
+
+	// The switch survives profile updates
+	upsertAcquirer(s.acqStore, &AcquirerProfile{AcqID: "100090", ApiKey: "KEY-1", Secret: "b4Rt8-Mn2Qs-6Yh1"})
+	assert.Equal(t, false, s.IsAcquirerActive(s.GetAcquireByID("100090")))
+	assert.Equal(t, 1, len(s.GetSuspensions()))
+
+	lifted, err := s.LiftSuspension(PARTNER_TYPE_ACQUIRER, "100090")
+	assert.Nil(t, err)
+	assert.Equal(t, true, lifted)
+	lifted, _ = s.LiftSuspension(PARTNER_TYPE_ACQUIRER, "100090")
+	assert.Equal(t, false, lifted)
+	assert.Equal(t, true, s.IsAcquirerActive(s.GetAcquireByID("100090")))
+}
+
+func TestSharedKillSwitch(t *testing.T) {
+	shared := NewMemorySuspensions()
+	pod1, pod2 := NewSharedKillSwitch(shared), NewSharedKillSwitch(shared)
+
+	_, err := pod1.Suspend(context.TODO(), PARTNER_TYPE_ACQUIRER, "100090", "compromised")
+	assert.Nil(t, err)
+	assert.Equal(t, false, pod2.IsSuspended(PARTNER_TYPE_ACQUIRER, "100090"), "Not synced yet")
+	assert.Nil(t, pod2.Sync(context.TODO()))
+	assert.Equal(t, true, pod2.IsSuspended(PARTNER_TYPE_ACQUIRER, "100090"), "Suspended on every instance")
+
+	// A restarted instance loads the suspensions
+	restarted := NewSharedKillSwitch(shared)
+	assert.Nil(t, restarted.Sync(context.TODO()))
+	assert.Equal(t, "compromised", restarted.List()[0].Reason)
+
+	lifted, err := pod2.Lift(context.TODO(), PARTNER_TYPE_ACQUIRER, "100090")
+	assert.Nil(t, err)
+	assert.Equal(t, true, lifted)
+	assert.Nil(t, pod1.Sync(context.TODO()))
+	assert.Equal(t, false, pod1.IsSuspended(PARTNER_TYPE_ACQUIRER, "100090"), "Lifted on every instance")
+}
@@ -0,0 +1,94 @@
+package partners
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	PROFILE_INSERTED    = "INSERTED"
+	PROFILE_UPDATED     = "UPDATED"
+	PROFILE_KEY_CHANGED = "KEY_CHANGED"
+	PROFILE_DELETED     = "DELETED"
+	PROFILE_UNCHANGED   = "UNCHANGED"
+)
+
+var ErrMissingField = errors.New("missing mandatory field")
+
+// upsertAcquirer stores the profile under its partner id. When the API key changed the old
+// key is dropped from the index in the same write, so it stops authenticating immediately.
+// A terminated partner is removed from the store instead, the store guards validate the others.
+func upsertAcquirer(store *AcquirerStore, acq *AcquirerProfile) (string, error) {
+	if acq.AcqID != "" && normalizeStatus(acq.Status) == PARTNER_STATUS_TERMINATED {
+		return deleteAcquirer(store, acq.AcqID), nil
+	}
+	old, existed, err := store.UpsertUnique(acq, INDEX_API_KEY)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case !existed:
+		return PROFILE_INSERTED, nil
+	case old.ApiKey != acq.ApiKey:
+		fmt.Printf("Revoke previous api key of acquirer (AcqID: %s)\n", acq.AcqID)
+		return PROFILE_KEY_CHANGED, nil
+	}
+	return PROFILE_UPDATED, nil
+}
+
+func upsertIssuer(store *IssuerStore, iss *IssuerProfile) (string, error) {
+	if iss.IssuerID != "" && normalizeStatus(iss.Status) == PARTNER_STATUS_TERMINATED {
+		return deleteIssuer(store, iss.IssuerID), nil
+	}
+	old, existed, err := store.UpsertUnique(iss, INDEX_API_KEY)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case !existed:
+		return PROFILE_INSERTED, nil
+	case old.ApiKey != iss.ApiKey:
+		fmt.Printf("Revoke previous api key of issuer (IssuerID: %s)\n", iss.IssuerID)
+		return PROFILE_KEY_CHANGED, nil
+	}
+	return PROFILE_UPDATED, nil
+}
+
+func deleteAcquirer(store *AcquirerStore, acqID string) string {
+	if _, ok := store.Delete(acqID); !ok {
+		return PROFILE_UNCHANGED
+	}
+	fmt.Printf("Remove acquirer profile, api key revoked (AcqID: %s)\n", acqID)
+	return PROFILE_DELETED
+}
+
+func deleteIssuer(store *IssuerStore, issuerID string) string {
+	if _, ok := store.Delete(issuerID); !ok {
+		return PROFILE_UNCHANGED
+	}
+	fmt.Printf("Remove issuer profile, api key revoked (IssuerID: %s)\n", issuerID)
+	return PROFILE_DELETED
+}
+
+// pruneStore removes the items whose primary key is not in keep and returns the removed keys.
+// Items written after since, e.g. by kafka while the list was fetched, are kept. An empty keep
+// removes nothing, it is more likely a faulty list than every partner removed at once.
+func pruneStore[T any](store *Store[T], keep map[string]bool, since time.Time) []string {
+	removed := []string{}
+	if len(keep) == 0 {
+		return removed
+	}
+	for _, key := range store.Keys() {
+		if keep[key] {
+			continue
+		}
+		if v, ok := store.EntityVersion(key); ok && v.Applied.After(since) {
+			continue
+		}
+		if _, ok := store.Delete(key); ok {
+			removed = append(removed, key)
+		}
+	}
+	return removed
+}
@@ -0,0 +1,187 @@
+package partners
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcquirerProfileEventTransitions(t *testing.T) {
+	tests := []struct {
+		name       string
+		existing   []*AcquirerProfile
+		event      AcquirerProfileEvent
+		err        error
+		apiKeys    map[string]string // api key -> expected AcqID, "" when revoked
+		transition string
+	}{
+		{
+			name:       "new partner is inserted under its api key",
+			event:      AcquirerProfileEvent{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"},
+			apiKeys:    map[string]string{"KEY-1": "100090"},
+			transition: PROFILE_INSERTED,
+		},
+		{
+			name:       "existing partner is updated in place",
+			existing:   []*AcquirerProfile{{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8", Name: "old"}},
+			event:      AcquirerProfileEvent{AcqID: "100090", ApiKey: "KEY-1", Secret: "b4Rt8-Mn2Qs-6Yh1", Name: "new"},
+			apiKeys:    map[string]string{"KEY-1": "100090"},
+			transition: PROFILE_UPDATED,
+		},
+		{
+			name:       "api key change revokes the old key",
+			existing:   []*AcquirerProfile{{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"}},
+			event:      AcquirerProfileEvent{AcqID: "100090", ApiKey: "KEY-2", Secret: "a7Fq2-Lx9Pw-3Zk8"},
+			apiKeys:    map[string]string{"KEY-1": "", "KEY-2": "100090"},
+			transition: PROFILE_KEY_CHANGED,
+		},
+		{
+			name:     "api key owned by another partner is rejected",
+			existing: []*AcquirerProfile{{AcqID: "100091", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"}},
+			event:    AcquirerProfileEvent{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"},
+			err:      ErrIndexConflict,
+			apiKeys:  map[string]string{"KEY-1": "100091"},
+		},
+		{
+			name:     "older profile is refused",
+			existing: []*AcquirerProfile{{AcqID: "100090", ApiKey: "KEY-2", Secret: "a7Fq2-Lx9Pw-3Zk8", Modified: "2025-02-21T06:39:00Z"}},
+			event:    AcquirerProfileEvent{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8", Modified: "2025-02-20T06:39:00Z"},
+			err:      ErrStaleWrite,
+			apiKeys:  map[string]string{"KEY-1": "", "KEY-2": "100090"},
+		},
+		{
+			name:  "missing acquirer id is rejected",
+			event: AcquirerProfileEvent{ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"},
+			err:   ErrMissingField,
+		},
+		{
+			name:     "missing api key is rejected",
+			existing: []*AcquirerProfile{{AcqID: "100090", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"}},
+			event:    AcquirerProfileEvent{AcqID: "100090", Secret: "a7Fq2-Lx9Pw-3Zk8"},
+			err:      ErrMissingField,
+			apiKeys:  map[string]string{"KEY-1": "100090"},
+		},
+		{
+			name:    "missing secret is rejected",
+			event:   AcquirerProfileEvent{AcqID: "100090", ApiKey: "KEY-1"},
+			err:     ErrMissingField,
+			apiKeys: map[string]string{"KEY-1": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewAcquirerStore()
+			for _, acq := range tt.existing {
+				store.Upsert(acq)
+			}
+
+			transition, err := upsertAcquirer(store, eventToAcquirerProfile(&tt.event))
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.transition, transition)
+
+			for apiKey, acqID := range tt.apiKeys {
+				acq, ok := store.GetBy(INDEX_API_KEY, apiKey)
+				if acqID == "" {
+					assert.Equal(t, false, ok, "Api key %s must not authenticate", apiKey)
+				} else {
+					assert.Equal(t, acqID, acq.AcqID, "Owner of api key %s", apiKey)
+				}
+			}
+
+			if tt.err == nil {
+				acq, _ := store.Get(tt.event.AcqID)
+				assert.Equal(t, tt.event.Name, acq.Name, "Stored profile")
+				assert.Equal(t, tt.event.Secret, acq.Secret, "Stored secret")
+			}
+		})
+	}
+}
+
+func TestIssuerProfileEventTransitions(t *testing.T) {
+	tests := []struct {
+		name       string
+		existing   []*IssuerProfile
+		event      IssuerProfileEvent
+		err        error
+		apiKeys    map[string]string
+		transition string
+	}{
+		{
+			name:       "new partner is inserted under its api key",
+			event:      IssuerProfileEvent{IssuerID: "200099", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"},
+			apiKeys:    map[string]string{"KEY-1": "200099"},
+			transition: PROFILE_INSERTED,
+		},
+		{
+			name:       "existing partner is updated in place",
+			existing:   []*IssuerProfile{{IssuerID: "200099", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"}},
+			event:      IssuerProfileEvent{IssuerID: "200099", ApiKey: "KEY-1", Secret: "b4Rt8-Mn2Qs-6Yh1"},
+			apiKeys:    map[string]string{"KEY-1": "200099"},
+			transition: PROFILE_UPDATED,
+		},
+		{
+			name:       "api key change revokes the old key",
+			existing:   []*IssuerProfile{{IssuerID: "200099", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"}},
+			event:      IssuerProfileEvent{IssuerID: "200099", ApiKey: "KEY-2", Secret: "a7Fq2-Lx9Pw-3Zk8"},
+			apiKeys:    map[string]string{"KEY-1": "", "KEY-2": "200099"},
+			transition: PROFILE_KEY_CHANGED,
+		},
+		{
+			name:     "api key owned by another partner is rejected",
+			existing: []*IssuerProfile{{IssuerID: "200098", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"}},
+			event:    IssuerProfileEvent{IssuerID: "200099", ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"},
+			err:      ErrIndexConflict,
+			apiKeys:  map[string]string{"KEY-1": "200098"},
+		},
+		{
+			name:  "missing issuer id is rejected",
+			event: IssuerProfileEvent{ApiKey: "KEY-1", Secret: "a7Fq2-Lx9Pw-3Zk8"},
+			err:   ErrMissingField,
+		},
+		{
+			name:  "missing api key is rejected",
+			event: IssuerProfileEvent{IssuerID: "200099", Secret: "a7Fq2-Lx9Pw-3Zk8"},
+			err:   ErrMissingField,
+		},
+		{
+			name:    "missing secret is rejected",
+			event:   IssuerProfileEvent{IssuerID: "200099", ApiKey: "KEY-1"},
+			err:     ErrMissingField,
+			apiKeys: map[string]string{"KEY-1": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewIssuerStore()
+			for _, iss := range tt.existing {
+				store.Upsert(iss)
+			}
+
+			transition, err := upsertIssuer(store, eventToIssuerProfile(&tt.event))
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.transition, transition)
+
+			for apiKey, issuerID := range tt.apiKeys {
+				iss, ok := store.GetBy(INDEX_API_KEY, apiKey)
+				if issuerID == "" {
+					assert.Equal(t, false, ok, "Api key %s must not authenticate", apiKey)
+				} else {
+					assert.Equal(t, issuerID, iss.IssuerID, "Owner of api key %s", apiKey)
+				}
+			}
+		})
+	}
+}
+
+func TestEventToProfileFields(t *testing.T) {
+	acq := eventToAcquirerProfile(&AcquirerProfileEvent{AcqID: "100090", NotificationHook: "https://hook", SettlementWaived: false, SwitchingWaived: true})
+	assert.Equal(t, "https://hook", acq.NotificationHook)
+	assert.Equal(t, true, acq.SwitchingWaived)
+	assert.Equal(t, false, acq.SettlementWaived)
+
+	iss := eventToIssuerProfile(&IssuerProfileEvent{IssuerID: "200099", SettlementFeeWaived: false, SwitchingFeeWaived: true})
+	assert.Equal(t, true, iss.SwitchingWaived)
+	assert.Equal(t, false, iss.SettlementWaived)
+}
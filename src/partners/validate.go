@@ -0,0 +1,239 @@
+package partners
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/onecombine/onecombine-msg-validator/src/currency"
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+const (
+	FEE_TYPE_PERCENT  = "PCT"
+	FEE_TYPE_ABSOLUTE = "ABS"
+)
+
+const SECRET_MIN_ENTROPY_BITS string = "SECRET_MIN_ENTROPY_BITS"
+const SECRET_ENTROPY_MODE string = "SECRET_ENTROPY_MODE"
+
+// Default estimated entropy of the partner secrets, "aaaa" or "password" are below it
+const MIN_SECRET_ENTROPY_BITS = 48
+
+const (
+	ENTROPY_WARN   = "warn"
+	ENTROPY_REJECT = "reject"
+)
+
+var ErrInvalidField = errors.New("invalid field")
+
+// ValidationError reports the field of a refused profile, it wraps ErrMissingField,
+// ErrInvalidField or ErrIndexConflict.
+type ValidationError struct {
+	Field  string
+	Err    error
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("%v: %s", e.Err, e.Field)
+	}
+	return fmt.Sprintf("%v: %s, %s", e.Err, e.Field, e.Reason)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+func missingField(field string) error {
+	return &ValidationError{Field: field, Err: ErrMissingField}
+}
+
+func invalidField(field, reason string) error {
+	return &ValidationError{Field: field, Err: ErrInvalidField, Reason: reason}
+}
+
+// ErrorFields returns the fields of the validation errors joined in err.
+func ErrorFields(err error) []string {
+	var invalid *ValidationError
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case interface{ Unwrap() []error }:
+		fields := []string{}
+		for _, err := range e.Unwrap() {
+			fields = append(fields, ErrorFields(err)...)
+		}
+		return fields
+	}
+	if errors.As(err, &invalid) {
+		return []string{invalid.Field}
+	}
+	return nil
+}
+
+func validateAcquirerProfile(acq *AcquirerProfile) error {
+	switch {
+	case acq.AcqID == "":
+		return missingField("acqId")
+	case acq.ApiKey == "":
+		return missingField("apiKey")
+	case acq.Secret == "":
+		return missingField("secret")
+	}
+	return errors.Join(
+		validateFee("settlement_fee", acq.SettlementFee, "settlement_type", acq.SettlementType),
+		validateFee("switching_fee", acq.SwitchingFee, "switching_type", acq.SwitchingType),
+		validateCurrency("settlement_currency_code", acq.SettlementCurrencyCode),
+		validateWebhook("hook", acq.NotificationHook),
+	)
+}
+
+func validateIssuerProfile(iss *IssuerProfile) error {
+	switch {
+	case iss.IssuerID == "":
+		return missingField("issuer_id")
+	case iss.ApiKey == "":
+		return missingField("apiKey")
+	case iss.Secret == "":
+		return missingField("secret")
+	}
+	return errors.Join(
+		validateFee("settlement_fee", iss.SettlementFee, "settlement_type", iss.SettlementType),
+		validateFee("switching_fee", iss.SwitchingFee, "switching_type", iss.SwitchingType),
+		validateCurrency("settlement_currency_code", iss.SettlementCurrencyCode),
+		validateWebhook("refund_notification_webhook", iss.RefundNotificationWebHook),
+		validateWebhook("cancelled_notification_webhook", iss.CancelledNotificationWebHook),
+	)
+}
+
+// SecretPolicy checks the entropy of the partner secrets. Weak secrets are only logged unless
+// Reject is set, and even then a partner keeps the secret it is already stored with, so live
+// partners are not locked out before their secret is rotated.
+type SecretPolicy struct {
+	MinBits float64
+	Reject  bool
+}
+
+// NewSecretPolicyFromEnv requires SECRET_MIN_ENTROPY_BITS, 48 by default, and refuses weak
+// secrets when SECRET_ENTROPY_MODE is reject. It only warns by default.
+func NewSecretPolicyFromEnv() SecretPolicy {
+	bits, err := strconv.ParseFloat(utils.GetEnv(SECRET_MIN_ENTROPY_BITS, strconv.Itoa(MIN_SECRET_ENTROPY_BITS)), 64)
+	if err != nil || bits < 0 {
+		bits = MIN_SECRET_ENTROPY_BITS
+	}
+	return SecretPolicy{
+		MinBits: bits,
+		Reject:  strings.ToLower(utils.GetEnv(SECRET_ENTROPY_MODE, ENTROPY_WARN)) == ENTROPY_REJECT,
+	}
+}
+
+// Check validates the secret of partner id, stored tells whether the partner is already stored
+// with this secret. Weak secrets are logged when they are first seen.
+func (p SecretPolicy) Check(id, secret string, stored bool) error {
+	bits := secretEntropy(secret)
+	if bits >= p.MinBits {
+		return nil
+	}
+	reason := fmt.Sprintf("entropy %.0f bits below %.0f", bits, p.MinBits)
+	if p.Reject && !stored {
+		return invalidField("secret", reason)
+	}
+	if !stored {
+		fmt.Printf("Accept weak secret of partner (id: %s), %s\n", id, reason)
+	}
+	return nil
+}
+
+// secretEntropy estimates the entropy of a secret as its length times the Shannon entropy of its
+// characters, repeated or predictable characters lower it.
+func secretEntropy(secret string) float64 {
+	counts := make(map[rune]int)
+	total := 0
+	for _, r := range secret {
+		counts[r]++
+		total++
+	}
+	perChar := 0.0
+	for _, n := range counts {
+		p := float64(n) / float64(total)
+		perChar -= p * math.Log2(p)
+	}
+	return perChar * float64(total)
+}
+
+// validateFee accepts an empty fee, otherwise a decimal percentage between 0 and 100 for PCT
+// and a non negative decimal amount for ABS.
+func validateFee(feeField, fee, typeField, feeType string) error {
+	if fee == "" && feeType == "" {
+		return nil
+	}
+	if feeType != FEE_TYPE_PERCENT && feeType != FEE_TYPE_ABSOLUTE {
+		return invalidField(typeField, fmt.Sprintf("%q is neither %s nor %s", feeType, FEE_TYPE_PERCENT, FEE_TYPE_ABSOLUTE))
+	}
+	if fee == "" {
+		return nil
+	}
+
+	value, err := currency.ParseDecimal(fee)
+	if err != nil {
+		return invalidField(feeField, fmt.Sprintf("%q is not a decimal", fee))
+	}
+	if value.Sign() < 0 {
+		return invalidField(feeField, fmt.Sprintf("%q is negative", fee))
+	}
+	if feeType == FEE_TYPE_PERCENT && value.Cmp(big.NewRat(100, 1)) > 0 {
+		return invalidField(feeField, fmt.Sprintf("%q is above 100%%", fee))
+	}
+	return nil
+}
+
+func validateCurrency(field, code string) error {
+	if code != "" && !currency.IsValid(code) {
+		return invalidField(field, fmt.Sprintf("%q is not an ISO 4217 code", code))
+	}
+	return nil
+}
+
+func validateWebhook(field, hook string) error {
+	if hook == "" {
+		return nil
+	}
+	u, err := url.Parse(hook)
+	switch {
+	case err != nil:
+		return invalidField(field, err.Error())
+	case u.Scheme != "https":
+		return invalidField(field, fmt.Sprintf("%q is not an https url", hook))
+	case u.Host == "":
+		return invalidField(field, fmt.Sprintf("%q has no host", hook))
+	}
+	return nil
+}
+
+// linkApiKeys refuses the acquirers and issuers using an API key of the other partner type, an
+// API key authenticates a single partner. The stores share a write lock so that an acquirer and
+// an issuer written concurrently with the same key cannot both pass.
+func linkApiKeys(acqStore *AcquirerStore, issStore *IssuerStore) {
+	var lock sync.Mutex
+	acqStore.SetWriteLock(&lock)
+	issStore.SetWriteLock(&lock)
+	acqStore.AddGuard(func(acq *AcquirerProfile) error {
+		if iss, ok := issStore.GetBy(INDEX_API_KEY, acq.ApiKey); ok {
+			return &ValidationError{Field: "apiKey", Err: ErrIndexConflict, Reason: "used by issuer " + iss.IssuerID}
+		}
+		return nil
+	})
+	issStore.AddGuard(func(iss *IssuerProfile) error {
+		if acq, ok := acqStore.GetBy(INDEX_API_KEY, iss.ApiKey); ok {
+			return &ValidationError{Field: "apiKey", Err: ErrIndexConflict, Reason: "used by acquirer " + acq.AcqID}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,160 @@
+package partners
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func validAcquirer() *AcquirerProfile {
+	return &AcquirerProfile{
+		AcqID:                  "100090",
+		ApiKey:                 "KEY-1",
+		Secret:                 "a7Fq2-Lx9Pw-3Zk8",
+		NotificationHook:       "https://hook.example.com/notify",
+		SettlementFee:          "0.60",
+		SettlementType:         "PCT",
+		SwitchingFee:           "12.5",
+		SwitchingType:          "ABS",
+		SettlementCurrencyCode: "THB",
+	}
+}
+
+func TestValidateAcquirerProfile(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(acq *AcquirerProfile)
+		err    error
+		fields []string
+	}{
+		{name: "valid profile", modify: func(acq *AcquirerProfile) {}},
+		{name: "empty fees", modify: func(acq *AcquirerProfile) { acq.SettlementFee, acq.SettlementType = "", "" }},
+		{name: "missing api key", modify: func(acq *AcquirerProfile) { acq.ApiKey = "" }, err: ErrMissingField, fields: []string{"apiKey"}},
+		{name: "unknown fee type", modify: func(acq *AcquirerProfile) { acq.SettlementType = "FLAT" }, err: ErrInvalidField, fields: []string{"settlement_type"}},
+		{name: "non numeric fee", modify: func(acq *AcquirerProfile) { acq.SettlementFee = "0,60" }, err: ErrInvalidField, fields: []string{"settlement_fee"}},
+		{name: "fraction fee", modify: func(acq *AcquirerProfile) { acq.SettlementFee = "3/5" }, err: ErrInvalidField, fields: []string{"settlement_fee"}},
+		{name: "percentage above 100", modify: func(acq *AcquirerProfile) { acq.SettlementFee = "100.01" }, err: ErrInvalidField, fields: []string{"settlement_fee"}},
+		{name: "negative amount", modify: func(acq *AcquirerProfile) { acq.SwitchingFee = "-1" }, err: ErrInvalidField, fields: []string{"switching_fee"}},
+		{name: "unknown currency", modify: func(acq *AcquirerProfile) { acq.SettlementCurrencyCode = "thb" }, err: ErrInvalidField, fields: []string{"settlement_currency_code"}},
+		{name: "plain http webhook", modify: func(acq *AcquirerProfile) { acq.NotificationHook = "http://hook.example.com" }, err: ErrInvalidField, fields: []string{"hook"}},
+		{
+			name:   "every invalid field is reported",
+			modify: func(acq *AcquirerProfile) { acq.SwitchingType, acq.SettlementCurrencyCode = "", "XYZ" },
+			err:    ErrInvalidField,
+			fields: []string{"switching_type", "settlement_currency_code"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acq := validAcquirer()
+			tt.modify(acq)
+			err := validateAcquirerProfile(acq)
+			if tt.err == nil {
+				assert.Nil(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.fields, ErrorFields(err))
+		})
+	}
+}
+
+func TestValidateIssuerWebhooks(t *testing.T) {
+	iss := &IssuerProfile{IssuerID: "200099", ApiKey: "KEY-2", Secret: "b4Rt8-Mn2Qs-6Yh1", RefundNotificationWebHook: "https:///refund"}
+	err := validateIssuerProfile(iss)
+	assert.ErrorIs(t, err, ErrInvalidField)
+	assert.Equal(t, []string{"refund_notification_webhook"}, ErrorFields(err))
+}
+
+func TestSecretPolicy(t *testing.T) {
+	warn := SecretPolicy{MinBits: MIN_SECRET_ENTROPY_BITS}
+	assert.Nil(t, warn.Check("100090", "aaaaaaaaaaaaaaaaaaaa", false), "Only logged")
+
+	reject := SecretPolicy{MinBits: MIN_SECRET_ENTROPY_BITS, Reject: true}
+	err := reject.Check("100090", "aaaaaaaaaaaaaaaaaaaa", false)
+	assert.ErrorIs(t, err, ErrInvalidField)
+	assert.Equal(t, []string{"secret"}, ErrorFields(err))
+	assert.Nil(t, reject.Check("100090", "aaaaaaaaaaaaaaaaaaaa", true), "Stored secret kept")
+	assert.Nil(t, reject.Check("100090", "a7Fq2-Lx9Pw-3Zk8", false))
+
+	t.Setenv(SECRET_MIN_ENTROPY_BITS, "96")
+	t.Setenv(SECRET_ENTROPY_MODE, "REJECT")
+	assert.Equal(t, SecretPolicy{MinBits: 96, Reject: true}, NewSecretPolicyFromEnv())
+}
+
+func TestStoreWarnsOnWeakSecrets(t *testing.T) {
+	store := NewAcquirerStore()
+	acq := validAcquirer()
+	acq.Secret = "password"
+	_, err := upsertAcquirer(store, acq)
+	assert.Nil(t, err, "Weak secrets are only logged by default")
+
+	// Enforced once the live partners have rotated their secret
+	t.Setenv(SECRET_ENTROPY_MODE, ENTROPY_REJECT)
+	store = NewAcquirerStore()
+	_, err = upsertAcquirer(store, acq)
+	assert.ErrorIs(t, err, ErrInvalidField)
+}
+
+func TestStoreRejectsInvalidProfiles(t *testing.T) {
+	t.Setenv(SECRET_ENTROPY_MODE, ENTROPY_REJECT)
+	store := NewAcquirerStore()
+
+	acq := validAcquirer()
+	acq.Secret = "password"
+	_, err := upsertAcquirer(store, acq)
+	assert.ErrorIs(t, err, ErrInvalidField)
+	assert.Equal(t, 0, store.Len(), "Invalid profile not stored")
+
+	_, err = upsertAcquirer(store, &AcquirerProfile{AcqID: "100091", Secret: "a7Fq2-Lx9Pw-3Zk8"})
+	assert.ErrorIs(t, err, ErrMissingField)
+	assert.Equal(t, map[string]uint64{"secret": 1, "apiKey": 1}, store.Rejected())
+}
+
+func TestUniqueApiKeyAcrossPartnerTypes(t *testing.T) {
+	s := newPartnerService(context.Background(), NewStaticSource(nil, nil))
+
+	_, err := upsertAcquirer(s.acqStore, validAcquirer())
+	assert.Nil(t, err)
+
+	_, err = upsertIssuer(s.issStore, &IssuerProfile{IssuerID: "200099", ApiKey: "KEY-1", Secret: "b4Rt8-Mn2Qs-6Yh1"})
+	assert.ErrorIs(t, err, ErrIndexConflict, "Api key of an acquirer")
+	assert.Equal(t, true, isPermanent(err))
+	assert.Equal(t, uint64(1), s.issStore.Rejected()["apiKey"])
+
+	_, err = upsertIssuer(s.issStore, &IssuerProfile{IssuerID: "200099", ApiKey: "KEY-2", Secret: "b4Rt8-Mn2Qs-6Yh1"})
+	assert.Nil(t, err)
+	_, err = upsertAcquirer(s.acqStore, &AcquirerProfile{AcqID: "100091", ApiKey: "KEY-2", Secret: "c9Wd3-Kp7Ve-5Xj2"})
+	assert.ErrorIs(t, err, ErrIndexConflict, "Api key of an issuer")
+}
+
+func TestUniqueApiKeyConcurrentWrites(t *testing.T) {
+	s := newPartnerService(context.Background(), NewStaticSource(nil, nil))
+	// Widens the window between the api key check and the write
+	s.acqStore.AddGuard(func(*AcquirerProfile) error { time.Sleep(time.Millisecond); return nil })
+	s.issStore.AddGuard(func(*IssuerProfile) error { time.Sleep(time.Millisecond); return nil })
+
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("KEY-%d", i)
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			upsertAcquirer(s.acqStore, &AcquirerProfile{AcqID: fmt.Sprintf("1%05d", i), ApiKey: key, Secret: "a7Fq2-Lx9Pw-3Zk8"})
+		}()
+		go func() {
+			defer wg.Done()
+			upsertIssuer(s.issStore, &IssuerProfile{IssuerID: fmt.Sprintf("2%05d", i), ApiKey: key, Secret: "b4Rt8-Mn2Qs-6Yh1"})
+		}()
+		wg.Wait()
+
+		_, acq := s.acqStore.GetBy(INDEX_API_KEY, key)
+		_, iss := s.issStore.GetBy(INDEX_API_KEY, key)
+		assert.Equal(t, true, acq != iss, "Api key of a single partner: %s", key)
+	}
+}
@@ -0,0 +1,213 @@
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefix of the encrypted values: enc:v1:<wrapped data key>:<nonce and ciphertext>, both base64
+const ENCRYPTED_PREFIX = "enc:v1:"
+
+// Number of unwrapped data keys kept to decrypt without calling the key provider
+const DATA_KEY_CACHE_SIZE = 256
+
+var ErrDecrypt = errors.New("unable to decrypt secret")
+var ErrPlaintextSecret = errors.New("secret is not encrypted")
+var ErrNoKeyProvider = errors.New("no key provider to decrypt secret")
+
+// Cipher encrypts the partner secrets published on kafka. The additional data binds a secret
+// to its partner, a secret copied to another partner fails to decrypt.
+type Cipher interface {
+	Encrypt(ctx context.Context, plaintext, aad string) (string, error)
+	Decrypt(ctx context.Context, value, aad string) (string, error)
+}
+
+type dataKey struct {
+	aead    cipher.AEAD
+	wrapped string
+	created time.Time
+}
+
+type envelopeCipher struct {
+	provider KeyProvider
+	strict   bool
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	current *dataKey
+	keys    map[string]cipher.AEAD
+}
+
+// NewEnvelopeCipher encrypts with a data key generated by provider, renewed every ttl. In strict
+// mode plaintext values are rejected instead of returned as is.
+func NewEnvelopeCipher(provider KeyProvider, ttl time.Duration, strict bool) Cipher {
+	return &envelopeCipher{
+		provider: provider,
+		strict:   strict,
+		ttl:      ttl,
+		keys:     make(map[string]cipher.AEAD),
+	}
+}
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, ENCRYPTED_PREFIX)
+}
+
+// Encrypt implements Cipher.
+func (c *envelopeCipher) Encrypt(ctx context.Context, plaintext, aad string) (string, error) {
+	if plaintext == "" || IsEncrypted(plaintext) {
+		return plaintext, nil
+	}
+
+	key, err := c.dataKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(key.aead, []byte(plaintext), []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return ENCRYPTED_PREFIX + key.wrapped + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt implements Cipher.
+func (c *envelopeCipher) Decrypt(ctx context.Context, value, aad string) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+	if !IsEncrypted(value) {
+		if c.strict {
+			return "", ErrPlaintextSecret
+		}
+		return value, nil
+	}
+
+	wrapped, sealed, found := strings.Cut(strings.TrimPrefix(value, ENCRYPTED_PREFIX), ":")
+	if !found {
+		return "", fmt.Errorf("%w: malformed value", ErrDecrypt)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrDecrypt, err)
+	}
+
+	aead, err := c.unwrap(ctx, wrapped)
+	if err != nil {
+		return "", err
+	}
+	plain, err := open(aead, ciphertext, []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func (c *envelopeCipher) dataKey(ctx context.Context) (*dataKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.current != nil && (c.ttl <= 0 || time.Since(c.current.created) < c.ttl) {
+		return c.current, nil
+	}
+
+	plain, wrapped, err := c.provider.GenerateDataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(plain)
+	if err != nil {
+		return nil, err
+	}
+	c.current = &dataKey{
+		aead:    aead,
+		wrapped: base64.StdEncoding.EncodeToString(wrapped),
+		created: time.Now(),
+	}
+	return c.current, nil
+}
+
+func (c *envelopeCipher) unwrap(ctx context.Context, wrapped string) (cipher.AEAD, error) {
+	c.mu.Lock()
+	aead, found := c.keys[wrapped]
+	c.mu.Unlock()
+	if found {
+		return aead, nil
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecrypt, err)
+	}
+	// Provider errors, e.g. KMS unavailable, are not wrapped so the event can be retried
+	plain, err := c.provider.UnwrapDataKey(ctx, blob)
+	if err != nil {
+		return nil, err
+	}
+	aead, err = newGCM(plain)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.keys) >= DATA_KEY_CACHE_SIZE {
+		c.keys = make(map[string]cipher.AEAD)
+	}
+	c.keys[wrapped] = aead
+	return aead, nil
+}
+
+// Encrypt encrypts plaintext with c, a nil cipher leaves it in plaintext.
+func Encrypt(ctx context.Context, c Cipher, plaintext, aad string) (string, error) {
+	if c == nil {
+		return plaintext, nil
+	}
+	return c.Encrypt(ctx, plaintext, aad)
+}
+
+// Decrypt decrypts value with c. Without cipher only plaintext values are accepted.
+func Decrypt(ctx context.Context, c Cipher, value, aad string) (string, error) {
+	if c == nil {
+		if IsEncrypted(value) {
+			return "", ErrNoKeyProvider
+		}
+		return value, nil
+	}
+	return c.Decrypt(ctx, value, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKey, err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: ciphertext too short", ErrDecrypt)
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecrypt, err)
+	}
+	return plain, nil
+}
@@ -0,0 +1,104 @@
+package secrets
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingProvider struct {
+	KeyProvider
+	generated int
+	unwrapped int
+}
+
+func (p *countingProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	p.generated++
+	return p.KeyProvider.GenerateDataKey(ctx)
+}
+
+func (p *countingProvider) UnwrapDataKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	p.unwrapped++
+	return p.KeyProvider.UnwrapDataKey(ctx, wrapped)
+}
+
+func newTestProvider(t *testing.T) *countingProvider {
+	key := make([]byte, DATA_KEY_SIZE)
+	rand.Read(key)
+	path := filepath.Join(t.TempDir(), "kek")
+	os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600)
+
+	provider, err := NewLocalKeyProvider(path)
+	assert.Nil(t, err)
+	return &countingProvider{KeyProvider: provider}
+}
+
+func TestEnvelopeCipher(t *testing.T) {
+	ctx := context.Background()
+	provider := newTestProvider(t)
+	publisher := NewEnvelopeCipher(provider, time.Hour, false)
+
+	sealed, err := publisher.Encrypt(ctx, "6dc010a7952422a", "200099")
+	assert.Nil(t, err)
+	assert.Equal(t, true, IsEncrypted(sealed))
+	assert.NotContains(t, sealed, "6dc010a7952422a")
+
+	other, _ := publisher.Encrypt(ctx, "aaaa", "100090")
+	assert.Equal(t, 1, provider.generated, "Data key reused")
+	resealed, _ := publisher.Encrypt(ctx, sealed, "200099")
+	assert.Equal(t, sealed, resealed, "Encrypted value left as is")
+
+	// A consumer of another process unwraps the data key once
+	consumer := NewEnvelopeCipher(provider, time.Hour, false)
+	plain, err := consumer.Decrypt(ctx, sealed, "200099")
+	assert.Nil(t, err)
+	assert.Equal(t, "6dc010a7952422a", plain)
+	plain, _ = consumer.Decrypt(ctx, other, "100090")
+	assert.Equal(t, "aaaa", plain)
+	assert.Equal(t, 1, provider.unwrapped)
+
+	_, err = consumer.Decrypt(ctx, sealed, "100090")
+	assert.ErrorIs(t, err, ErrDecrypt, "Secret bound to its partner")
+	_, err = consumer.Decrypt(ctx, strings.TrimSuffix(sealed, sealed[len(sealed)-4:])+"AAA=", "200099")
+	assert.ErrorIs(t, err, ErrDecrypt, "Tampered ciphertext")
+	_, err = consumer.Decrypt(ctx, ENCRYPTED_PREFIX+"garbage", "200099")
+	assert.ErrorIs(t, err, ErrDecrypt)
+
+	plain, err = consumer.Decrypt(ctx, "legacy", "200099")
+	assert.Nil(t, err)
+	assert.Equal(t, "legacy", plain, "Plaintext accepted outside strict mode")
+}
+
+func TestStrictMode(t *testing.T) {
+	ctx := context.Background()
+	strict := NewEnvelopeCipher(newTestProvider(t), 0, true)
+
+	_, err := strict.Decrypt(ctx, "legacy", "200099")
+	assert.ErrorIs(t, err, ErrPlaintextSecret)
+
+	sealed, _ := strict.Encrypt(ctx, "secret", "200099")
+	plain, err := strict.Decrypt(ctx, sealed, "200099")
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", plain)
+}
+
+func TestWithoutCipher(t *testing.T) {
+	ctx := context.Background()
+	value, err := Encrypt(ctx, nil, "secret", "200099")
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", value)
+
+	sealed, _ := NewEnvelopeCipher(newTestProvider(t), 0, false).Encrypt(ctx, "secret", "200099")
+	_, err = Decrypt(ctx, nil, sealed, "200099")
+	assert.ErrorIs(t, err, ErrNoKeyProvider)
+
+	_, err = NewStaticKeyProvider([]byte("short"))
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
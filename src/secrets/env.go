@@ -0,0 +1,66 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+const SECRET_KEY_PROVIDER string = "SECRET_KEY_PROVIDER"
+const SECRET_KEY_FILE string = "SECRET_KEY_FILE"
+const SECRET_KMS_KEY_ID string = "SECRET_KMS_KEY_ID"
+const SECRET_DATA_KEY_TTL string = "SECRET_DATA_KEY_TTL"
+const SECRET_STRICT_MODE string = "SECRET_STRICT_MODE"
+
+const (
+	PROVIDER_LOCAL = "local"
+	PROVIDER_KMS   = "kms"
+)
+
+var (
+	envOnce   sync.Once
+	envCipher Cipher
+	envErr    error
+)
+
+// FromEnv returns the cipher configured by SECRET_KEY_PROVIDER, shared by the publishers and
+// consumers of the process. It is nil when no provider is configured.
+func FromEnv() (Cipher, error) {
+	envOnce.Do(func() {
+		envCipher, envErr = newCipherFromEnv()
+	})
+	return envCipher, envErr
+}
+
+func newCipherFromEnv() (Cipher, error) {
+	strict := strings.EqualFold(utils.GetEnv(SECRET_STRICT_MODE, "false"), "true")
+	ttl, err := time.ParseDuration(utils.GetEnv(SECRET_DATA_KEY_TTL, "1h"))
+	if err != nil {
+		ttl = time.Hour
+	}
+
+	var provider KeyProvider
+	switch name := strings.ToLower(utils.GetEnv(SECRET_KEY_PROVIDER, "")); name {
+	case "":
+		if strict {
+			return nil, fmt.Errorf("%w: %s requires %s", ErrInvalidKey, SECRET_STRICT_MODE, SECRET_KEY_PROVIDER)
+		}
+		return nil, nil
+	case PROVIDER_LOCAL:
+		provider, err = NewLocalKeyProvider(utils.GetEnv(SECRET_KEY_FILE, ""))
+	case PROVIDER_KMS:
+		provider, err = NewKmsKeyProvider(context.Background(), utils.GetEnv(utils.AWS_REGION, "ap-southeast-1"), utils.GetEnv(SECRET_KMS_KEY_ID, ""))
+	default:
+		err = fmt.Errorf("%w: unknown provider %s", ErrInvalidKey, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Encrypt partner secrets with %s key provider (strict: %t)\n", utils.GetEnv(SECRET_KEY_PROVIDER, ""), strict)
+	return NewEnvelopeCipher(provider, ttl, strict), nil
+}
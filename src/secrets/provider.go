@@ -0,0 +1,120 @@
+package secrets
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+const DATA_KEY_SIZE = 32
+
+var ErrInvalidKey = errors.New("invalid key encryption key")
+
+// KeyProvider generates the data keys encrypting the secrets and wraps them with a key
+// encryption key it never discloses.
+type KeyProvider interface {
+	// GenerateDataKey returns a new data key, in plaintext and wrapped
+	GenerateDataKey(ctx context.Context) (plain []byte, wrapped []byte, err error)
+	// UnwrapDataKey returns the plaintext of a data key returned by GenerateDataKey
+	UnwrapDataKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+type localProvider struct {
+	kek cipher.AEAD
+}
+
+// NewLocalKeyProvider wraps the data keys with the 32 bytes key of a local file, raw or base64
+// encoded. It is meant for development and tests.
+func NewLocalKeyProvider(path string) (KeyProvider, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewStaticKeyProvider(raw)
+}
+
+// NewStaticKeyProvider wraps the data keys with key, raw or base64 encoded.
+func NewStaticKeyProvider(key []byte) (KeyProvider, error) {
+	if len(key) != DATA_KEY_SIZE {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(key)))
+		if err != nil || len(decoded) != DATA_KEY_SIZE {
+			return nil, fmt.Errorf("%w: expect %d bytes", ErrInvalidKey, DATA_KEY_SIZE)
+		}
+		key = decoded
+	}
+	kek, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &localProvider{kek: kek}, nil
+}
+
+// GenerateDataKey implements KeyProvider.
+func (p *localProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	plain := make([]byte, DATA_KEY_SIZE)
+	if _, err := rand.Read(plain); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := seal(p.kek, plain, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plain, wrapped, nil
+}
+
+// UnwrapDataKey implements KeyProvider.
+func (p *localProvider) UnwrapDataKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	return open(p.kek, wrapped, nil)
+}
+
+type kmsProvider struct {
+	client *kms.Client
+	keyId  string
+}
+
+// NewKmsKeyProvider generates and unwraps the data keys with the AWS KMS key keyId, using the
+// default AWS configuration.
+func NewKmsKeyProvider(ctx context.Context, region, keyId string) (KeyProvider, error) {
+	if keyId == "" {
+		return nil, fmt.Errorf("%w: missing kms key id", ErrInvalidKey)
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+	return &kmsProvider{client: kms.NewFromConfig(cfg), keyId: keyId}, nil
+}
+
+// GenerateDataKey implements KeyProvider.
+func (p *kmsProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	out, err := p.client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:   aws.String(p.keyId),
+		KeySpec: types.DataKeySpecAes256,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return out.Plaintext, out.CiphertextBlob, nil
+}
+
+// UnwrapDataKey implements KeyProvider.
+func (p *kmsProvider) UnwrapDataKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	out, err := p.client.Decrypt(ctx, &kms.DecryptInput{
+		CiphertextBlob: wrapped,
+		KeyId:          aws.String(p.keyId),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
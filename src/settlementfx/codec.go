@@ -0,0 +1,69 @@
+package settlementfx
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/onecombine/onecombine-msg-validator/src/messages"
+	"github.com/onecombine/onecombine-msg-validator/src/partners"
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+func checkFXVersion(version string) error {
+	// Events published before the version field was filled carry none
+	if version == "" || version == "v1" || strings.HasPrefix(version, "v1.") {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", partners.ErrUnsupportedVersion, version)
+}
+
+// decodeFXEvent decodes a JSON or protobuf settlement fx message, enveloped or bare. The event
+// is nil for DELETED events and tombstones, the pair is then the message key.
+func decodeFXEvent(msg kafka.Message) (*SettlementFxEvent, *utils.EventEnvelope, error) {
+	// A message without value is the tombstone of a deleted pair
+	if len(msg.Value) == 0 {
+		return nil, nil, nil
+	}
+
+	env, payload, err := utils.OpenEnvelope(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if env != nil {
+		if err := checkFXVersion(env.SchemaVersion); err != nil {
+			return nil, env, err
+		}
+		if env.EventType == utils.EVENT_TYPE_DELETED {
+			return nil, env, nil
+		}
+	}
+
+	var event SettlementFxEvent
+	switch contentType := utils.ContentTypeOf(msg); contentType {
+	case utils.CONTENT_TYPE_JSON:
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, env, err
+		}
+	case utils.CONTENT_TYPE_PROTOBUF:
+		var rate pb.SettlementFX
+		if err := proto.Unmarshal(payload, &rate); err != nil {
+			return nil, env, err
+		}
+		if err := checkFXVersion(rate.Version); err != nil {
+			return nil, env, err
+		}
+		event = SettlementFxEvent{
+			Pair:     rate.Pair,
+			Value:    rate.Value,
+			Created:  rate.Created,
+			Modified: rate.Modified,
+		}
+	default:
+		return nil, env, fmt.Errorf("%w: %s", partners.ErrUnsupportedContentType, contentType)
+	}
+	return &event, env, nil
+}
@@ -0,0 +1,78 @@
+package settlementfx
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/onecombine/onecombine-msg-validator/src/messages"
+	"github.com/onecombine/onecombine-msg-validator/src/partners"
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+func TestDecodeFXEvent(t *testing.T) {
+	t.Setenv(utils.EVENT_ENVELOPE_ENABLED, "true")
+	ctx := context.Background()
+	fx := &SettlementFX{Pair: "USDTHB", Value: "35.50", Created: "2025-02-20T00:00:00Z", Modified: "2025-02-21T00:00:00Z"}
+	expected := &SettlementFxEvent{Pair: fx.Pair, Value: fx.Value, Created: fx.Created, Modified: fx.Modified}
+
+	pbMsg, err := settlementFXMessage(ctx, fx)
+	assert.Nil(t, err)
+	assert.Equal(t, "USDTHB", string(pbMsg.Key))
+	event, env, err := decodeFXEvent(pbMsg)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, event, "Protobuf round trip")
+	assert.Equal(t, PAYLOAD_TYPE_SETTLEMENT_FX_PROTO, env.PayloadType)
+	assert.Equal(t, utils.EVENT_TYPE_UPDATED, env.EventType)
+
+	raw, _ := json.Marshal(fx)
+	jsonMsg, _ := utils.NewEventEnvelope(ctx, utils.EVENT_TYPE_UPDATED, PAYLOAD_TYPE_SETTLEMENT_FX, FX_SCHEMA_VERSION).Message([]byte(fx.Pair), utils.CONTENT_TYPE_JSON, raw)
+	event, _, err = decodeFXEvent(jsonMsg)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, event, "Enveloped JSON")
+
+	event, _, err = decodeFXEvent(kafka.Message{Value: raw})
+	assert.Nil(t, err)
+	assert.Equal(t, expected, event, "Bare JSON")
+
+	deleted, _ := utils.NewEventEnvelope(ctx, utils.EVENT_TYPE_DELETED, PAYLOAD_TYPE_SETTLEMENT_FX_PROTO, FX_SCHEMA_VERSION).Message([]byte(fx.Pair), utils.CONTENT_TYPE_PROTOBUF, nil)
+	event, _, err = decodeFXEvent(deleted)
+	assert.Nil(t, err)
+	assert.Nil(t, event, "Deleted pair")
+
+	event, _, err = decodeFXEvent(kafka.Message{Key: []byte(fx.Pair)})
+	assert.Nil(t, err)
+	assert.Nil(t, event, "Tombstone")
+
+	v2, _ := proto.Marshal(&pb.SettlementFX{Version: "v2.0", Pair: "USDTHB", Value: "35.50"})
+	_, _, err = decodeFXEvent(kafka.Message{Value: v2, Headers: []kafka.Header{utils.ContentTypeHeader(utils.CONTENT_TYPE_PROTOBUF)}})
+	assert.ErrorIs(t, err, partners.ErrUnsupportedVersion)
+}
+
+func TestHandleFXEvent(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newGuardedStore(t)
+	consumer := &fxConsumer{store: store}
+
+	msg, _ := settlementFXMessage(ctx, &SettlementFX{Pair: "USDTHB", Value: "35.00"})
+	assert.Nil(t, consumer.handle(ctx, msg))
+	fx, ok := store.Get("USDTHB")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "35.00", fx.Value)
+
+	msg, _ = settlementFXMessage(ctx, &SettlementFX{Pair: "USDTHB", Value: "3.50"})
+	assert.Nil(t, consumer.handle(ctx, msg), "Quarantined, not retried")
+	fx, _ = store.Get("USDTHB")
+	assert.Equal(t, "35.00", fx.Value)
+
+	msg, _ = settlementFXMessage(ctx, &SettlementFX{Pair: "USDTHB", Value: "abc"})
+	assert.ErrorIs(t, consumer.handle(ctx, msg), partners.ErrInvalidField)
+
+	assert.Nil(t, consumer.handle(ctx, kafka.Message{Key: []byte("USDTHB")}), "Tombstone")
+	_, ok = store.Get("USDTHB")
+	assert.Equal(t, false, ok, "Removed")
+}
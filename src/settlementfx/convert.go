@@ -0,0 +1,256 @@
+package settlementfx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/onecombine/onecombine-msg-validator/src/currency"
+	"github.com/onecombine/onecombine-msg-validator/src/partners"
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+const FX_BASE_CURRENCY string = "FX_BASE_CURRENCY"
+const FX_ROUNDING_MODE string = "FX_ROUNDING_MODE"
+const FX_STALE_AFTER string = "FX_STALE_AFTER"
+const FX_STALE_ACTION string = "FX_STALE_ACTION"
+
+const (
+	STALE_WARN = "warn"
+	STALE_FAIL = "fail"
+)
+
+// Index of the rates by normalized pair, e.g. "USDTHB" for "USD/THB"
+const INDEX_FX_PAIR = "pair"
+
+var ErrInvalidPair = errors.New("invalid currency pair")
+var ErrInvalidRate = errors.New("invalid fx rate")
+var ErrRateNotFound = errors.New("no fx rate for currency pair")
+var ErrNoHistory = errors.New("fx rate history is disabled")
+var ErrStaleRate = errors.New("fx rate is stale")
+
+// ParsePair splits a pair like "USDTHB", "USD/THB", "USD_THB" or "USD-THB" into its base and
+// quote currencies. The rate of the pair is the amount of quote currency for one base unit.
+func ParsePair(pair string) (string, string, error) {
+	normalized := strings.ToUpper(strings.NewReplacer("/", "", "_", "", "-", "", " ", "").Replace(pair))
+	if len(normalized) != 6 {
+		return "", "", fmt.Errorf("%w: %q", ErrInvalidPair, pair)
+	}
+	base, quote := normalized[:3], normalized[3:]
+	if !currency.IsValid(base) || !currency.IsValid(quote) {
+		return "", "", fmt.Errorf("%w: %q", ErrInvalidPair, pair)
+	}
+	return base, quote, nil
+}
+
+func pairIndex(fx *SettlementFX) string {
+	base, quote, err := ParsePair(fx.Pair)
+	if err != nil {
+		return ""
+	}
+	return base + quote
+}
+
+// rateLookup returns the rate of a normalized pair, ok is false when the pair has no rate.
+type rateLookup func(pair string) (fx *SettlementFX, ok bool, err error)
+
+// Converter converts amounts with the rates of a store, or of the history at a given time. A
+// missing pair is derived from its inverse, or through the base currency when both currencies
+// have a rate against it.
+type Converter struct {
+	store      *FXStore
+	history    RateHistory
+	base       string
+	rounding   currency.RoundingMode
+	staleAfter time.Duration
+	failStale  bool
+}
+
+func NewConverter(store *FXStore, base string, rounding currency.RoundingMode) *Converter {
+	return &Converter{store: store, base: strings.ToUpper(base), rounding: rounding}
+}
+
+// SetHistory enables RateAt and ConvertAt.
+func (c *Converter) SetHistory(history RateHistory) {
+	c.history = history
+}
+
+// SetStaleness flags the rates not updated for after, Rate fails with ErrStaleRate when fail is
+// set and only warns otherwise. Zero disables the check.
+func (c *Converter) SetStaleness(after time.Duration, fail bool) {
+	c.staleAfter = after
+	c.failStale = fail
+}
+
+// NewConverterFromEnv triangulates through FX_BASE_CURRENCY, USD by default, and rounds as
+// FX_ROUNDING_MODE, half up by default. Rates not updated for FX_STALE_AFTER are refused when
+// FX_STALE_ACTION is fail, they are used with a warning otherwise.
+func NewConverterFromEnv(store *FXStore) *Converter {
+	base := strings.ToUpper(utils.GetEnv(FX_BASE_CURRENCY, "USD"))
+	if !currency.IsValid(base) {
+		fmt.Printf("Triangulate fx rates through USD, %q is not a currency\n", base)
+		base = "USD"
+	}
+	rounding, err := currency.ParseRoundingMode(utils.GetEnv(FX_ROUNDING_MODE, string(currency.ROUND_HALF_UP)))
+	if err != nil {
+		fmt.Printf("Round fx conversions half up, error: %v\n", err)
+		rounding = currency.ROUND_HALF_UP
+	}
+	converter := NewConverter(store, base, rounding)
+	staleAfter, _ := time.ParseDuration(utils.GetEnv(FX_STALE_AFTER, ""))
+	converter.SetStaleness(staleAfter, strings.ToLower(utils.GetEnv(FX_STALE_ACTION, STALE_WARN)) == STALE_FAIL)
+	return converter
+}
+
+// Rate returns the exact amount of to for one unit of from.
+func (c *Converter) Rate(from, to string) (*big.Rat, error) {
+	return c.rate(from, to, func(pair string) (*SettlementFX, bool, error) {
+		fx, ok := c.store.GetBy(INDEX_FX_PAIR, pair)
+		if !ok {
+			return nil, false, nil
+		}
+		if age, stale := c.age(fx); stale {
+			if c.failStale {
+				return nil, false, fmt.Errorf("%w: %s not updated for %s", ErrStaleRate, fx.Pair, age.Truncate(time.Second))
+			}
+			fmt.Printf("Convert with stale settlement fx (pair: %s), not updated for %s\n", fx.Pair, age.Truncate(time.Second))
+		}
+		return fx, true, nil
+	})
+}
+
+// age returns the time since the rate was last modified upstream or received, whichever is later.
+func (c *Converter) age(fx *SettlementFX) (time.Duration, bool) {
+	if c.staleAfter <= 0 {
+		return 0, false
+	}
+	updated := partners.ParseModified(fx.Modified)
+	if v, ok := c.store.EntityVersion(fx.Pair); ok && v.Applied.After(updated) {
+		updated = v.Applied
+	}
+	age := time.Since(updated)
+	return age, age > c.staleAfter
+}
+
+// StaleRates returns the pairs not updated within the staleness threshold.
+func (c *Converter) StaleRates() []string {
+	stale := []string{}
+	for _, fx := range c.store.Snapshot() {
+		if _, ok := c.age(fx); ok {
+			stale = append(stale, fx.Pair)
+		}
+	}
+	return stale
+}
+
+// RateAt returns the rate in effect at t, see Rate. Every pair of a triangulation is taken at t.
+func (c *Converter) RateAt(ctx context.Context, from, to string, t time.Time) (*big.Rat, error) {
+	if c.history == nil {
+		return nil, ErrNoHistory
+	}
+	return c.rate(from, to, func(pair string) (*SettlementFX, bool, error) {
+		point, ok, err := c.history.At(ctx, pair, t)
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		return &SettlementFX{Pair: point.Pair, Value: point.Value}, true, nil
+	})
+}
+
+func (c *Converter) rate(from, to string, lookup rateLookup) (*big.Rat, error) {
+	src, err := currency.Parse(from)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := currency.Parse(to)
+	if err != nil {
+		return nil, err
+	}
+	if src.Code == dst.Code {
+		return big.NewRat(1, 1), nil
+	}
+
+	rate, err := direct(src.Code, dst.Code, lookup)
+	if !errors.Is(err, ErrRateNotFound) || src.Code == c.base || dst.Code == c.base || c.base == "" {
+		return rate, err
+	}
+
+	toBase, err := direct(src.Code, c.base, lookup)
+	if err != nil {
+		return nil, c.notFound(src.Code, dst.Code, err)
+	}
+	fromBase, err := direct(c.base, dst.Code, lookup)
+	if err != nil {
+		return nil, c.notFound(src.Code, dst.Code, err)
+	}
+	return toBase.Mul(toBase, fromBase), nil
+}
+
+func (c *Converter) notFound(from, to string, err error) error {
+	if errors.Is(err, ErrRateNotFound) {
+		return fmt.Errorf("%w: %s%s, nor through %s", ErrRateNotFound, from, to, c.base)
+	}
+	return err
+}
+
+// direct returns the rate of the pair or the inverse of the reversed pair.
+func direct(from, to string, lookup rateLookup) (*big.Rat, error) {
+	fx, ok, err := lookup(from + to)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return parseRate(fx)
+	}
+	fx, ok, err = lookup(to + from)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		rate, err := parseRate(fx)
+		if err != nil {
+			return nil, err
+		}
+		return rate.Inv(rate), nil
+	}
+	return nil, fmt.Errorf("%w: %s%s", ErrRateNotFound, from, to)
+}
+
+func parseRate(fx *SettlementFX) (*big.Rat, error) {
+	rate, err := currency.ParseDecimal(fx.Value)
+	if err != nil || rate.Sign() <= 0 {
+		return nil, fmt.Errorf("%w: %s = %q", ErrInvalidRate, fx.Pair, fx.Value)
+	}
+	return rate, nil
+}
+
+// Convert converts amount of from into to, rounded to the minor units of to with the rounding
+// mode of the converter.
+func (c *Converter) Convert(amount *big.Rat, from, to string) (*big.Rat, error) {
+	return c.ConvertWithRounding(amount, from, to, c.rounding)
+}
+
+func (c *Converter) ConvertWithRounding(amount *big.Rat, from, to string, mode currency.RoundingMode) (*big.Rat, error) {
+	rate, err := c.Rate(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return round(amount, rate, to, mode), nil
+}
+
+// ConvertAt converts at the rate in effect at t, e.g. the time of the original payment of a refund.
+func (c *Converter) ConvertAt(ctx context.Context, amount *big.Rat, from, to string, t time.Time) (*big.Rat, error) {
+	rate, err := c.RateAt(ctx, from, to, t)
+	if err != nil {
+		return nil, err
+	}
+	return round(amount, rate, to, c.rounding), nil
+}
+
+func round(amount, rate *big.Rat, to string, mode currency.RoundingMode) *big.Rat {
+	dst, _ := currency.Parse(to)
+	return dst.Round(rate.Mul(rate, amount), mode)
+}
@@ -0,0 +1,90 @@
+package settlementfx
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/onecombine/onecombine-msg-validator/src/currency"
+)
+
+func rat(value string) *big.Rat {
+	r, _ := currency.ParseDecimal(value)
+	return r
+}
+
+func newTestConverter() *Converter {
+	store := NewFXStore()
+	store.Upsert(&SettlementFX{Pair: "USDTHB", Value: "35.50"})
+	store.Upsert(&SettlementFX{Pair: "USD/JPY", Value: "150"})
+	store.Upsert(&SettlementFX{Pair: "EUR_USD", Value: "1.08"})
+	store.Upsert(&SettlementFX{Pair: "SGDMYR", Value: "0"})
+	return NewConverter(store, "USD", currency.ROUND_HALF_UP)
+}
+
+func TestParsePair(t *testing.T) {
+	for _, pair := range []string{"USDTHB", "USD/THB", "usd_thb", "USD-THB"} {
+		base, quote, err := ParsePair(pair)
+		assert.Nil(t, err, pair)
+		assert.Equal(t, []string{"USD", "THB"}, []string{base, quote}, pair)
+	}
+	for _, pair := range []string{"", "USD", "USDTHBX", "XYZTHB"} {
+		_, _, err := ParsePair(pair)
+		assert.ErrorIs(t, err, ErrInvalidPair, pair)
+	}
+}
+
+func TestRate(t *testing.T) {
+	c := newTestConverter()
+
+	rate, err := c.Rate("USD", "THB")
+	assert.Nil(t, err)
+	assert.Equal(t, rat("35.50"), rate, "Direct pair")
+
+	rate, err = c.Rate("thb", "usd")
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewRat(2, 71), rate, "Inverse pair, exactly")
+
+	rate, err = c.Rate("EUR", "THB")
+	assert.Nil(t, err)
+	assert.Equal(t, rat("38.34"), rate, "Through the base currency")
+
+	rate, err = c.Rate("THB", "JPY")
+	assert.Nil(t, err)
+	assert.Equal(t, new(big.Rat).Quo(rat("150"), rat("35.50")), rate, "Inverse then direct through the base currency")
+
+	rate, _ = c.Rate("THB", "THB")
+	assert.Equal(t, big.NewRat(1, 1), rate)
+
+	_, err = c.Rate("USD", "GBP")
+	assert.ErrorIs(t, err, ErrRateNotFound)
+	_, err = c.Rate("THB", "GBP")
+	assert.ErrorIs(t, err, ErrRateNotFound)
+	_, err = c.Rate("SGD", "MYR")
+	assert.ErrorIs(t, err, ErrInvalidRate)
+	_, err = c.Rate("XYZ", "THB")
+	assert.ErrorIs(t, err, currency.ErrUnknownCurrency)
+}
+
+func TestConvert(t *testing.T) {
+	c := newTestConverter()
+
+	converted, err := c.Convert(rat("10.00"), "USD", "THB")
+	assert.Nil(t, err)
+	assert.Equal(t, "355.00", converted.FloatString(2))
+
+	converted, _ = c.Convert(rat("100"), "THB", "USD")
+	assert.Equal(t, "2.82", converted.FloatString(2), "2.8169 USD")
+
+	converted, _ = c.Convert(rat("100"), "THB", "JPY")
+	assert.Equal(t, "423", converted.FloatString(0), "422.535 JPY")
+
+	converted, _ = c.ConvertWithRounding(rat("100"), "THB", "JPY", currency.ROUND_UP)
+	assert.Equal(t, "423", converted.FloatString(0))
+	converted, _ = c.ConvertWithRounding(rat("100"), "THB", "JPY", currency.ROUND_DOWN)
+	assert.Equal(t, "422", converted.FloatString(0))
+
+	_, err = c.Convert(rat("1"), "USD", "GBP")
+	assert.ErrorIs(t, err, ErrRateNotFound)
+}
@@ -0,0 +1,465 @@
+package settlementfx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/onecombine/onecombine-msg-validator/src/currency"
+	"github.com/onecombine/onecombine-msg-validator/src/partners"
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+const FX_MAX_CHANGE_PCT string = "FX_MAX_CHANGE_PCT"
+const FX_GUARD_RULES string = "FX_GUARD_RULES"
+
+// Rule of the pairs without a rule of their own in FX_GUARD_RULES
+const GUARD_DEFAULT_PAIR = "*"
+
+// Approvals are kept long enough for every instance to apply them
+const APPROVAL_TTL = 24 * time.Hour
+
+// Bounds each write of the quarantine to the shared store
+const QUARANTINE_SHARE_TIMEOUT = time.Second
+
+// Quarantine updates waiting for the shared store, they are dropped when it is full
+const QUARANTINE_SHARE_QUEUE = 1024
+
+// ErrQuarantined is returned for the suspicious rates held for a manual approval, they are not
+// applied until then.
+var ErrQuarantined = errors.New("fx rate quarantined")
+var ErrNotQuarantined = errors.New("no quarantined fx rate for pair")
+var ErrQuarantineUnavailable = errors.New("fx quarantine backend unavailable")
+
+// GuardRule bounds the updates of a pair, empty values are not checked. MaxChangePct is the
+// largest change versus the current rate, in percent.
+type GuardRule struct {
+	MaxChangePct string `json:"max_change_pct"`
+	Min          string `json:"min"`
+	Max          string `json:"max"`
+}
+
+type guardLimits struct {
+	maxChange *big.Rat
+	min       *big.Rat
+	max       *big.Rat
+}
+
+// QuarantinedRate is a suspicious update waiting for an approval, Previous is the rate it would
+// have replaced.
+type QuarantinedRate struct {
+	Rate     SettlementFX `json:"rate"`
+	Previous string       `json:"previous"`
+	Reason   string       `json:"reason"`
+	Received time.Time    `json:"received"`
+	Approved time.Time    `json:"approved,omitzero"`
+}
+
+// Guard quarantines the rates breaking the rule of their pair, the last suspicious update of a
+// pair replaces the previous one. Without a shared store the quarantine and the approvals only
+// apply to this instance, with one Sync lists the rates quarantined by the other instances and
+// applies their approvals.
+type Guard struct {
+	rules  map[string]guardLimits
+	shared QuarantineStore
+
+	mu          sync.Mutex
+	quarantined map[string]QuarantinedRate
+	approved    map[string]QuarantinedRate
+	applied     map[string]SettlementFX
+	pending     chan func(ctx context.Context) error
+}
+
+// NewGuard keys rules by normalized pair, GUARD_DEFAULT_PAIR applies to the other pairs.
+func NewGuard(rules map[string]GuardRule) (*Guard, error) {
+	return NewSharedGuard(rules, nil)
+}
+
+func NewSharedGuard(rules map[string]GuardRule, shared QuarantineStore) (*Guard, error) {
+	g := &Guard{
+		rules:       make(map[string]guardLimits),
+		shared:      shared,
+		quarantined: make(map[string]QuarantinedRate),
+		approved:    make(map[string]QuarantinedRate),
+		applied:     make(map[string]SettlementFX),
+	}
+	if shared != nil {
+		g.pending = make(chan func(ctx context.Context) error, QUARANTINE_SHARE_QUEUE)
+	}
+	for pair, rule := range rules {
+		key := pair
+		if pair != GUARD_DEFAULT_PAIR {
+			base, quote, err := ParsePair(pair)
+			if err != nil {
+				return nil, err
+			}
+			key = base + quote
+		}
+
+		var limits guardLimits
+		var err error
+		for _, bound := range []struct {
+			name  string
+			value string
+			dest  **big.Rat
+		}{{"max_change_pct", rule.MaxChangePct, &limits.maxChange}, {"min", rule.Min, &limits.min}, {"max", rule.Max, &limits.max}} {
+			if bound.value == "" {
+				continue
+			}
+			if *bound.dest, err = currency.ParseDecimal(bound.value); err != nil {
+				return nil, fmt.Errorf("%s of fx guard %s: %w", bound.name, pair, err)
+			}
+		}
+		g.rules[key] = limits
+	}
+	return g, nil
+}
+
+// NewGuardFromEnv reads the rules of FX_GUARD_RULES, a JSON object of GuardRule by pair. The
+// pairs without rule may change by FX_MAX_CHANGE_PCT percent when it is set, they are only
+// checked for invalid values otherwise. The quarantine is shared in redis when
+// FX_QUARANTINE_BACKEND is redis, the default when URL_REDIS_HOST is set.
+func NewGuardFromEnv() *Guard {
+	var shared QuarantineStore
+	backend := QUARANTINE_MEMORY
+	if os.Getenv(utils.REDIS_HOST) != "" {
+		backend = QUARANTINE_REDIS
+	}
+	if strings.ToLower(utils.GetEnv(FX_QUARANTINE_BACKEND, backend)) == QUARANTINE_REDIS {
+		shared = NewRedisQuarantine(utils.NewCache())
+	} else {
+		fmt.Printf("Quarantined settlement fx apply to this instance only, no shared backend\n")
+	}
+
+	rules := map[string]GuardRule{}
+	if raw := utils.GetEnv(FX_GUARD_RULES, ""); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+			fmt.Printf("Ignore fx guard rules, error: %v\n", err)
+			rules = map[string]GuardRule{}
+		}
+	}
+	if _, ok := rules[GUARD_DEFAULT_PAIR]; !ok {
+		if maxChange := utils.GetEnv(FX_MAX_CHANGE_PCT, ""); maxChange != "" {
+			rules[GUARD_DEFAULT_PAIR] = GuardRule{MaxChangePct: maxChange}
+		}
+	}
+
+	guard, err := NewSharedGuard(rules, shared)
+	if err != nil {
+		fmt.Printf("Ignore fx guard rules, error: %v\n", err)
+		guard, _ = NewSharedGuard(map[string]GuardRule{}, shared)
+	}
+	return guard
+}
+
+func (g *Guard) limits(pair string) guardLimits {
+	if limits, ok := g.rules[pair]; ok {
+		return limits
+	}
+	return g.rules[GUARD_DEFAULT_PAIR]
+}
+
+// Check validates next against the current rate of its pair, nil when there is none. Invalid
+// rates are refused, the suspicious ones are quarantined unless they were approved less than
+// APPROVAL_TTL ago. It runs under the fx store lock, the shared store is written later by Run.
+func (g *Guard) Check(current, next *SettlementFX) error {
+	value, err := parseRate(next)
+	if err != nil {
+		return &partners.ValidationError{Field: "value", Err: partners.ErrInvalidField, Reason: err.Error()}
+	}
+
+	pair := pairIndex(next)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if approved, ok := g.approved[next.Pair]; ok && time.Since(approved.Approved) > APPROVAL_TTL {
+		delete(g.approved, next.Pair)
+	} else if ok && approved.Rate == *next {
+		g.applied[next.Pair] = *next
+		return nil
+	}
+
+	reason := g.suspicious(pair, current, value)
+	if reason == "" {
+		// A sane rate supersedes the quarantined one
+		if _, ok := g.quarantined[next.Pair]; ok {
+			delete(g.quarantined, next.Pair)
+			g.share(func(ctx context.Context) error {
+				_, err := g.shared.Release(ctx, next.Pair)
+				return err
+			})
+		}
+		return nil
+	}
+	q := QuarantinedRate{Rate: *next, Reason: reason, Received: time.Now()}
+	if current != nil {
+		q.Previous = current.Value
+	}
+	g.quarantined[next.Pair] = q
+	g.share(func(ctx context.Context) error { return g.shared.Quarantine(ctx, q) })
+	fmt.Printf("Quarantine settlement fx (pair: %s, value: %s), %s\n", next.Pair, next.Value, reason)
+	return &partners.ValidationError{Field: "value", Err: ErrQuarantined, Reason: reason}
+}
+
+// share queues a write to the shared store, Check must not wait for it.
+func (g *Guard) share(write func(ctx context.Context) error) {
+	if g.shared == nil {
+		return
+	}
+	select {
+	case g.pending <- write:
+	default:
+		fmt.Printf("Drop fx quarantine update, the shared store is lagging behind\n")
+	}
+}
+
+func (g *Guard) write(ctx context.Context, write func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(ctx, QUARANTINE_SHARE_TIMEOUT)
+	defer cancel()
+	if err := write(ctx); err != nil {
+		fmt.Printf("Unable to share the fx quarantine, error: %v\n", err)
+	}
+}
+
+// flush writes the queued updates to the shared store.
+func (g *Guard) flush(ctx context.Context) {
+	for {
+		select {
+		case write := <-g.pending:
+			g.write(ctx, write)
+		default:
+			return
+		}
+	}
+}
+
+// Run writes the quarantine updates to the shared store and syncs with it every period until
+// ctx is cancelled, the queued updates are then flushed.
+func (g *Guard) Run(ctx context.Context, wg *sync.WaitGroup, store *FXStore, period time.Duration) {
+	if g.shared == nil {
+		return
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				g.flush(context.WithoutCancel(ctx))
+				return
+			case write := <-g.pending:
+				g.write(ctx, write)
+			case <-ticker.C:
+				if err := g.Sync(ctx, store); err != nil && ctx.Err() == nil {
+					fmt.Printf("Unable to sync the fx quarantine, error: %v\n", err)
+				}
+			}
+		}
+	}()
+}
+
+func (g *Guard) suspicious(pair string, current *SettlementFX, value *big.Rat) string {
+	limits := g.limits(pair)
+	switch {
+	case limits.min != nil && value.Cmp(limits.min) < 0:
+		return fmt.Sprintf("below the minimum %s", limits.min.FloatString(6))
+	case limits.max != nil && value.Cmp(limits.max) > 0:
+		return fmt.Sprintf("above the maximum %s", limits.max.FloatString(6))
+	}
+	if limits.maxChange == nil || current == nil {
+		return ""
+	}
+	previous, err := parseRate(current)
+	if err != nil {
+		return ""
+	}
+
+	// |value - previous| / previous * 100
+	change := new(big.Rat).Sub(value, previous)
+	change.Abs(change).Quo(change, previous).Mul(change, big.NewRat(100, 1))
+	if change.Cmp(limits.maxChange) > 0 {
+		return fmt.Sprintf("changed by %s%% from %s, above %s%%", change.FloatString(2), current.Value, limits.maxChange.FloatString(2))
+	}
+	return ""
+}
+
+// Quarantined returns the rates waiting for an approval ordered by pair.
+func (g *Guard) Quarantined() []QuarantinedRate {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	rates := make([]QuarantinedRate, 0, len(g.quarantined))
+	for _, q := range g.quarantined {
+		rates = append(rates, q)
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i].Rate.Pair < rates[j].Rate.Pair })
+	return rates
+}
+
+// Approve applies the quarantined rate of pair to store, and to the stores of the other instances
+// when the quarantine is shared. The approval is dropped when the store refuses the rate, e.g.
+// when a newer rate was received meanwhile.
+func (g *Guard) Approve(ctx context.Context, store *FXStore, pair string) (*SettlementFX, error) {
+	g.mu.Lock()
+	q, ok := g.findQuarantined(pair)
+	g.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotQuarantined, pair)
+	}
+
+	q.Approved = time.Now()
+	if g.shared != nil {
+		// The quarantine of the rate is shared first, it must not be listed again after approval
+		g.flush(ctx)
+		if err := g.shared.Approve(ctx, q); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrQuarantineUnavailable, err)
+		}
+	}
+	g.mu.Lock()
+	delete(g.quarantined, q.Rate.Pair)
+	g.approved[q.Rate.Pair] = q
+	g.mu.Unlock()
+
+	fx := q.Rate
+	if _, _, err := store.UpsertLatest(&fx); err != nil {
+		g.forget(ctx, fx.Pair)
+		return nil, err
+	}
+	fmt.Printf("Approve quarantined settlement fx (pair: %s, value: %s)\n", fx.Pair, fx.Value)
+	return &fx, nil
+}
+
+// Reject drops the quarantined rate of pair.
+func (g *Guard) Reject(ctx context.Context, pair string) error {
+	g.mu.Lock()
+	q, ok := g.findQuarantined(pair)
+	g.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotQuarantined, pair)
+	}
+
+	if g.shared != nil {
+		g.flush(ctx)
+		if _, err := g.shared.Release(ctx, q.Rate.Pair); err != nil {
+			return fmt.Errorf("%w: %v", ErrQuarantineUnavailable, err)
+		}
+	}
+	g.mu.Lock()
+	delete(g.quarantined, q.Rate.Pair)
+	g.mu.Unlock()
+	fmt.Printf("Reject quarantined settlement fx (pair: %s, value: %s)\n", q.Rate.Pair, q.Rate.Value)
+	return nil
+}
+
+func (g *Guard) forget(ctx context.Context, pair string) {
+	g.mu.Lock()
+	delete(g.approved, pair)
+	g.mu.Unlock()
+	if g.shared == nil {
+		return
+	}
+	if err := g.shared.Forget(ctx, pair); err != nil {
+		fmt.Printf("Unable to drop the fx approval (pair: %s), error: %v\n", pair, err)
+	}
+}
+
+// Sync writes the queued updates to the shared store, replaces the quarantined rates of this
+// instance with the shared ones and applies to store the approvals it has not applied yet. They
+// are kept as they are when the shared store is unreachable.
+func (g *Guard) Sync(ctx context.Context, store *FXStore) error {
+	if g.shared == nil {
+		return nil
+	}
+	g.flush(ctx)
+	since := time.Now()
+	quarantined, approved, err := g.shared.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	var expired []string
+	var pending []SettlementFX
+	g.mu.Lock()
+	local := g.quarantined
+	g.quarantined = make(map[string]QuarantinedRate, len(quarantined))
+	for _, q := range quarantined {
+		g.quarantined[q.Rate.Pair] = q
+	}
+	// Quarantined while loading, their update is still queued
+	for pair, q := range local {
+		if q.Received.After(since) {
+			g.quarantined[pair] = q
+		}
+	}
+	g.approved = make(map[string]QuarantinedRate, len(approved))
+	for _, q := range approved {
+		if time.Since(q.Approved) > APPROVAL_TTL {
+			expired = append(expired, q.Rate.Pair)
+			continue
+		}
+		g.approved[q.Rate.Pair] = q
+		if applied, ok := g.applied[q.Rate.Pair]; !ok || applied != q.Rate {
+			pending = append(pending, q.Rate)
+		}
+	}
+	g.mu.Unlock()
+
+	for _, pair := range expired {
+		if err := g.shared.Forget(ctx, pair); err != nil {
+			fmt.Printf("Unable to drop the expired fx approval (pair: %s), error: %v\n", pair, err)
+		}
+	}
+	for i := range pending {
+		fx := pending[i]
+		if _, _, err := store.UpsertLatest(&fx); err != nil {
+			// Superseded by a newer rate, it is not retried
+			g.mu.Lock()
+			g.applied[fx.Pair] = fx
+			g.mu.Unlock()
+			fmt.Printf("Skip approved settlement fx (pair: %s, value: %s), error: %v\n", fx.Pair, fx.Value, err)
+			continue
+		}
+		fmt.Printf("Apply settlement fx approved on another instance (pair: %s, value: %s)\n", fx.Pair, fx.Value)
+	}
+	return nil
+}
+
+func (g *Guard) Shared() bool {
+	return g.shared != nil
+}
+
+// findQuarantined accepts the pair as stored or in any notation of ParsePair.
+func (g *Guard) findQuarantined(pair string) (QuarantinedRate, bool) {
+	if q, ok := g.quarantined[pair]; ok {
+		return q, true
+	}
+	base, quote, err := ParsePair(pair)
+	if err != nil {
+		return QuarantinedRate{}, false
+	}
+	for _, q := range g.quarantined {
+		if strings.EqualFold(pairIndex(&q.Rate), base+quote) {
+			return q, true
+		}
+	}
+	return QuarantinedRate{}, false
+}
+
+// guardStore checks every rate written to store with guard, against the rate it replaces.
+func guardStore(store *FXStore, guard *Guard) {
+	store.AddWriteCheck(func(current *SettlementFX, exists bool, next *SettlementFX) error {
+		if !exists {
+			current = nil
+		}
+		return guard.Check(current, next)
+	})
+}
@@ -0,0 +1,226 @@
+package settlementfx
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/onecombine/onecombine-msg-validator/src/currency"
+	"github.com/onecombine/onecombine-msg-validator/src/partners"
+)
+
+func newGuardedStore(t *testing.T) (*FXStore, *Guard) {
+	guard, err := NewGuard(map[string]GuardRule{
+		GUARD_DEFAULT_PAIR: {MaxChangePct: "10"},
+		"USD/THB":          {MaxChangePct: "5", Min: "20", Max: "50"},
+	})
+	assert.Nil(t, err)
+	store := NewFXStore()
+	guardStore(store, guard)
+	return store, guard
+}
+
+func TestGuardQuarantinesSuspiciousRates(t *testing.T) {
+	store, guard := newGuardedStore(t)
+
+	_, _, err := store.UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "35.00"})
+	assert.Nil(t, err, "First rate within bounds")
+	_, _, err = store.UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "36.50"})
+	assert.Nil(t, err, "4.3% change")
+
+	_, _, err = store.UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "3.65"})
+	assert.ErrorIs(t, err, ErrQuarantined, "Fat finger")
+	fx, _ := store.Get("USDTHB")
+	assert.Equal(t, "36.50", fx.Value, "Current rate kept")
+
+	_, _, err = store.UpsertLatest(&SettlementFX{Pair: "EURUSD", Value: "60"})
+	assert.Nil(t, err, "No previous rate and no bounds")
+	_, _, err = store.UpsertLatest(&SettlementFX{Pair: "EURUSD", Value: "67"})
+	assert.ErrorIs(t, err, ErrQuarantined, "11.7% change above the default rule")
+
+	_, _, err = store.UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "-1"})
+	assert.ErrorIs(t, err, partners.ErrInvalidField, "Invalid rates are refused")
+
+	quarantined := guard.Quarantined()
+	assert.Equal(t, 2, len(quarantined))
+	assert.Equal(t, "EURUSD", quarantined[0].Rate.Pair)
+	assert.Equal(t, "3.65", quarantined[1].Rate.Value)
+	assert.Equal(t, "36.50", quarantined[1].Previous)
+	assert.Equal(t, uint64(3), store.Rejected()["value"])
+}
+
+func TestGuardBounds(t *testing.T) {
+	store, _ := newGuardedStore(t)
+
+	_, _, err := store.UpsertLatest(&SettlementFX{Pair: "USD_THB", Value: "51"})
+	assert.ErrorIs(t, err, ErrQuarantined, "Above the maximum of the pair")
+	_, _, err = store.UpsertLatest(&SettlementFX{Pair: "USD_THB", Value: "19.99"})
+	assert.ErrorIs(t, err, ErrQuarantined, "Below the minimum of the pair")
+}
+
+func TestApproveQuarantinedRate(t *testing.T) {
+	store, guard := newGuardedStore(t)
+	store.UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "35.00", Modified: "2025-02-20T00:00:00Z"})
+	store.UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "40.00", Modified: "2025-02-21T00:00:00Z"})
+
+	_, err := guard.Approve(context.Background(), store, "EURUSD")
+	assert.ErrorIs(t, err, ErrNotQuarantined)
+
+	fx, err := guard.Approve(context.Background(), store, "usd/thb")
+	assert.Nil(t, err)
+	assert.Equal(t, "40.00", fx.Value)
+	current, _ := store.Get("USDTHB")
+	assert.Equal(t, "40.00", current.Value, "Approved rate applied")
+	assert.Equal(t, 0, len(guard.Quarantined()))
+
+	store.UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "30.00", Modified: "2025-02-22T00:00:00Z"})
+	assert.Nil(t, guard.Reject(context.Background(), "USDTHB"))
+	assert.ErrorIs(t, guard.Reject(context.Background(), "USDTHB"), ErrNotQuarantined)
+	current, _ = store.Get("USDTHB")
+	assert.Equal(t, "40.00", current.Value, "Rejected rate dropped")
+
+	// A sane rate received meanwhile supersedes the quarantined one
+	store.UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "30.00", Modified: "2025-02-22T00:00:00Z"})
+	store.UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "41.00", Modified: "2025-02-23T00:00:00Z"})
+	assert.Equal(t, 0, len(guard.Quarantined()))
+}
+
+func TestSharedQuarantine(t *testing.T) {
+	ctx := context.Background()
+	shared := NewMemoryQuarantine()
+	rules := map[string]GuardRule{GUARD_DEFAULT_PAIR: {MaxChangePct: "10"}}
+	guards := make([]*Guard, 2)
+	stores := make([]*FXStore, 2)
+	for i := range guards {
+		guards[i], _ = NewSharedGuard(rules, shared)
+		stores[i] = NewFXStore()
+		guardStore(stores[i], guards[i])
+		stores[i].UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "35.00", Modified: "2025-02-20T00:00:00Z"})
+	}
+
+	// Only the first instance received the suspicious rate yet
+	_, _, err := stores[0].UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "40.00", Modified: "2025-02-21T00:00:00Z"})
+	assert.ErrorIs(t, err, ErrQuarantined)
+	assert.Nil(t, guards[1].Sync(ctx, stores[1]))
+	assert.Equal(t, 0, len(guards[1].Quarantined()), "Not shared under the store lock")
+	assert.Nil(t, guards[0].Sync(ctx, stores[0]))
+	assert.Equal(t, 1, len(guards[0].Quarantined()), "Kept while shared")
+	assert.Nil(t, guards[1].Sync(ctx, stores[1]))
+	assert.Equal(t, 1, len(guards[1].Quarantined()), "Listed by every instance")
+
+	fx, err := guards[1].Approve(ctx, stores[1], "USDTHB")
+	assert.Nil(t, err)
+	assert.Equal(t, "40.00", fx.Value)
+	assert.Nil(t, guards[0].Sync(ctx, stores[0]))
+	current, _ := stores[0].Get("USDTHB")
+	assert.Equal(t, "40.00", current.Value, "Approval applied by the other instance")
+	assert.Equal(t, 0, len(guards[0].Quarantined()))
+
+	// A newer rate is not overwritten by an approval applied again
+	stores[0].UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "41.00", Modified: "2025-02-22T00:00:00Z"})
+	assert.Nil(t, guards[0].Sync(ctx, stores[0]))
+	current, _ = stores[0].Get("USDTHB")
+	assert.Equal(t, "41.00", current.Value)
+
+	stores[1].UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "50.00", Modified: "2025-02-23T00:00:00Z"})
+	assert.Nil(t, guards[1].Sync(ctx, stores[1]))
+	assert.Nil(t, guards[0].Sync(ctx, stores[0]))
+	assert.Nil(t, guards[0].Reject(ctx, "USDTHB"))
+	assert.Nil(t, guards[1].Sync(ctx, stores[1]))
+	assert.Equal(t, 0, len(guards[1].Quarantined()), "Rejected on every instance")
+}
+
+func TestApprovalExpires(t *testing.T) {
+	store, guard := newGuardedStore(t)
+	store.UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "35.00", Modified: "2025-02-20T00:00:00Z"})
+	store.UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "40.00", Modified: "2025-02-21T00:00:00Z"})
+	_, err := guard.Approve(context.Background(), store, "USDTHB")
+	assert.Nil(t, err)
+
+	current := SettlementFX{Pair: "USDTHB", Value: "35.00", Modified: "2025-02-20T00:00:00Z"}
+	next := SettlementFX{Pair: "USDTHB", Value: "40.00", Modified: "2025-02-21T00:00:00Z"}
+	assert.Nil(t, guard.Check(&current, &next), "Approved")
+
+	guard.mu.Lock()
+	q := guard.approved["USDTHB"]
+	q.Approved = time.Now().Add(-APPROVAL_TTL - time.Minute)
+	guard.approved["USDTHB"] = q
+	guard.mu.Unlock()
+	assert.ErrorIs(t, guard.Check(&current, &next), ErrQuarantined, "Approval expired")
+}
+
+type blockingQuarantine struct {
+	QuarantineStore
+	release chan struct{}
+}
+
+func (b *blockingQuarantine) Quarantine(ctx context.Context, q QuarantinedRate) error {
+	<-b.release
+	return b.QuarantineStore.Quarantine(ctx, q)
+}
+
+func TestQuarantineSharedOutsideStoreLock(t *testing.T) {
+	shared := &blockingQuarantine{QuarantineStore: NewMemoryQuarantine(), release: make(chan struct{})}
+	guard, _ := NewSharedGuard(map[string]GuardRule{GUARD_DEFAULT_PAIR: {MaxChangePct: "10"}}, shared)
+	store := NewFXStore()
+	guardStore(store, guard)
+	store.UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "35.00", Modified: "2025-02-20T00:00:00Z"})
+
+	_, _, err := store.UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "40.00", Modified: "2025-02-21T00:00:00Z"})
+	assert.ErrorIs(t, err, ErrQuarantined, "Not waiting for the shared store")
+	current, _ := store.Get("USDTHB")
+	assert.Equal(t, "35.00", current.Value)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	guard.Run(ctx, wg, store, time.Hour)
+	close(shared.release)
+	assert.Eventually(t, func() bool {
+		quarantined, _, _ := shared.Load(context.Background())
+		return len(quarantined) == 1
+	}, time.Second, 10*time.Millisecond, "Shared by Run")
+	cancel()
+	wg.Wait()
+}
+
+func TestGuardFromEnvDefaultRule(t *testing.T) {
+	t.Setenv(FX_QUARANTINE_BACKEND, QUARANTINE_MEMORY)
+	t.Setenv(FX_GUARD_RULES, "")
+	t.Setenv(FX_MAX_CHANGE_PCT, "")
+	store := NewFXStore()
+	guardStore(store, NewGuardFromEnv())
+	store.UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "35.00"})
+	_, _, err := store.UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "70.00"})
+	assert.Nil(t, err, "No change limit unless configured")
+
+	t.Setenv(FX_MAX_CHANGE_PCT, "20")
+	store = NewFXStore()
+	guardStore(store, NewGuardFromEnv())
+	store.UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "35.00"})
+	_, _, err = store.UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "70.00"})
+	assert.ErrorIs(t, err, ErrQuarantined)
+}
+
+func TestStaleRates(t *testing.T) {
+	store := NewFXStore()
+	store.Upsert(&SettlementFX{Pair: "USDTHB", Value: "35.00"})
+	c := NewConverter(store, "USD", currency.ROUND_HALF_UP)
+
+	c.SetStaleness(time.Hour, true)
+	_, err := c.Rate("USD", "THB")
+	assert.Nil(t, err, "Just received")
+	assert.Equal(t, []string{}, c.StaleRates())
+
+	c.SetStaleness(time.Nanosecond, false)
+	time.Sleep(time.Millisecond)
+	_, err = c.Rate("USD", "THB")
+	assert.Nil(t, err, "Warn only")
+	assert.Equal(t, []string{"USDTHB"}, c.StaleRates())
+
+	c.SetStaleness(time.Nanosecond, true)
+	_, err = c.Convert(rat("1"), "THB", "USD")
+	assert.ErrorIs(t, err, ErrStaleRate)
+}
@@ -0,0 +1,198 @@
+package settlementfx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/onecombine/onecombine-msg-validator/src/partners"
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+const FX_HISTORY_BACKEND string = "FX_HISTORY_BACKEND"
+const FX_HISTORY_SIZE string = "FX_HISTORY_SIZE"
+
+const (
+	HISTORY_MEMORY = "memory"
+	HISTORY_REDIS  = "redis"
+)
+
+// RatePoint is the rate of a pair from Effective until the next point of the pair.
+type RatePoint struct {
+	Pair      string    `json:"pair"`
+	Value     string    `json:"value"`
+	Effective time.Time `json:"effective"`
+}
+
+// RateHistory keeps the last rates of every pair, pairs are normalized like INDEX_FX_PAIR.
+type RateHistory interface {
+	// Record adds a point, it replaces the point of the pair with the same effective time.
+	Record(ctx context.Context, point RatePoint) error
+	// At returns the point in effect at t, false when t precedes the kept history.
+	At(ctx context.Context, pair string, t time.Time) (RatePoint, bool, error)
+	// Points returns the kept points of a pair ordered by effective time.
+	Points(ctx context.Context, pair string) ([]RatePoint, error)
+}
+
+type memoryHistory struct {
+	size   int
+	mu     sync.RWMutex
+	points map[string][]RatePoint
+}
+
+// NewMemoryHistory keeps size points per pair, the oldest ones are dropped.
+func NewMemoryHistory(size int) RateHistory {
+	return &memoryHistory{size: size, points: make(map[string][]RatePoint)}
+}
+
+func (m *memoryHistory) Record(ctx context.Context, point RatePoint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	points := m.points[point.Pair]
+	i := sort.Search(len(points), func(i int) bool { return !points[i].Effective.Before(point.Effective) })
+	switch {
+	case i < len(points) && points[i].Effective.Equal(point.Effective):
+		points[i] = point
+	default:
+		points = append(points, RatePoint{})
+		copy(points[i+1:], points[i:])
+		points[i] = point
+	}
+	if m.size > 0 && len(points) > m.size {
+		points = points[len(points)-m.size:]
+	}
+	m.points[point.Pair] = points
+	return nil
+}
+
+func (m *memoryHistory) At(ctx context.Context, pair string, t time.Time) (RatePoint, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	points := m.points[pair]
+	i := sort.Search(len(points), func(i int) bool { return points[i].Effective.After(t) })
+	if i == 0 {
+		return RatePoint{}, false, nil
+	}
+	return points[i-1], true, nil
+}
+
+func (m *memoryHistory) Points(ctx context.Context, pair string) ([]RatePoint, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]RatePoint{}, m.points[pair]...), nil
+}
+
+type redisHistory struct {
+	client *redis.Client
+	size   int
+}
+
+// NewRedisHistory keeps the points in a sorted set per pair scored by effective time in
+// milliseconds, so every instance answers from the same history.
+func NewRedisHistory(cache *utils.Cache, size int) RateHistory {
+	return &redisHistory{client: cache.Client, size: size}
+}
+
+func (r *redisHistory) key(pair string) string {
+	return fmt.Sprintf("FX-HISTORY-%s", pair)
+}
+
+func (r *redisHistory) Record(ctx context.Context, point RatePoint) error {
+	member, err := json.Marshal(point)
+	if err != nil {
+		return err
+	}
+	key := r.key(point.Pair)
+	score := strconv.FormatInt(point.Effective.UnixMilli(), 10)
+
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, key, score, score)
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(point.Effective.UnixMilli()), Member: string(member)})
+		if r.size > 0 {
+			pipe.ZRemRangeByRank(ctx, key, 0, int64(-r.size-1))
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *redisHistory) At(ctx context.Context, pair string, t time.Time) (RatePoint, bool, error) {
+	members, err := r.client.ZRevRangeByScore(ctx, r.key(pair), &redis.ZRangeBy{
+		Max:   strconv.FormatInt(t.UnixMilli(), 10),
+		Min:   "-inf",
+		Count: 1,
+	}).Result()
+	if err != nil || len(members) == 0 {
+		return RatePoint{}, false, err
+	}
+	var point RatePoint
+	if err := json.Unmarshal([]byte(members[0]), &point); err != nil {
+		return RatePoint{}, false, err
+	}
+	return point, true, nil
+}
+
+func (r *redisHistory) Points(ctx context.Context, pair string) ([]RatePoint, error) {
+	members, err := r.client.ZRange(ctx, r.key(pair), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	points := make([]RatePoint, 0, len(members))
+	for _, member := range members {
+		var point RatePoint
+		if err := json.Unmarshal([]byte(member), &point); err != nil {
+			return nil, err
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
+
+// NewHistoryFromEnv keeps FX_HISTORY_SIZE points per pair, 100 by default, in memory or in the
+// redis of the cache when FX_HISTORY_BACKEND is redis.
+func NewHistoryFromEnv() RateHistory {
+	size, err := strconv.Atoi(utils.GetEnv(FX_HISTORY_SIZE, "100"))
+	if err != nil || size <= 0 {
+		size = 100
+	}
+	if strings.ToLower(utils.GetEnv(FX_HISTORY_BACKEND, HISTORY_MEMORY)) == HISTORY_REDIS {
+		return NewRedisHistory(utils.NewCache(), size)
+	}
+	return NewMemoryHistory(size)
+}
+
+// recordHistory records the created and updated rates of store in history before their write
+// returns, so no rate is missing from the history however slow its backend. A rate is effective
+// from its modification time, or from the time it was applied when it has none.
+func recordHistory(ctx context.Context, store *FXStore, history RateHistory) {
+	store.AddWriteHook(func(change partners.Change[*SettlementFX]) {
+		if change.Type == partners.CHANGE_DELETED {
+			return
+		}
+		pair := pairIndex(change.New)
+		if pair == "" {
+			fmt.Printf("Skip fx history of invalid pair (pair: %s)\n", change.New.Pair)
+			return
+		}
+		effective := partners.ParseModified(change.New.Modified)
+		if effective.IsZero() {
+			effective = time.Now()
+			if v, ok := store.EntityVersion(change.Key); ok {
+				effective = v.Applied
+			}
+		}
+		point := RatePoint{Pair: pair, Value: change.New.Value, Effective: effective.UTC()}
+		if err := history.Record(ctx, point); err != nil {
+			fmt.Printf("Error record fx history (pair: %s), error: %v\n", pair, err)
+		}
+	})
+}
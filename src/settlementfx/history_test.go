@@ -0,0 +1,79 @@
+package settlementfx
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/onecombine/onecombine-msg-validator/src/currency"
+)
+
+func at(value string) time.Time {
+	t, _ := time.Parse(time.RFC3339, value)
+	return t
+}
+
+func TestMemoryHistory(t *testing.T) {
+	ctx := context.Background()
+	history := NewMemoryHistory(3)
+
+	history.Record(ctx, RatePoint{Pair: "USDTHB", Value: "36.00", Effective: at("2025-02-21T00:00:00Z")})
+	history.Record(ctx, RatePoint{Pair: "USDTHB", Value: "35.00", Effective: at("2025-02-19T00:00:00Z")})
+	history.Record(ctx, RatePoint{Pair: "USDTHB", Value: "35.50", Effective: at("2025-02-20T00:00:00Z")})
+
+	point, ok, err := history.At(ctx, "USDTHB", at("2025-02-20T12:00:00Z"))
+	assert.Nil(t, err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "35.50", point.Value, "Recorded out of order")
+
+	point, _, _ = history.At(ctx, "USDTHB", at("2025-02-21T00:00:00Z"))
+	assert.Equal(t, "36.00", point.Value, "Effective from its time")
+
+	_, ok, _ = history.At(ctx, "USDTHB", at("2025-02-18T00:00:00Z"))
+	assert.Equal(t, false, ok, "Before the history")
+
+	history.Record(ctx, RatePoint{Pair: "USDTHB", Value: "36.10", Effective: at("2025-02-21T00:00:00Z")})
+	history.Record(ctx, RatePoint{Pair: "USDTHB", Value: "36.20", Effective: at("2025-02-22T00:00:00Z")})
+	points, _ := history.Points(ctx, "USDTHB")
+	values := []string{}
+	for _, p := range points {
+		values = append(values, p.Value)
+	}
+	assert.Equal(t, []string{"35.50", "36.10", "36.20"}, values, "Same time replaced, oldest dropped")
+}
+
+func TestRateAt(t *testing.T) {
+	ctx := context.Background()
+	store := NewFXStore()
+	history := NewMemoryHistory(10)
+	recordHistory(ctx, store, history)
+
+	c := NewConverter(store, "USD", currency.ROUND_HALF_UP)
+	_, err := c.RateAt(ctx, "USD", "THB", time.Now())
+	assert.ErrorIs(t, err, ErrNoHistory)
+	c.SetHistory(history)
+
+	store.UpsertLatest(&SettlementFX{Pair: "USD/THB", Value: "35.00", Modified: "2025-02-20T00:00:00Z"})
+	store.UpsertLatest(&SettlementFX{Pair: "USD/THB", Value: "36.00", Modified: "2025-02-21T00:00:00Z"})
+	store.UpsertLatest(&SettlementFX{Pair: "EURUSD", Value: "1.10", Modified: "2025-02-20T00:00:00Z"})
+	points, _ := history.Points(ctx, "EURUSD")
+	assert.Equal(t, 1, len(points), "Recorded before the write returns")
+
+	rate, err := c.Rate("USD", "THB")
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewRat(36, 1), rate, "Latest rate")
+
+	rate, err = c.RateAt(ctx, "THB", "USD", at("2025-02-20T18:00:00Z"))
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewRat(1, 35), rate, "Inverse of the rate then in effect")
+
+	converted, err := c.ConvertAt(ctx, big.NewRat(100, 1), "EUR", "THB", at("2025-02-21T06:00:00Z"))
+	assert.Nil(t, err)
+	assert.Equal(t, "3960.00", converted.FloatString(2), "Triangulated at the same time")
+
+	_, err = c.RateAt(ctx, "USD", "THB", at("2025-02-19T00:00:00Z"))
+	assert.ErrorIs(t, err, ErrRateNotFound)
+}
@@ -0,0 +1,61 @@
+package settlementfx
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/onecombine/onecombine-msg-validator/src/messages"
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+type pbEventPublisher struct {
+	p *kafka.Writer
+}
+
+// PublishSettlementFXChangedEvent implements SettlementFXPublisher.
+func (p *pbEventPublisher) PublishSettlementFXChangedEvent(ctx context.Context, fx *SettlementFX) error {
+	msg, err := settlementFXMessage(ctx, fx)
+	if err != nil {
+		return err
+	}
+
+	if err = p.p.WriteMessages(ctx, msg); err != nil {
+		fmt.Printf("Unable to publish settlement fx change event, error: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+func settlementFXMessage(ctx context.Context, fx *SettlementFX) (kafka.Message, error) {
+	rate := &pb.SettlementFX{
+		Version:  FX_SCHEMA_VERSION,
+		Pair:     fx.Pair,
+		Value:    fx.Value,
+		Created:  fx.Created,
+		Modified: fx.Modified,
+	}
+
+	val, err := proto.Marshal(rate)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+
+	env := utils.NewEventEnvelope(ctx, fxEventType(fx), PAYLOAD_TYPE_SETTLEMENT_FX_PROTO, FX_SCHEMA_VERSION)
+	return env.Message([]byte(fx.Pair), utils.CONTENT_TYPE_PROTOBUF, val)
+}
+
+// NewPBEventPublisher publishes the rate changes as protobuf SettlementFX messages, see
+// proto/partner.proto.
+func NewPBEventPublisher(cfg *EventPublisherConfig) SettlementFXPublisher {
+	writer, err := newFXWriter(cfg)
+	if err != nil {
+		return nil
+	}
+	return &pbEventPublisher{
+		p: writer,
+	}
+}
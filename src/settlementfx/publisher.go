@@ -23,10 +23,30 @@ type EventPublisherConfig struct {
 	Timeout   string
 }
 
+// Envelope metadata of the settlement fx events, protobuf payloads are named after their message
+const (
+	PAYLOAD_TYPE_SETTLEMENT_FX       = "settlementfx.SettlementFX"
+	PAYLOAD_TYPE_SETTLEMENT_FX_PROTO = "messages.SettlementFX"
+	FX_SCHEMA_VERSION                = "v1.0"
+)
+
+// SettlementFXPublisher publishes the rate changes, in JSON with EventPublisher or in protobuf
+// with NewPBEventPublisher.
+type SettlementFXPublisher interface {
+	PublishSettlementFXChangedEvent(ctx context.Context, fx *SettlementFX) error
+}
+
 type EventPublisher struct {
 	p *kafka.Writer
 }
 
+func fxEventType(fx *SettlementFX) string {
+	if fx.Created != "" && fx.Created == fx.Modified {
+		return utils.EVENT_TYPE_CREATED
+	}
+	return utils.EVENT_TYPE_UPDATED
+}
+
 func (p *EventPublisher) PublishSettlementFXChangedEvent(ctx context.Context, fx *SettlementFX) error {
 	val, err := json.Marshal(fx)
 	if err != nil {
@@ -34,9 +54,10 @@ func (p *EventPublisher) PublishSettlementFXChangedEvent(ctx context.Context, fx
 		return err
 	}
 
-	msg := kafka.Message{
-		Key:   []byte(fx.Pair),
-		Value: val,
+	env := utils.NewEventEnvelope(ctx, fxEventType(fx), PAYLOAD_TYPE_SETTLEMENT_FX, FX_SCHEMA_VERSION)
+	msg, err := env.Message([]byte(fx.Pair), utils.CONTENT_TYPE_JSON, val)
+	if err != nil {
+		return err
 	}
 
 	if err = p.p.WriteMessages(ctx, msg); err != nil {
@@ -48,13 +69,23 @@ func (p *EventPublisher) PublishSettlementFXChangedEvent(ctx context.Context, fx
 }
 
 func NewEventPublisher(cfg *EventPublisherConfig) *EventPublisher {
+	writer, err := newFXWriter(cfg)
+	if err != nil {
+		return nil
+	}
+	return &EventPublisher{
+		p: writer,
+	}
+}
+
+func newFXWriter(cfg *EventPublisherConfig) (*kafka.Writer, error) {
 	var dialer *kafka.Dialer
 
 	switch cfg.QueueType {
 	case utils.MSK:
 		awsConfig, err := awsConfig.LoadDefaultConfig(context.Background())
 		if err != nil {
-			return nil
+			return nil, err
 		}
 		dialer = &kafka.Dialer{
 			DualStack:     false,
@@ -83,8 +114,5 @@ func NewEventPublisher(cfg *EventPublisherConfig) *EventPublisher {
 		//ErrorLogger: kafka.LoggerFunc(logError),
 	}
 
-	return &EventPublisher{
-		p: kafka.NewWriter(kafkaConfig),
-	}
-
+	return kafka.NewWriter(kafkaConfig), nil
 }
@@ -0,0 +1,163 @@
+package settlementfx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+const FX_QUARANTINE_BACKEND string = "FX_QUARANTINE_BACKEND"
+const FX_QUARANTINE_SYNC_SECS string = "FX_QUARANTINE_SYNC_SECS"
+
+const (
+	QUARANTINE_MEMORY = "memory"
+	QUARANTINE_REDIS  = "redis"
+)
+
+// Redis hashes of the quarantined and of the approved rates by pair
+const (
+	QUARANTINED_KEY = "FX-QUARANTINED"
+	APPROVED_KEY    = "FX-APPROVED"
+)
+
+// QuarantineStore shares the quarantined and the approved rates between the instances of the
+// validator, by pair as stored.
+type QuarantineStore interface {
+	Quarantine(ctx context.Context, q QuarantinedRate) error
+	// Release drops the quarantined rate of pair, it reports whether there was one
+	Release(ctx context.Context, pair string) (bool, error)
+	// Approve replaces the quarantined rate of q.Rate.Pair with its approval
+	Approve(ctx context.Context, q QuarantinedRate) error
+	// Forget drops the approval of pair
+	Forget(ctx context.Context, pair string) error
+	Load(ctx context.Context) (quarantined []QuarantinedRate, approved []QuarantinedRate, err error)
+}
+
+type memoryQuarantine struct {
+	mu          sync.Mutex
+	quarantined map[string]QuarantinedRate
+	approved    map[string]QuarantinedRate
+}
+
+// NewMemoryQuarantine shares the quarantined rates between the guards of a process only.
+func NewMemoryQuarantine() QuarantineStore {
+	return &memoryQuarantine{quarantined: make(map[string]QuarantinedRate), approved: make(map[string]QuarantinedRate)}
+}
+
+func (m *memoryQuarantine) Quarantine(ctx context.Context, q QuarantinedRate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.quarantined[q.Rate.Pair] = q
+	return nil
+}
+
+func (m *memoryQuarantine) Release(ctx context.Context, pair string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.quarantined[pair]
+	delete(m.quarantined, pair)
+	return ok, nil
+}
+
+func (m *memoryQuarantine) Approve(ctx context.Context, q QuarantinedRate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.quarantined, q.Rate.Pair)
+	m.approved[q.Rate.Pair] = q
+	return nil
+}
+
+func (m *memoryQuarantine) Forget(ctx context.Context, pair string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.approved, pair)
+	return nil
+}
+
+func (m *memoryQuarantine) Load(ctx context.Context) ([]QuarantinedRate, []QuarantinedRate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	quarantined := make([]QuarantinedRate, 0, len(m.quarantined))
+	for _, q := range m.quarantined {
+		quarantined = append(quarantined, q)
+	}
+	approved := make([]QuarantinedRate, 0, len(m.approved))
+	for _, q := range m.approved {
+		approved = append(approved, q)
+	}
+	return quarantined, approved, nil
+}
+
+type redisQuarantine struct {
+	client *redis.Client
+}
+
+// NewRedisQuarantine keeps the quarantined and the approved rates in redis hashes by pair, so
+// every instance lists the same rates and applies the approvals made on another one.
+func NewRedisQuarantine(cache *utils.Cache) QuarantineStore {
+	return &redisQuarantine{client: cache.Client}
+}
+
+func (r *redisQuarantine) Quarantine(ctx context.Context, q QuarantinedRate) error {
+	raw, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+	return r.client.HSet(ctx, QUARANTINED_KEY, q.Rate.Pair, string(raw)).Err()
+}
+
+func (r *redisQuarantine) Release(ctx context.Context, pair string) (bool, error) {
+	n, err := r.client.HDel(ctx, QUARANTINED_KEY, pair).Result()
+	return n > 0, err
+}
+
+func (r *redisQuarantine) Approve(ctx context.Context, q QuarantinedRate) error {
+	raw, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, QUARANTINED_KEY, q.Rate.Pair)
+		pipe.HSet(ctx, APPROVED_KEY, q.Rate.Pair, string(raw))
+		return nil
+	})
+	return err
+}
+
+func (r *redisQuarantine) Forget(ctx context.Context, pair string) error {
+	return r.client.HDel(ctx, APPROVED_KEY, pair).Err()
+}
+
+func (r *redisQuarantine) Load(ctx context.Context) ([]QuarantinedRate, []QuarantinedRate, error) {
+	quarantined, err := r.load(ctx, QUARANTINED_KEY)
+	if err != nil {
+		return nil, nil, err
+	}
+	approved, err := r.load(ctx, APPROVED_KEY)
+	if err != nil {
+		return nil, nil, err
+	}
+	return quarantined, approved, nil
+}
+
+func (r *redisQuarantine) load(ctx context.Context, key string) ([]QuarantinedRate, error) {
+	values, err := r.client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]QuarantinedRate, 0, len(values))
+	for pair, raw := range values {
+		var q QuarantinedRate
+		if err := json.Unmarshal([]byte(raw), &q); err != nil {
+			fmt.Printf("Skip unreadable %s entry (pair: %s), error: %v\n", key, pair, err)
+			continue
+		}
+		list = append(list, q)
+	}
+	return list, nil
+}
@@ -0,0 +1,133 @@
+package settlementfx
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/onecombine/onecombine-msg-validator/src/partners"
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+const REFRESH_SETTLEMENT_FX_SECS string = "REFRESH_SETTLEMENT_FX_SECS"
+const FX_RECONCILE_MAX_REMOVALS string = "FX_RECONCILE_MAX_REMOVALS"
+
+// Drift between the rates listed by the fx API and the store
+const (
+	// Listed but not stored, e.g. a missed creation event
+	DRIFT_MISSING = "MISSING"
+	// Stored with another value than listed, e.g. a missed update event
+	DRIFT_VALUE = "VALUE"
+	// Stored with a newer value than listed, the fx API has not caught up with kafka yet
+	DRIFT_AHEAD = "AHEAD"
+	// Stored but no longer listed, e.g. a missed deletion event
+	DRIFT_REMOVED = "REMOVED"
+)
+
+type FXDrift struct {
+	Pair     string `json:"pair"`
+	Kind     string `json:"kind"`
+	Stored   string `json:"stored,omitempty"`
+	Listed   string `json:"listed,omitempty"`
+	Resolved bool   `json:"resolved"`
+	Error    string `json:"error,omitempty"`
+}
+
+// FXReconciliation reports the drifts found by a refresh, they are resolved in favour of the fx
+// API unless the stored rate is newer.
+type FXReconciliation struct {
+	At     time.Time `json:"at"`
+	Listed int       `json:"listed"`
+	Stored int       `json:"stored"`
+	Drifts []FXDrift `json:"drifts"`
+}
+
+// maxRemovalsFromEnv reads FX_RECONCILE_MAX_REMOVALS, 10 by default, 0 disables the removals.
+func maxRemovalsFromEnv() int {
+	n, err := strconv.Atoi(utils.GetEnv(FX_RECONCILE_MAX_REMOVALS, "10"))
+	if err != nil || n < 0 {
+		return 10
+	}
+	return n
+}
+
+// reconcile applies the listed rates to store and removes the pairs no longer listed, at most
+// maxRemovals per run. Nothing is removed when the list is empty, the other pairs are reported as
+// unresolved drifts. Rates written to the store after since, while the list was fetched, are
+// kept. Loading an empty store, e.g. at startup, is not a drift.
+func reconcile(store *FXStore, listed []*SettlementFX, since time.Time, maxRemovals int) *FXReconciliation {
+	report := &FXReconciliation{At: time.Now(), Listed: len(listed), Drifts: []FXDrift{}}
+	initial := store.Len() == 0
+
+	keep := make(map[string]bool)
+	for _, fx := range listed {
+		keep[fx.Pair] = true
+
+		current, ok := store.Get(fx.Pair)
+		drift := FXDrift{Pair: fx.Pair, Listed: fx.Value}
+		switch {
+		case !ok:
+			drift.Kind = DRIFT_MISSING
+		case current.Value == fx.Value:
+			// Refreshes the timestamps, identical writes are not published
+			if _, _, err := store.UpsertLatest(fx); err != nil && !errors.Is(err, partners.ErrStaleWrite) {
+				fmt.Printf("Skip settlement fx (pair: %s), error: %v\n", fx.Pair, err)
+			}
+			continue
+		case isNewer(current, fx):
+			drift.Kind, drift.Stored = DRIFT_AHEAD, current.Value
+			report.Drifts = append(report.Drifts, drift)
+			continue
+		default:
+			drift.Kind, drift.Stored = DRIFT_VALUE, current.Value
+		}
+
+		_, _, err := store.UpsertLatest(fx)
+		if err == nil && initial {
+			continue
+		}
+		if err != nil {
+			drift.Error = err.Error()
+		} else {
+			drift.Resolved = true
+		}
+		report.Drifts = append(report.Drifts, drift)
+	}
+
+	removals := 0
+	for _, fx := range store.Snapshot() {
+		if keep[fx.Pair] {
+			continue
+		}
+		if v, ok := store.EntityVersion(fx.Pair); ok && v.Applied.After(since) {
+			report.Drifts = append(report.Drifts, FXDrift{Pair: fx.Pair, Kind: DRIFT_AHEAD, Stored: fx.Value})
+			continue
+		}
+		drift := FXDrift{Pair: fx.Pair, Kind: DRIFT_REMOVED, Stored: fx.Value}
+		switch {
+		case len(listed) == 0:
+			// An empty answer is more likely a fx API failure than the end of every pair
+			drift.Error = "fx API listed no rate"
+		case removals >= maxRemovals:
+			drift.Error = fmt.Sprintf("more than %d removals", maxRemovals)
+		default:
+			_, drift.Resolved = store.Delete(fx.Pair)
+			removals++
+		}
+		report.Drifts = append(report.Drifts, drift)
+	}
+	report.Stored = store.Len()
+
+	for _, drift := range report.Drifts {
+		fmt.Printf("Settlement fx drift (pair: %s, kind: %s, stored: %q, listed: %q, resolved: %t) %s\n",
+			drift.Pair, drift.Kind, drift.Stored, drift.Listed, drift.Resolved, drift.Error)
+	}
+	return report
+}
+
+// isNewer reports whether the stored rate was modified after the listed one.
+func isNewer(stored, listed *SettlementFX) bool {
+	s, l := partners.ParseModified(stored.Modified), partners.ParseModified(listed.Modified)
+	return !s.IsZero() && !l.IsZero() && s.After(l)
+}
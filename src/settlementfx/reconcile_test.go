@@ -0,0 +1,139 @@
+package settlementfx
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/onecombine/onecombine-msg-validator/src/apiclient"
+	"github.com/onecombine/onecombine-msg-validator/src/partners"
+)
+
+func driftKinds(report *FXReconciliation) map[string]string {
+	kinds := make(map[string]string)
+	for _, drift := range report.Drifts {
+		kinds[drift.Pair] = drift.Kind
+	}
+	return kinds
+}
+
+func TestReconcile(t *testing.T) {
+	store := NewFXStore()
+	listed := []*SettlementFX{
+		{Pair: "USDTHB", Value: "35.00", Modified: "2025-02-20T00:00:00Z"},
+		{Pair: "EURUSD", Value: "1.08", Modified: "2025-02-20T00:00:00Z"},
+		{Pair: "USDJPY", Value: "150", Modified: "2025-02-20T00:00:00Z"},
+	}
+	report := reconcile(store, listed, time.Now(), 10)
+	assert.Equal(t, 0, len(report.Drifts), "Initial load")
+	assert.Equal(t, 3, report.Stored)
+
+	// Kafka state drifted from the fx API
+	store.Delete("EURUSD")
+	store.UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "35.90", Modified: "2025-02-20T00:00:00Z"})
+	store.UpsertLatest(&SettlementFX{Pair: "USDJPY", Value: "151", Modified: "2025-02-21T00:00:00Z"})
+	store.UpsertLatest(&SettlementFX{Pair: "USDSGD", Value: "1.35"})
+	since := time.Now()
+	store.UpsertLatest(&SettlementFX{Pair: "USDMYR", Value: "4.45"})
+
+	report = reconcile(store, listed, since, 10)
+	assert.Equal(t, map[string]string{
+		"USDTHB": DRIFT_VALUE,
+		"EURUSD": DRIFT_MISSING,
+		"USDJPY": DRIFT_AHEAD,
+		"USDSGD": DRIFT_REMOVED,
+		"USDMYR": DRIFT_AHEAD,
+	}, driftKinds(report))
+
+	fx, _ := store.Get("USDTHB")
+	assert.Equal(t, "35.00", fx.Value, "Listed value restored")
+	fx, _ = store.Get("USDJPY")
+	assert.Equal(t, "151", fx.Value, "Newer kafka rate kept")
+	_, ok := store.Get("EURUSD")
+	assert.Equal(t, true, ok, "Missing pair restored")
+	_, ok = store.Get("USDSGD")
+	assert.Equal(t, false, ok, "Pair removed upstream")
+	_, ok = store.Get("USDMYR")
+	assert.Equal(t, true, ok, "Written while listing")
+
+	report = reconcile(store, listed, time.Now(), 10)
+	assert.Equal(t, map[string]string{"USDJPY": DRIFT_AHEAD, "USDMYR": DRIFT_REMOVED}, driftKinds(report))
+}
+
+func TestReconcileRemovalLimits(t *testing.T) {
+	store := NewFXStore()
+	listed := []*SettlementFX{{Pair: "USDTHB", Value: "35.00"}, {Pair: "EURUSD", Value: "1.08"}, {Pair: "USDJPY", Value: "150"}}
+	reconcile(store, listed, time.Now(), 10)
+
+	for _, empty := range [][]*SettlementFX{{}, nil} {
+		report := reconcile(store, empty, time.Now(), 10)
+		assert.Equal(t, 3, store.Len(), "Nothing removed on an empty list")
+		assert.Equal(t, 3, len(report.Drifts))
+		assert.Equal(t, false, report.Drifts[0].Resolved)
+		assert.Equal(t, DRIFT_REMOVED, report.Drifts[0].Kind)
+	}
+
+	report := reconcile(store, listed[:1], time.Now(), 1)
+	assert.Equal(t, 2, store.Len(), "One removal per run")
+	assert.Equal(t, 2, len(report.Drifts))
+	assert.Equal(t, true, report.Drifts[0].Resolved)
+	assert.Equal(t, false, report.Drifts[1].Resolved, "Reported as drift")
+	assert.Contains(t, report.Drifts[1].Error, "more than 1 removals")
+
+	reconcile(store, listed[:1], time.Now(), 1)
+	assert.Equal(t, 1, store.Len(), "Removed by the next run")
+}
+
+func TestReconcileQuarantinedCorrection(t *testing.T) {
+	store, guard := newGuardedStore(t)
+	listed := []*SettlementFX{{Pair: "USDTHB", Value: "35.00"}}
+	reconcile(store, listed, time.Now(), 10)
+	store.UpsertLatest(&SettlementFX{Pair: "USDTHB", Value: "36.00"})
+
+	report := reconcile(store, []*SettlementFX{{Pair: "USDTHB", Value: "25.00"}}, time.Now(), 10)
+	assert.Equal(t, 1, len(report.Drifts))
+	assert.Equal(t, false, report.Drifts[0].Resolved, "Correction is guarded too")
+	assert.Contains(t, report.Drifts[0].Error, ErrQuarantined.Error())
+	assert.Equal(t, 1, len(guard.Quarantined()))
+}
+
+func TestLoadSettlementFXNotModified(t *testing.T) {
+	var mu sync.Mutex
+	list := []*SettlementFX{{Pair: "USDTHB", Value: "35.00"}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("ETag", `"v1"`)
+		json.NewEncoder(w).Encode(list)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &SettlementFXService{
+		api:     apiclient.NewClient(apiclient.Config{BaseUrl: server.URL}),
+		fxStore: NewFXStore(),
+		status:  partners.NewRefreshStatus(),
+		ctx:     ctx,
+		wg:      &sync.WaitGroup{},
+	}
+	assert.Nil(t, s.Reload())
+	assert.Equal(t, 1, s.fxStore.Len())
+
+	// Missed kafka event, the fx API answers not modified
+	s.fxStore.Upsert(&SettlementFX{Pair: "USDTHB", Value: "3.50"})
+	assert.Nil(t, s.Reload())
+	fx, _ := s.fxStore.Get("USDTHB")
+	assert.Equal(t, "35.00", fx.Value, "Reconciled with the last list")
+	assert.Equal(t, DRIFT_VALUE, s.GetLastReconciliation().Drifts[0].Kind)
+}
@@ -1,12 +1,17 @@
 package settlementfx
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"math/big"
+	"os"
+	"strconv"
 	"sync"
+	"time"
 
+	"github.com/onecombine/onecombine-msg-validator/src/apiclient"
+	"github.com/onecombine/onecombine-msg-validator/src/currency"
 	"github.com/onecombine/onecombine-msg-validator/src/partners"
 )
 
@@ -21,71 +26,302 @@ const (
 	API_LIST_SETTLEMENT_FX_PATH = "/v1/switching/fx"
 )
 
+type FXStore = partners.Store[*SettlementFX]
+type FXChange = partners.Change[*SettlementFX]
+
+// NewFXStore keys the settlement fx rates by currency pair and indexes them by normalized pair,
+// rates older than the stored one are refused.
+func NewFXStore() *FXStore {
+	st := partners.NewStore(func(fx *SettlementFX) string { return fx.Pair }, map[string]partners.IndexFunc[*SettlementFX]{
+		INDEX_FX_PAIR: pairIndex,
+	})
+	st.SetModifiedFunc(func(fx *SettlementFX) time.Time { return partners.ParseModified(fx.Modified) })
+	st.SetEqualFunc(func(old, new *SettlementFX) bool { return *old == *new })
+	return st
+}
+
 type SettlementFXService struct {
-	baseUrl    string
-	fxStore    *partners.MemoryStore
-	fxConsumer SettlementFXConsumer
+	baseUrl     string
+	api         *apiclient.Client
+	fxStore     *FXStore
+	converter   *Converter
+	history     RateHistory
+	guard       *Guard
+	fxConsumer  SettlementFXConsumer
+	consumerErr error
+	status      *partners.RefreshStatus
+	maxRemovals int
 
-	cls chan string
-	wg  *sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+	errs   <-chan error
+	wg     *sync.WaitGroup
+
+	mu             sync.Mutex
+	listed         []*SettlementFX
+	reconciliation *FXReconciliation
 }
 
+// NewSettlementFXService starts without fx events when the consumer cannot be configured, the
+// error is then reported by ConsumerError.
 func NewSettlementFXService(baseUrl string, kConfig *partners.KafkaConfig) *SettlementFXService {
-	store := partners.NewMemoryStore()
+	service, err := NewSettlementFXServiceWithContext(context.Background(), baseUrl, kConfig)
+	if err != nil {
+		fmt.Printf("Start settlement fx service without fx events, error: %v\n", err)
+	}
+	return service
+}
 
-	consumer := NewKafkaSettlementFXConsumer(store, kConfig)
+// NewSettlementFXServiceWithContext starts the fx consumer, it runs until ctx is cancelled or
+// the service is shut down. When the consumer cannot be configured the rates are still loaded
+// from the fx API, the service is returned without consumer along with the error.
+func NewSettlementFXServiceWithContext(ctx context.Context, baseUrl string, kConfig *partners.KafkaConfig) (*SettlementFXService, error) {
+	store := NewFXStore()
+	guard := NewGuardFromEnv()
+	guardStore(store, guard)
+	history := NewHistoryFromEnv()
+	converter := NewConverterFromEnv(store)
+	converter.SetHistory(history)
+
+	consumer, consumerErr := NewKafkaSettlementFXConsumer(store, kConfig)
 	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(ctx)
 
 	service := &SettlementFXService{
-		baseUrl:    baseUrl,
-		fxStore:    store,
-		fxConsumer: consumer,
-		wg:         &wg,
+		baseUrl:     baseUrl,
+		api:         apiclient.NewClient(apiclient.NewConfigFromEnv(baseUrl)),
+		fxStore:     store,
+		converter:   converter,
+		history:     history,
+		guard:       guard,
+		consumerErr: consumerErr,
+		status:      partners.NewRefreshStatus(),
+		maxRemovals: maxRemovalsFromEnv(),
+		ctx:         ctx,
+		cancel:      cancel,
+		wg:          &wg,
 	}
 
+	// Hooked first so the rates of the initial refresh are recorded
+	recordHistory(service.ctx, store, history)
+	service.syncQuarantine()
 	if err := service.refreshSettlementFX(); err != nil {
 		fmt.Printf("Error refresh the settlement fx, error: %v\n", err)
 	}
 
-	service.cls = service.fxConsumer.Subscribe(service.wg)
+	if consumerErr != nil {
+		errs := make(chan error, 1)
+		errs <- consumerErr
+		service.errs = errs
+		return service, fmt.Errorf("settlement fx consumer: %w", consumerErr)
+	}
+	service.fxConsumer = consumer
+	service.errs = service.fxConsumer.Subscribe(service.ctx, service.wg)
 
-	return service
+	return service, nil
 }
 
-func (s *SettlementFXService) GetFXStore() *partners.MemoryStore {
+func (s *SettlementFXService) GetFXStore() *FXStore {
 	return s.fxStore
 }
 
+// Rate returns the exact amount of to for one unit of from, see Converter.
+func (s *SettlementFXService) Rate(from, to string) (*big.Rat, error) {
+	return s.converter.Rate(from, to)
+}
+
+// Convert converts amount of from into to, rounded to the minor units of to as FX_ROUNDING_MODE.
+func (s *SettlementFXService) Convert(amount *big.Rat, from, to string) (*big.Rat, error) {
+	return s.converter.Convert(amount, from, to)
+}
+
+func (s *SettlementFXService) ConvertWithRounding(amount *big.Rat, from, to string, mode currency.RoundingMode) (*big.Rat, error) {
+	return s.converter.ConvertWithRounding(amount, from, to, mode)
+}
+
+// RateAt returns the rate in effect at t, e.g. the time of the original payment of a refund.
+func (s *SettlementFXService) RateAt(ctx context.Context, from, to string, t time.Time) (*big.Rat, error) {
+	return s.converter.RateAt(ctx, from, to, t)
+}
+
+func (s *SettlementFXService) ConvertAt(ctx context.Context, amount *big.Rat, from, to string, t time.Time) (*big.Rat, error) {
+	return s.converter.ConvertAt(ctx, amount, from, to, t)
+}
+
+// GetRateHistory returns the kept rates of a pair ordered by effective time.
+func (s *SettlementFXService) GetRateHistory(ctx context.Context, pair string) ([]RatePoint, error) {
+	base, quote, err := ParsePair(pair)
+	if err != nil {
+		return nil, err
+	}
+	return s.history.Points(ctx, base+quote)
+}
+
+// GetQuarantinedRates returns the suspicious rates waiting for an approval.
+func (s *SettlementFXService) GetQuarantinedRates() []QuarantinedRate {
+	return s.guard.Quarantined()
+}
+
+// ApproveQuarantinedRate applies the quarantined rate of pair, on every instance when the
+// quarantine is shared.
+func (s *SettlementFXService) ApproveQuarantinedRate(pair string) (*SettlementFX, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), partners.CONSUMER_COMMIT_TIMEOUT)
+	defer cancel()
+	return s.guard.Approve(ctx, s.fxStore, pair)
+}
+
+func (s *SettlementFXService) RejectQuarantinedRate(pair string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), partners.CONSUMER_COMMIT_TIMEOUT)
+	defer cancel()
+	return s.guard.Reject(ctx, pair)
+}
+
+// GetStaleRates returns the pairs not updated for FX_STALE_AFTER, none when it is unset.
+func (s *SettlementFXService) GetStaleRates() []string {
+	return s.converter.StaleRates()
+}
+
+func (s *SettlementFXService) GetRefreshStatus() *partners.RefreshStatus {
+	return s.status
+}
+
+// ConsumerError returns the error which kept the fx consumer from starting.
+func (s *SettlementFXService) ConsumerError() error {
+	return s.consumerErr
+}
+
+func (s *SettlementFXService) GetConsumerStats() *partners.ConsumerStats {
+	if s.fxConsumer == nil {
+		return nil
+	}
+	return s.fxConsumer.Stats()
+}
+
+func (s *SettlementFXService) GetConsumerOffsets() []partners.TopicOffset {
+	if s.fxConsumer == nil {
+		return []partners.TopicOffset{}
+	}
+	return s.fxConsumer.Stats().Offsets()
+}
+
+// OnFXChanged calls fn after every rate creation, update and deletion, whether it comes from
+// kafka or from a refresh. It returns the function cancelling the subscription.
+func (s *SettlementFXService) OnFXChanged(fn func(FXChange)) func() {
+	return s.fxStore.Changes().Subscribe(fn)
+}
+
 func (s *SettlementFXService) WaitForCompletion() {
 	s.wg.Wait()
 }
 
+// GracefullyShutdown stops the consumer without waiting, see Shutdown.
 func (s *SettlementFXService) GracefullyShutdown() {
+	s.cancel()
+}
+
+// Shutdown stops the consumer and waits for it, the offset of the message in flight is
+// committed. It returns ctx.Err() when ctx expires first.
+func (s *SettlementFXService) Shutdown(ctx context.Context) error {
+	s.cancel()
+
+	done := make(chan struct{})
 	go func() {
-		s.cls <- "done"
+		s.wg.Wait()
+		close(done)
 	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Errors returns the consumer errors, errors are dropped when they are not drained.
+func (s *SettlementFXService) Errors() <-chan error {
+	return s.errs
 }
 
 func (s *SettlementFXService) refreshSettlementFX() error {
-	response, err := http.Get(fmt.Sprintf("%s%s", s.baseUrl, API_LIST_SETTLEMENT_FX_PATH))
+	err := s.loadSettlementFX()
+	s.status.Record(err)
+	return err
+}
 
-	if err != nil {
+func (s *SettlementFXService) loadSettlementFX() error {
+	since := time.Now()
+	var fxs []*SettlementFX
+	err := s.api.GetJSONIfModified(s.ctx, API_LIST_SETTLEMENT_FX_PATH, &fxs)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case errors.Is(err, apiclient.ErrNotModified) && s.listed == nil:
 		return nil
+	case errors.Is(err, apiclient.ErrNotModified):
+		// The last list is still current, kafka may have drifted from it meanwhile
+		fxs = s.listed
+	case err != nil:
+		return err
 	}
+	s.listed = fxs
+	s.reconciliation = reconcile(s.fxStore, fxs, since, s.maxRemovals)
+	return nil
+}
 
-	defer response.Body.Close()
-
-	responseData, err := io.ReadAll(response.Body)
-	if err != nil {
-		return err
+// syncQuarantine loads the shared quarantine, then syncs it every FX_QUARANTINE_SYNC_SECS, 5 by
+// default, until the service is shut down.
+func (s *SettlementFXService) syncQuarantine() {
+	if !s.guard.Shared() {
+		return
+	}
+	if err := s.guard.Sync(s.ctx, s.fxStore); err != nil {
+		fmt.Printf("Unable to load the fx quarantine, error: %v\n", err)
 	}
 
-	var fxs []*SettlementFX
-	json.Unmarshal(responseData, &fxs)
+	period, err := strconv.Atoi(os.Getenv(FX_QUARANTINE_SYNC_SECS))
+	if err != nil || period <= 0 {
+		period = 5 // Default
+	}
+	s.guard.Run(s.ctx, s.wg, s.fxStore, time.Duration(period)*time.Second)
+}
 
-	for _, fx := range fxs {
-		s.fxStore.Set(fx.Pair, fx)
+// StartSettlementFXScheduler reloads the rates every REFRESH_SETTLEMENT_FX_SECS, 60 by default,
+// and reconciles the store with them until the service is shut down.
+func (s *SettlementFXService) StartSettlementFXScheduler() {
+	period, err := strconv.Atoi(os.Getenv(REFRESH_SETTLEMENT_FX_SECS))
+	if err != nil || period <= 0 {
+		period = 60 // Default
 	}
 
-	return nil
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(time.Duration(period) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := s.refreshSettlementFX(); err != nil && s.ctx.Err() == nil {
+				fmt.Printf("Error refresh the settlement fx, error: %v\n", err)
+			}
+		}
+	}()
+}
+
+// Reload refreshes the rates now and reconciles the store with them.
+func (s *SettlementFXService) Reload() error {
+	return s.refreshSettlementFX()
+}
+
+// GetLastReconciliation returns the drifts found by the last successful refresh, nil before it.
+func (s *SettlementFXService) GetLastReconciliation() *FXReconciliation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reconciliation
 }
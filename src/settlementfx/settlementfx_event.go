@@ -2,7 +2,7 @@ package settlementfx
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -24,14 +24,17 @@ type SettlementFxEvent struct {
 }
 
 type SettlementFXConsumer interface {
-	Subscribe(wg *sync.WaitGroup) chan string
+	Subscribe(ctx context.Context, wg *sync.WaitGroup) <-chan error
 	Process(e *SettlementFxEvent) error
+	Stats() *partners.ConsumerStats
 }
 
 type fxConsumer struct {
-	store   *partners.MemoryStore
+	store   *FXStore
 	kreader *kafka.Reader
 	cfg     *partners.KafkaConfig
+	stats   *partners.ConsumerStats
+	policy  partners.ConsumePolicy
 }
 
 // Process implements SettlementFXConsumer.
@@ -42,59 +45,47 @@ func (f *fxConsumer) Process(e *SettlementFxEvent) error {
 		Created:  e.Created,
 		Modified: e.Modified,
 	}
-	f.store.Set(fx.Pair, fx)
-	return nil
+	_, _, err := f.store.UpsertLatest(fx)
+	return err
 }
 
-// Subscribe implements SettlementFXConsumer.
-func (f *fxConsumer) Subscribe(wg *sync.WaitGroup) chan string {
-	cls := make(chan string)
-	topic := f.cfg.TopicName
-
-	go func() {
-		fmt.Printf("Subscribe to settlement fx event stream, topic: %s\n", topic)
-
-		for {
-			select {
-			case <-cls:
-				fmt.Printf("Stop subscribe topic: %s\n", topic)
-				f.kreader.Close()
-				wg.Done()
-				close(cls)
-				return
-			default:
-				fmt.Printf("Fetching message (topic: %s)\n", topic)
-				msg, err := f.kreader.FetchMessage(context.TODO())
-				if err != nil {
-					fmt.Printf("Error fetch message from kafka (topic: %s), error: %v", topic, err)
-					continue
-				}
-
-				var event SettlementFxEvent
-				if err = json.Unmarshal(msg.Value, &event); err != nil {
-					fmt.Printf("Unable to unmarshal event message (settlementFx), error: %v", err)
-					f.kreader.CommitMessages(context.TODO(), msg)
-					continue
-				}
-
-				if err = f.Process(&event); err != nil {
-					fmt.Printf("Unable to process settlement fx event, error: %v", err)
-				} else {
-					fmt.Printf("Process settlement fx event successfully")
-				}
-
-				f.kreader.CommitMessages(context.TODO(), msg)
-				time.Sleep(1000 * time.Millisecond)
-			}
-		}
-	}()
+// Stats implements SettlementFXConsumer.
+func (f *fxConsumer) Stats() *partners.ConsumerStats {
+	return f.stats
+}
+
+// Subscribe implements SettlementFXConsumer, it consumes the fx topic until ctx is cancelled.
+func (f *fxConsumer) Subscribe(ctx context.Context, wg *sync.WaitGroup) <-chan error {
+	return partners.Consume(ctx, wg, f.kreader, f.stats, f.policy, f.handle)
+}
+
+func (f *fxConsumer) handle(ctx context.Context, msg kafka.Message) error {
+	event, _, err := decodeFXEvent(msg)
+	if err != nil {
+		fmt.Printf("Unable to decode event message (settlementFx), error: %v\n", err)
+		return partners.Permanent(err)
+	}
 
-	wg.Add(1)
+	if event == nil {
+		f.store.Delete(string(msg.Key))
+		fmt.Printf("Remove settlement fx (pair: %s)\n", string(msg.Key))
+		return nil
+	}
 
-	return cls
+	err = f.Process(event)
+	if errors.Is(err, ErrQuarantined) {
+		// Held for a manual approval, redelivering it would only quarantine it again
+		return nil
+	}
+	if err != nil {
+		fmt.Printf("Unable to process settlement fx event, error: %v\n", err)
+		return err
+	}
+	fmt.Printf("Process settlement fx event successfully (pair: %s)\n", event.Pair)
+	return nil
 }
 
-func NewKafkaSettlementFXConsumer(store *partners.MemoryStore, cfg *partners.KafkaConfig) SettlementFXConsumer {
+func NewKafkaSettlementFXConsumer(store *FXStore, cfg *partners.KafkaConfig) (SettlementFXConsumer, error) {
 	hosts := strings.Split(cfg.Bootstrap, ",")
 
 	var dialer *kafka.Dialer
@@ -102,7 +93,7 @@ func NewKafkaSettlementFXConsumer(store *partners.MemoryStore, cfg *partners.Kaf
 	case utils.MSK:
 		awsConfig, err := config.LoadDefaultConfig(context.Background())
 		if err != nil {
-			return nil
+			return nil, fmt.Errorf("msk authentication: %w", err)
 		}
 		dialer = &kafka.Dialer{
 			DualStack:     false,
@@ -145,5 +136,7 @@ func NewKafkaSettlementFXConsumer(store *partners.MemoryStore, cfg *partners.Kaf
 		kreader: reader,
 		store:   store,
 		cfg:     cfg,
-	}
+		stats:   partners.NewConsumerStats(cfg.TopicName),
+		policy:  partners.NewConsumePolicy(cfg, dialer, 1000*time.Millisecond),
+	}, nil
 }
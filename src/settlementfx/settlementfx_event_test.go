@@ -0,0 +1,40 @@
+package settlementfx
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/onecombine/onecombine-msg-validator/src/partners"
+	"github.com/onecombine/onecombine-msg-validator/src/utils"
+)
+
+func TestSettlementFXServiceWithBadMSKConfig(t *testing.T) {
+	t.Setenv("AWS_MAX_ATTEMPTS", "invalid")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]*SettlementFX{{Pair: "USDTHB", Value: "35.00"}})
+	}))
+	defer server.Close()
+	cfg := &partners.KafkaConfig{Bootstrap: "127.0.0.1:0", TopicName: "fx", QueueType: utils.MSK}
+
+	var s *SettlementFXService
+	var err error
+	assert.NotPanics(t, func() {
+		s, err = NewSettlementFXServiceWithContext(context.Background(), server.URL, cfg)
+	})
+	assert.ErrorContains(t, err, "msk authentication")
+	assert.NotNil(t, s, "Rates are still served from the fx API")
+	assert.NotNil(t, s.ConsumerError())
+	assert.NotNil(t, <-s.Errors())
+	assert.Nil(t, s.GetConsumerStats())
+	assert.Equal(t, 1, s.GetFXStore().Len())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(t, s.Shutdown(ctx))
+}
@@ -94,6 +94,11 @@ func (autils AwsUtils) GetSecretValue(region, secretId string) string {
 	return *result.SecretString
 }
 
+// IsLoaded reports whether any value was loaded from AWS Secrets Manager.
+func (sv AwsSecretValues) IsLoaded() bool {
+	return sv != AwsSecretValues{}
+}
+
 func (sv AwsSecretValues) GetApiKeysMap() map[string]*ApiKeyMapValue {
 	result := make(map[string]*ApiKeyMapValue)
 	result[sv.Acquirer01ApiKey] = &ApiKeyMapValue{ApiKey: sv.Acquirer01ApiKey, SecretKey: sv.Acquirer01SecretKey, IdempotencyKey: sv.Acquirer01IdempotencyKey, Id: sv.Acquirer01Id, WebhookUrl: GetEnv("ACQUIRER01_WEBHOOKURL", "")}
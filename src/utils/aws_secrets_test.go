@@ -71,6 +71,12 @@ func TestNewAwsUtils(t *testing.T) {
 		XnapApiKey:               "XAK",
 	}
 	assert.Equal(t, expected, secret, "Missing some definition in AWS Secrets Manager")
+	assert.Equal(t, true, secret.IsLoaded(), "Partially loaded secrets")
+
+	mock = NewMockAwsUtils("")
+	loader = mock.(IAwsSecretStringLoader)
+	secret = NewAwsSecretValues(&loader)
+	assert.Equal(t, false, secret.IsLoaded(), "No secret loaded")
 }
 
 func TestGetApiKeysMap(t *testing.T) {
@@ -93,6 +93,10 @@ func (cache Cache) Delete(key string) error {
 	return nil
 }
 
+func (cache Cache) Ping(ctx context.Context) error {
+	return cache.Client.Ping(ctx).Err()
+}
+
 func (cache Cache) QrKey(id string) string {
 	return fmt.Sprintf("QR-%s", id)
 }
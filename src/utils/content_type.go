@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const CONTENT_TYPE_HEADER string = "content-type"
+
+const (
+	CONTENT_TYPE_JSON     = "application/json"
+	CONTENT_TYPE_PROTOBUF = "application/x-protobuf"
+)
+
+func ContentTypeHeader(contentType string) kafka.Header {
+	return kafka.Header{Key: CONTENT_TYPE_HEADER, Value: []byte(contentType)}
+}
+
+// ContentTypeOf returns the encoding of a kafka message from its content-type header. Messages
+// published without the header are sniffed: JSON objects start with '{', anything else is
+// taken as protobuf.
+func ContentTypeOf(msg kafka.Message) string {
+	for _, h := range msg.Headers {
+		if !strings.EqualFold(h.Key, CONTENT_TYPE_HEADER) {
+			continue
+		}
+		mediaType, _, err := mime.ParseMediaType(string(h.Value))
+		if err != nil {
+			break
+		}
+		return strings.ToLower(mediaType)
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(msg.Value), []byte("{")) {
+		return CONTENT_TYPE_JSON
+	}
+	return CONTENT_TYPE_PROTOBUF
+}
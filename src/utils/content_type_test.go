@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentTypeOf(t *testing.T) {
+	assert.Equal(t, CONTENT_TYPE_JSON, ContentTypeOf(kafka.Message{Value: []byte(` {"id":1}`)}))
+	assert.Equal(t, CONTENT_TYPE_PROTOBUF, ContentTypeOf(kafka.Message{Value: []byte{0x0a, 0x04, 'v', '1', '.', '0'}}))
+
+	msg := kafka.Message{
+		Value:   []byte{0x0a, 0x04, 'v', '1', '.', '0'},
+		Headers: []kafka.Header{{Key: "Content-Type", Value: []byte("application/json; charset=utf-8")}},
+	}
+	assert.Equal(t, CONTENT_TYPE_JSON, ContentTypeOf(msg), "Header wins over sniffing")
+
+	msg.Headers = []kafka.Header{ContentTypeHeader(CONTENT_TYPE_PROTOBUF)}
+	assert.Equal(t, CONTENT_TYPE_PROTOBUF, ContentTypeOf(msg))
+}
@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/onecombine/onecombine-msg-validator/src/messages"
+)
+
+const EVENT_SOURCE string = "EVENT_SOURCE"
+
+// EVENT_ENVELOPE_ENABLED wraps the published messages in an EventEnvelope, they are published
+// bare by default. Consumers older than the envelope cannot read wrapped messages, so it is only
+// set on the publishers once every consumer of their topics reads both formats.
+const EVENT_ENVELOPE_ENABLED string = "EVENT_ENVELOPE_ENABLED"
+
+// Messages carrying this header are wrapped in an EventEnvelope, the others are bare payloads
+// published before the envelope existed.
+const ENVELOPE_HEADER string = "envelope-version"
+const ENVELOPE_VERSION string = "v1"
+
+const (
+	EVENT_TYPE_CREATED = "CREATED"
+	EVENT_TYPE_UPDATED = "UPDATED"
+	EVENT_TYPE_DELETED = "DELETED"
+)
+
+var ErrUnsupportedEnvelope = errors.New("unsupported event envelope")
+
+// EventEnvelope is the JSON mirror of messages.EventEnvelope.
+type EventEnvelope struct {
+	EventID       string          `json:"event_id"`
+	EventType     string          `json:"event_type"`
+	OccurredAt    string          `json:"occurred_at"`
+	Source        string          `json:"source"`
+	SchemaVersion string          `json:"schema_version"`
+	CorrelationID string          `json:"correlation_id"`
+	PayloadType   string          `json:"payload_type"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+}
+
+// NewEventEnvelope returns the envelope of a new event, the correlation id is the request id
+// stored in ctx.
+func NewEventEnvelope(ctx context.Context, eventType, payloadType, schemaVersion string) *EventEnvelope {
+	return &EventEnvelope{
+		EventID:       NewRequestId(),
+		EventType:     eventType,
+		OccurredAt:    time.Now().UTC().Format(time.RFC3339Nano),
+		Source:        GetEnv(EVENT_SOURCE, "onecombine-msg-validator"),
+		SchemaVersion: schemaVersion,
+		CorrelationID: RequestIdFromContext(ctx),
+		PayloadType:   payloadType,
+	}
+}
+
+func EnvelopeEnabled() bool {
+	enabled, _ := strconv.ParseBool(GetEnv(EVENT_ENVELOPE_ENABLED, "false"))
+	return enabled
+}
+
+// Message wraps the payload, encoded as contentType, into a kafka message. The payload is
+// published bare unless EVENT_ENVELOPE_ENABLED is set.
+func (e *EventEnvelope) Message(key []byte, contentType string, payload []byte) (kafka.Message, error) {
+	if !EnvelopeEnabled() {
+		return e.BareMessage(key, contentType, payload), nil
+	}
+
+	var val []byte
+	var err error
+	switch contentType {
+	case CONTENT_TYPE_JSON:
+		env := *e
+		env.Payload = payload
+		val, err = json.Marshal(env)
+	case CONTENT_TYPE_PROTOBUF:
+		val, err = proto.Marshal(&pb.EventEnvelope{
+			EventId:       e.EventID,
+			EventType:     e.EventType,
+			OccurredAt:    e.OccurredAt,
+			Source:        e.Source,
+			SchemaVersion: e.SchemaVersion,
+			CorrelationId: e.CorrelationID,
+			PayloadType:   e.PayloadType,
+			Payload:       payload,
+		})
+	default:
+		err = fmt.Errorf("%w: content type %s", ErrUnsupportedEnvelope, contentType)
+	}
+	if err != nil {
+		return kafka.Message{}, err
+	}
+
+	headers := []kafka.Header{
+		ContentTypeHeader(contentType),
+		{Key: ENVELOPE_HEADER, Value: []byte(ENVELOPE_VERSION)},
+	}
+	if e.CorrelationID != "" {
+		headers = append(headers, kafka.Header{Key: REQUEST_ID_HEADER, Value: []byte(e.CorrelationID)})
+	}
+	return kafka.Message{Key: key, Value: val, Headers: headers}, nil
+}
+
+// BareMessage is the kafka message of the payload without envelope, as published before the
+// envelope existed. A DELETED event is a tombstone, a message without value.
+func (e *EventEnvelope) BareMessage(key []byte, contentType string, payload []byte) kafka.Message {
+	headers := []kafka.Header{ContentTypeHeader(contentType)}
+	if e.CorrelationID != "" {
+		headers = append(headers, kafka.Header{Key: REQUEST_ID_HEADER, Value: []byte(e.CorrelationID)})
+	}
+	return kafka.Message{Key: key, Value: payload, Headers: headers}
+}
+
+// OpenEnvelope returns the envelope and the payload of a kafka message. Bare messages are
+// returned as is with a nil envelope, so consumers handle both during the migration.
+func OpenEnvelope(msg kafka.Message) (*EventEnvelope, []byte, error) {
+	version := ""
+	for _, h := range msg.Headers {
+		if h.Key == ENVELOPE_HEADER {
+			version = string(h.Value)
+		}
+	}
+	if version == "" {
+		return nil, msg.Value, nil
+	}
+	if version != ENVELOPE_VERSION {
+		return nil, nil, fmt.Errorf("%w: version %s", ErrUnsupportedEnvelope, version)
+	}
+
+	switch contentType := ContentTypeOf(msg); contentType {
+	case CONTENT_TYPE_JSON:
+		var env EventEnvelope
+		if err := json.Unmarshal(msg.Value, &env); err != nil {
+			return nil, nil, err
+		}
+		payload := []byte(env.Payload)
+		env.Payload = nil
+		return &env, payload, nil
+	case CONTENT_TYPE_PROTOBUF:
+		var env pb.EventEnvelope
+		if err := proto.Unmarshal(msg.Value, &env); err != nil {
+			return nil, nil, err
+		}
+		return &EventEnvelope{
+			EventID:       env.EventId,
+			EventType:     env.EventType,
+			OccurredAt:    env.OccurredAt,
+			Source:        env.Source,
+			SchemaVersion: env.SchemaVersion,
+			CorrelationID: env.CorrelationId,
+			PayloadType:   env.PayloadType,
+		}, env.Payload, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: content type %s", ErrUnsupportedEnvelope, contentType)
+	}
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventEnvelope(t *testing.T) {
+	t.Setenv(EVENT_ENVELOPE_ENABLED, "true")
+	ctx := ContextWithRequestId(context.Background(), "REQ-001")
+
+	for _, contentType := range []string{CONTENT_TYPE_JSON, CONTENT_TYPE_PROTOBUF} {
+		env := NewEventEnvelope(ctx, EVENT_TYPE_UPDATED, "test.Payload", "v1.0")
+		msg, err := env.Message([]byte("KEY"), contentType, []byte(`{"a":1}`))
+		assert.Nil(t, err)
+		assert.Equal(t, "KEY", string(msg.Key))
+		assert.Equal(t, "REQ-001", RequestIdFromHeaders(msg.Headers), "Correlation id header")
+
+		opened, payload, err := OpenEnvelope(msg)
+		assert.Nil(t, err)
+		assert.Equal(t, `{"a":1}`, string(payload), "Payload (%s)", contentType)
+		assert.Equal(t, *env, *opened, "Envelope (%s)", contentType)
+	}
+
+	// Bare messages pass through
+	opened, payload, err := OpenEnvelope(kafka.Message{Value: []byte(`{"a":1}`)})
+	assert.Nil(t, err)
+	assert.Nil(t, opened)
+	assert.Equal(t, `{"a":1}`, string(payload))
+
+	_, _, err = OpenEnvelope(kafka.Message{Value: []byte(`{}`), Headers: []kafka.Header{{Key: ENVELOPE_HEADER, Value: []byte("v9")}}})
+	assert.ErrorIs(t, err, ErrUnsupportedEnvelope)
+}
+
+func TestEventEnvelopeDisabled(t *testing.T) {
+	ctx := ContextWithRequestId(context.Background(), "REQ-001")
+	env := NewEventEnvelope(ctx, EVENT_TYPE_UPDATED, "test.Payload", "v1.0")
+	msg, err := env.Message([]byte("KEY"), CONTENT_TYPE_JSON, []byte(`{"a":1}`))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"a":1}`, string(msg.Value), "Published bare by default")
+	assert.Equal(t, "REQ-001", RequestIdFromHeaders(msg.Headers))
+
+	opened, payload, err := OpenEnvelope(msg)
+	assert.Nil(t, err)
+	assert.Nil(t, opened)
+	assert.Equal(t, `{"a":1}`, string(payload))
+}
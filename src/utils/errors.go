@@ -8,6 +8,11 @@ const (
 	CODE_INVALID_SIGNATURE = "00400002"
 	CODE_BAD_REQUEST       = "00400006"
 	CODE_ORDER_NOT_FOUND   = "00404001"
+	CODE_PARTNER_NOT_FOUND = "00404002"
+	CODE_FX_NOT_FOUND      = "00404003"
+	CODE_PAYLOAD_TOO_LARGE = "00413001"
+	CODE_UNSUPPORTED_TYPE  = "00415001"
+	CODE_UNSUPPORTED_ENC   = "00415002"
 	CODE_ORDER_REF_EXIST   = "00400009"
 
 	// Reversal
@@ -25,6 +30,11 @@ const (
 	MSG_INVALID_SIGNATURE = "Invalid signature"
 	MSG_BAD_REQUEST       = "A field contains invalid value"
 	MSG_ORDER_NOT_FOUND   = "Order cannot be found"
+	MSG_PARTNER_NOT_FOUND = "Partner cannot be found"
+	MSG_FX_NOT_FOUND      = "Settlement fx rate cannot be found"
+	MSG_PAYLOAD_TOO_LARGE = "Request body is too large"
+	MSG_UNSUPPORTED_TYPE  = "Content-Type is not supported"
+	MSG_UNSUPPORTED_ENC   = "Content-Encoding is not supported"
 	MSG_ORDER_REF_EXIST   = "order_ref already exists"
 
 	// Reversal
@@ -43,6 +53,11 @@ var (
 		CODE_INVALID_SIGNATURE:            MSG_INVALID_SIGNATURE,
 		CODE_BAD_REQUEST:                  MSG_BAD_REQUEST,
 		CODE_ORDER_NOT_FOUND:              MSG_ORDER_NOT_FOUND,
+		CODE_PARTNER_NOT_FOUND:            MSG_PARTNER_NOT_FOUND,
+		CODE_FX_NOT_FOUND:                 MSG_FX_NOT_FOUND,
+		CODE_PAYLOAD_TOO_LARGE:            MSG_PAYLOAD_TOO_LARGE,
+		CODE_UNSUPPORTED_TYPE:             MSG_UNSUPPORTED_TYPE,
+		CODE_UNSUPPORTED_ENC:              MSG_UNSUPPORTED_ENC,
 		CODE_ORDER_REF_EXIST:              MSG_ORDER_REF_EXIST,
 		CODE_REFUND_NOT_ALLOW:             MSG_REFUND_NOT_ALLOW,
 		CODE_CANCEL_NOT_ALLOW:             MSG_CANCEL_NOT_ALLOW,
@@ -87,6 +102,27 @@ func InvalidSignature() *ErrorResponse {
 	}
 }
 
+func PayloadTooLargeError() *ErrorResponse {
+	return &ErrorResponse{
+		Code:        CODE_PAYLOAD_TOO_LARGE,
+		Description: MSG_PAYLOAD_TOO_LARGE,
+	}
+}
+
+func UnsupportedContentTypeError() *ErrorResponse {
+	return &ErrorResponse{
+		Code:        CODE_UNSUPPORTED_TYPE,
+		Description: MSG_UNSUPPORTED_TYPE,
+	}
+}
+
+func UnsupportedContentEncodingError() *ErrorResponse {
+	return &ErrorResponse{
+		Code:        CODE_UNSUPPORTED_ENC,
+		Description: MSG_UNSUPPORTED_ENC,
+	}
+}
+
 // General purpose error
 func CreateErrorResponse(code string) *ErrorResponse {
 
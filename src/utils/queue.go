@@ -54,8 +54,14 @@ type QueueMessage struct {
 	WebHookUrl string `json:"webhook_url" binding:"required"`
 	Data       string `json:"data" binding:"required"`
 	Event      string `json:"event" binding:"required"`
+	RequestId  string `json:"-"`
+	EventId    string `json:"-"`
 }
 
+// Envelope metadata of the notification messages
+const PAYLOAD_TYPE_QUEUE_MESSAGE string = "utils.QueueMessage"
+const QUEUE_SCHEMA_VERSION string = "v1.0"
+
 type QueueMessageConsumer interface {
 	ProcessMessage(msg QueueMessage)
 }
@@ -152,7 +158,13 @@ func (queue Queue) Publish(ctx context.Context, msg QueueMessage) error {
 		return err
 	}
 
-	err = (queue.KafkaWriter).WriteMessages(ctx, kafka.Message{Value: raw})
+	env := NewEventEnvelope(ctx, EVENT_TYPE_CREATED, PAYLOAD_TYPE_QUEUE_MESSAGE, QUEUE_SCHEMA_VERSION)
+	m, err := env.Message(nil, CONTENT_TYPE_JSON, raw)
+	if err != nil {
+		return err
+	}
+
+	err = (queue.KafkaWriter).WriteMessages(ctx, m)
 	return err
 }
 
@@ -167,10 +179,18 @@ func (queue Queue) Subscribe(ctx context.Context, consumer QueueMessageConsumer)
 			continue
 		}
 		var message QueueMessage
-		err = json.Unmarshal(m.Value, &message)
+		env, payload, err := OpenEnvelope(m)
 		if err != nil {
 			log.Printf("%v\n", err)
 		}
+		err = json.Unmarshal(payload, &message)
+		if err != nil {
+			log.Printf("%v\n", err)
+		}
+		message.RequestId = RequestIdFromHeaders(m.Headers)
+		if env != nil {
+			message.EventId = env.EventID
+		}
 		consumer.ProcessMessage(message)
 		err = (queue.KafkaReader).CommitMessages(ctx, m)
 		if err != nil {
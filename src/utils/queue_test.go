@@ -20,6 +20,7 @@ type Result struct {
 	ResultReadTimeout  time.Duration
 	ResultWriteTimeout time.Duration
 	Msg                []byte
+	Headers            []kafka.Header
 }
 
 type MockKafkaWriter struct {
@@ -28,6 +29,7 @@ type MockKafkaWriter struct {
 
 func (m *MockKafkaWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
 	m.result.Msg = msgs[0].Value
+	m.result.Headers = msgs[0].Headers
 	return nil
 }
 
@@ -117,6 +119,38 @@ func TestPublish(t *testing.T) {
 	queue.Publish(context.TODO(), msg)
 
 	assert.Equal(t, string(expectedMsg), string(mockWriter.result.Msg), "Check msg")
+
+	// Wrapped once every consumer reads the envelope
+	t.Setenv(EVENT_ENVELOPE_ENABLED, "true")
+	queue.Publish(context.TODO(), msg)
+
+	env, payload, err := OpenEnvelope(kafka.Message{Value: mockWriter.result.Msg, Headers: mockWriter.result.Headers})
+	assert.Nil(t, err)
+	assert.Equal(t, string(expectedMsg), string(payload), "Check msg")
+	assert.Equal(t, EVENT_TYPE_CREATED, env.EventType, "Check event type")
+	assert.Equal(t, PAYLOAD_TYPE_QUEUE_MESSAGE, env.PayloadType, "Check payload type")
+	assert.NotEqual(t, "", env.EventID, "Check event id")
+}
+
+func TestPublishWithRequestId(t *testing.T) {
+	mockWriter := MockKafkaWriter{}
+
+	CreateWriter = func(config kafka.WriterConfig) interface{} {
+		return &mockWriter
+	}
+
+	queue := NewQueue(QUEUE_MODE_PUBLISHER)
+
+	msg := QueueMessage{
+		WebHookUrl: "http://localhost:8888",
+		Data:       "hello, world",
+	}
+
+	queue.Publish(ContextWithRequestId(context.TODO(), "REQ-001"), msg)
+	assert.Equal(t, "REQ-001", RequestIdFromHeaders(mockWriter.result.Headers), "Check request id header")
+
+	queue.Publish(context.TODO(), msg)
+	assert.Equal(t, "", RequestIdFromHeaders(mockWriter.result.Headers), "No request id header")
 }
 
 type MockQueueMessageConsumer struct {
@@ -159,5 +193,52 @@ func TestSubscribe(t *testing.T) {
 	assert.Equal(t, string(data), string(out), "Check data")
 }
 
+func TestSubscribeWithRequestId(t *testing.T) {
+	qmsg := QueueMessage{WebHookUrl: "abcd", Data: "mnop"}
+	data, _ := json.Marshal(qmsg)
+	msg := kafka.Message{Value: []byte(data), Headers: []kafka.Header{{Key: REQUEST_ID_HEADER, Value: []byte("REQ-001")}}}
+
+	mockReader := MockKafkaReader{}
+	mockReader.msgs = []kafka.Message{msg}
+
+	CreateReader = func(config kafka.ReaderConfig) interface{} {
+		return &mockReader
+	}
+
+	queue := NewQueue(QUEUE_MODE_SUBSCRIBER)
+	consumer := NewMockQueueMessageConsumer()
+
+	queue.Subscribe(context.TODO(), consumer.(QueueMessageConsumer))
+
+	cons := consumer.(*MockQueueMessageConsumer)
+	assert.Equal(t, "REQ-001", cons.msgs[0].RequestId, "Check request id")
+}
+
+func TestSubscribeEnvelope(t *testing.T) {
+	t.Setenv(EVENT_ENVELOPE_ENABLED, "true")
+	qmsg := QueueMessage{WebHookUrl: "abcd", Data: "mnop", Event: NOTIFICATION_EVENT_REFUND}
+	data, _ := json.Marshal(qmsg)
+	env := NewEventEnvelope(ContextWithRequestId(context.TODO(), "REQ-001"), EVENT_TYPE_CREATED, PAYLOAD_TYPE_QUEUE_MESSAGE, QUEUE_SCHEMA_VERSION)
+	msg, _ := env.Message(nil, CONTENT_TYPE_JSON, data)
+
+	mockReader := MockKafkaReader{}
+	mockReader.msgs = []kafka.Message{msg}
+
+	CreateReader = func(config kafka.ReaderConfig) interface{} {
+		return &mockReader
+	}
+
+	queue := NewQueue(QUEUE_MODE_SUBSCRIBER)
+	consumer := NewMockQueueMessageConsumer()
+
+	queue.Subscribe(context.TODO(), consumer.(QueueMessageConsumer))
+
+	cons := consumer.(*MockQueueMessageConsumer)
+	assert.Equal(t, qmsg.Data, cons.msgs[0].Data, "Check data")
+	assert.Equal(t, qmsg.Event, cons.msgs[0].Event, "Check event")
+	assert.Equal(t, "REQ-001", cons.msgs[0].RequestId, "Check request id")
+	assert.Equal(t, env.EventID, cons.msgs[0].EventId, "Check event id")
+}
+
 func TestClose(t *testing.T) {
 }
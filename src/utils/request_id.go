@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+)
+
+const REQUEST_ID_HEADER string = "X-Request-ID"
+const REQUEST_ID_LOCALS string = "requestId"
+
+type requestIdKey struct{}
+
+func NewRequestId() string {
+	return uuid.NewString()
+}
+
+func ContextWithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+func RequestIdFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// RequestIdHeaders returns the kafka headers carrying the request id stored in ctx, if any.
+func RequestIdHeaders(ctx context.Context) []kafka.Header {
+	id := RequestIdFromContext(ctx)
+	if id == "" {
+		return nil
+	}
+	return []kafka.Header{{Key: REQUEST_ID_HEADER, Value: []byte(id)}}
+}
+
+func RequestIdFromHeaders(headers []kafka.Header) string {
+	for _, h := range headers {
+		if h.Key == REQUEST_ID_HEADER {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
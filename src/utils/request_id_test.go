@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestIdContext(t *testing.T) {
+	assert.Equal(t, "", RequestIdFromContext(context.TODO()), "No request id")
+	assert.Nil(t, RequestIdHeaders(context.TODO()), "No header without request id")
+
+	ctx := ContextWithRequestId(context.TODO(), "REQ-001")
+	assert.Equal(t, "REQ-001", RequestIdFromContext(ctx), "Request id from context")
+
+	headers := RequestIdHeaders(ctx)
+	assert.Equal(t, []kafka.Header{{Key: REQUEST_ID_HEADER, Value: []byte("REQ-001")}}, headers, "Request id header")
+	assert.Equal(t, "REQ-001", RequestIdFromHeaders(headers), "Request id from headers")
+}
+
+func TestNewRequestId(t *testing.T) {
+	id := NewRequestId()
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`, id)
+	assert.NotEqual(t, id, NewRequestId(), "Unique request id")
+}